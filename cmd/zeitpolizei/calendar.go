@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/calendar"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// runCalendar implements the calendar subcommand, which imports the
+// configured calendars right away instead of waiting for their refresh:
+//
+//	zeitpolizei [-config file] calendar import [name]
+func runCalendar(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "import" || len(args) > 2 {
+		return fmt.Errorf("usage: zeitpolizei calendar import [name]")
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	loc, err := cfg.Location()
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.Open(cfg.Database.Driver, cfg.Database.Source())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// The enforcer only works out the time zones of devices here, so it
+	// needs no network backend
+	importer, err := calendar.New(cfg.Calendars, store, enforcer.New(store, nil, loc))
+	if err != nil {
+		return err
+	}
+	if len(importer.Calendars()) == 0 {
+		return fmt.Errorf("no calendars configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	n, err := importer.Import(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d schedule exception(s)\n", n)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/report"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// runExport implements the export subcommand, which writes block usage
+// records as CSV or JSON to a file or stdout:
+//
+//	zeitpolizei [-config file] export [-format csv|json] [-from date] [-to date] [-mac mac] [-o file]
+func runExport(cfg *config.Config, args []string) error {
+	loc, err := cfg.Location()
+	if err != nil {
+		return err
+	}
+	today := time.Now().In(loc)
+
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", report.FormatCSV, "Output format, csv or json")
+	from := fs.String("from", today.AddDate(0, 0, -30).Format("2006-01-02"), "First date (YYYY-MM-DD)")
+	to := fs.String("to", today.Format("2006-01-02"), "Last date (YYYY-MM-DD)")
+	mac := fs.String("mac", "", "Only export this device")
+	output := fs.String("o", "", "Output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: zeitpolizei export [-format csv|json] [-from date] [-to date] [-mac mac] [-o file]")
+	}
+
+	filter := report.ExportFilter{MAC: strings.ToLower(*mac), From: *from, To: *to}
+	if err := report.ValidateExport(*format, filter); err != nil {
+		return err
+	}
+
+	// Exports only read, so an outdated database is reported rather than
+	// migrated behind the back of the running server
+	store, err := storage.OpenExisting(cfg.Database.Driver, cfg.Database.Source())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *output == "" {
+		return report.Export(os.Stdout, store, *format, filter)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := report.Export(f, store, *format, filter); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -8,13 +8,21 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // Time zones on systems without a zoneinfo database, like the UDM
 
 	"github.com/nadilas/zeitpolizei/internal/api"
+	"github.com/nadilas/zeitpolizei/internal/bus"
+	"github.com/nadilas/zeitpolizei/internal/calendar"
 	"github.com/nadilas/zeitpolizei/internal/config"
 	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/mqtt"
+	"github.com/nadilas/zeitpolizei/internal/network"
+	"github.com/nadilas/zeitpolizei/internal/notify"
 	"github.com/nadilas/zeitpolizei/internal/storage"
 	"github.com/nadilas/zeitpolizei/internal/tracker"
 	"github.com/nadilas/zeitpolizei/internal/unifi"
+	"github.com/nadilas/zeitpolizei/internal/webhook"
 )
 
 var (
@@ -33,53 +41,125 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Subcommands that do not need a configuration
+	if args := flag.Args(); len(args) > 0 && args[0] == "hash-password" {
+		if err := runHashPassword(args[1:]); err != nil {
+			log.Fatalf("hash-password: %v", err)
+		}
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Run subcommands
+	if args := flag.Args(); len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(cfg, args[1:])
+		case "user":
+			err = runUser(cfg, args[1:])
+		case "notify":
+			err = runNotify(cfg, args[1:])
+		case "calendar":
+			err = runCalendar(cfg, args[1:])
+		case "export":
+			err = runExport(cfg, args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	}
+
+	// Resolve the time zone of the schedules
+	loc, err := cfg.Location()
+	if err != nil {
+		log.Fatalf("Invalid timezone: %v", err)
+	}
+	log.Printf("Using time zone %s", loc)
+
 	// Initialize storage
-	store, err := storage.NewSQLite(cfg.Database.Path)
+	store, err := storage.Open(cfg.Database.Driver, cfg.Database.Source())
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 	defer store.Close()
 
-	// Initialize UniFi client
-	unifiClient, err := unifi.NewClient(unifi.Config{
-		BaseURL:  cfg.UniFi.URL,
-		Username: cfg.UniFi.Username,
-		Password: cfg.UniFi.Password,
-		Site:     cfg.UniFi.Site,
-		IsUDM:    cfg.UniFi.IsUDM,
-		Insecure: cfg.UniFi.Insecure,
-	})
+	// Create the first admin account on a fresh database
+	if err := bootstrapAdmin(cfg, store); err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+
+	// Initialize network backend
+	backend, err := newNetworkBackend(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize UniFi client: %v", err)
+		log.Fatalf("Failed to initialize network backend: %v", err)
 	}
 
-	// Login to UniFi controller
-	if err := unifiClient.Login(); err != nil {
-		log.Fatalf("Failed to login to UniFi controller: %v", err)
+	// Login to the network controller
+	if err := backend.Login(); err != nil {
+		log.Fatalf("Failed to login to %s network backend: %v", cfg.Network.Backend, err)
 	}
-	log.Println("Successfully connected to UniFi controller")
+	log.Printf("Successfully connected to %s network backend", cfg.Network.Backend)
 
 	// Initialize enforcer
-	enf := enforcer.New(store, unifiClient)
+	enf := enforcer.New(store, backend, loc)
+
+	// Initialize notifications
+	notifier, err := notify.New(cfg.Notify, store)
+	if err != nil {
+		log.Fatalf("Failed to initialize notifications: %v", err)
+	}
+	enf.AddHandler(notifier.Handle)
+
+	// Initialize outgoing webhooks
+	webhooks := webhook.New(store, cfg.Webhooks.RetentionDays)
+	enf.AddHandler(webhooks.Handle)
+
+	// Initialize holiday calendar imports
+	calendars, err := calendar.New(cfg.Calendars, store, enf)
+	if err != nil {
+		log.Fatalf("Failed to initialize calendars: %v", err)
+	}
 
 	// Initialize tracker
-	track := tracker.New(store, unifiClient, enf, cfg.Tracker.PollInterval)
+	track := tracker.New(store, backend, enf, cfg.Tracker.PollInterval, cfg.Tracker.Activity, cfg.Tracker.Retention)
+
+	// Initialize MQTT publishing
+	publisher := mqtt.New(cfg.MQTT, store, enf)
+	if publisher != nil {
+		enf.AddHandler(func(*storage.Event) { publisher.Refresh() })
+		track.AddHandler(func(time.Time) { publisher.Refresh() })
+	}
+
+	// Initialize live updates for the API stream
+	events := bus.New(bus.DefaultHistory)
+	enf.AddHandler(func(event *storage.Event) { events.Publish(event.Type, event) })
+	track.AddHandler(tracker.PublishUsage(store, enf, events))
 
 	// Setup context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start tracker
+	// Start tracker, notification and webhook delivery, calendar imports and
+	// MQTT publishing
 	go track.Start(ctx)
+	go notifier.Start(ctx)
+	go webhooks.Start(ctx)
+	go calendars.Start(ctx)
+	if publisher != nil {
+		go publisher.Start(ctx)
+	}
 
 	// Initialize and start API server
-	server := api.NewServer(cfg, store, unifiClient, enf)
+	server := api.NewServer(cfg, store, backend, enf, track, events)
 
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
@@ -98,3 +178,35 @@ func main() {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// newNetworkBackend creates the network backend selected in the configuration
+func newNetworkBackend(cfg *config.Config) (network.Backend, error) {
+	switch cfg.Network.Backend {
+	case "", "unifi":
+		client, err := unifi.NewClient(unifi.Config{
+			BaseURL:  cfg.UniFi.URL,
+			Username: cfg.UniFi.Username,
+			Password: cfg.UniFi.Password,
+			Site:     cfg.UniFi.Site,
+			IsUDM:    cfg.UniFi.IsUDM,
+			Insecure: cfg.UniFi.Insecure,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case "memory":
+		var clients []network.ClientInfo
+		for _, c := range cfg.Network.Clients {
+			clients = append(clients, network.ClientInfo{
+				MAC:      c.MAC,
+				Name:     c.Name,
+				Hostname: c.Hostname,
+				IP:       c.IP,
+			})
+		}
+		return network.NewMemory(clients...), nil
+	default:
+		return nil, fmt.Errorf("unknown network backend %q", cfg.Network.Backend)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// runMigrate implements the migrate subcommand:
+//
+//	zeitpolizei [-config file] migrate up [-to version]
+//	zeitpolizei [-config file] migrate down [-to version]
+//	zeitpolizei [-config file] migrate status
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: zeitpolizei migrate <up|down|status> [-to version]")
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	to := fs.Int("to", -1, "Target schema version (up: latest, down: previous)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	m, err := storage.OpenMigrator(cfg.Database.Driver, cfg.Database.Source())
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch action {
+	case "up":
+		if err := m.Up(*to); err != nil {
+			return err
+		}
+	case "down":
+		target := *to
+		if target < 0 {
+			current, err := m.Version()
+			if err != nil {
+				return err
+			}
+			target = current - 1
+		}
+		if err := m.Down(target); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate action %q (want up, down or status)", action)
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version %d (latest %d)\n\n", version, m.Latest())
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
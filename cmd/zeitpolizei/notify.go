@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/notify"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// runNotify implements the notify subcommand, which sends a sample warning
+// to check the channel configuration:
+//
+//	zeitpolizei [-config file] notify test [channel]
+func runNotify(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "test" || len(args) > 2 {
+		return fmt.Errorf("usage: zeitpolizei notify test [channel]")
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	store, err := storage.Open(cfg.Database.Driver, cfg.Database.Source())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	dispatcher, err := notify.New(cfg.Notify, store)
+	if err != nil {
+		return err
+	}
+	if len(dispatcher.Channels()) == 0 {
+		return fmt.Errorf("no notify channels configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := dispatcher.Test(ctx, name); err != nil {
+		return err
+	}
+
+	fmt.Println("Test notification sent")
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nadilas/zeitpolizei/internal/auth"
+)
+
+// runHashPassword implements the hash-password subcommand. It reads a
+// password from the arguments or the first line of stdin and prints its
+// bcrypt hash for server.password_hash:
+//
+//	zeitpolizei hash-password
+//	echo "secret" | zeitpolizei hash-password
+func runHashPassword(args []string) error {
+	var password string
+	if len(args) > 0 {
+		password = args[0]
+	} else {
+		var err error
+		if password, err = readPassword(); err != nil {
+			return err
+		}
+	}
+
+	if password == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hash)
+	return nil
+}
+
+// readPassword prompts for a password on stderr and reads the first line of stdin
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
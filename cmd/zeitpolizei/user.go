@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// runUser implements the user subcommand. Passwords are read from stdin:
+//
+//	zeitpolizei [-config file] user add [-role parent] <username>
+//	zeitpolizei [-config file] user list
+//	zeitpolizei [-config file] user passwd <username>
+//	zeitpolizei [-config file] user role <username> <role>
+//	zeitpolizei [-config file] user delete <username>
+func runUser(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: zeitpolizei user <add|list|passwd|role|delete> [args]")
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	role := fs.String("role", auth.RoleParent, "Role of the new user (admin, parent or viewer)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store, err := storage.Open(cfg.Database.Driver, cfg.Database.Source())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch action {
+	case "add":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: zeitpolizei user add [-role role] <username>")
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		user, err := auth.NewUser(fs.Arg(0), password, *role)
+		if err != nil {
+			return err
+		}
+		if err := store.CreateUser(user); err != nil {
+			return err
+		}
+		fmt.Printf("Created %s %s\n", user.Role, user.Username)
+		return nil
+
+	case "list":
+		users, err := store.ListUsers()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tROLE\tCREATED")
+		for _, user := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", user.Username, user.Role, user.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+
+	case "passwd", "role":
+		want := 1
+		if action == "role" {
+			want = 2
+		}
+		if fs.NArg() != want {
+			return fmt.Errorf("usage: zeitpolizei user passwd <username> | user role <username> <role>")
+		}
+		user, err := store.GetUser(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return fmt.Errorf("user %q not found", fs.Arg(0))
+		}
+
+		// A new password or a less privileged role signs the user out
+		// everywhere, so open sessions do not outlive the change
+		logout := true
+		if action == "role" {
+			if !auth.ValidRole(fs.Arg(1)) {
+				return fmt.Errorf("unknown role %q (want admin, parent or viewer)", fs.Arg(1))
+			}
+			logout = !auth.HasRole(fs.Arg(1), user.Role)
+			user.Role = fs.Arg(1)
+		} else {
+			password, err := readPassword()
+			if err != nil {
+				return err
+			}
+			if password == "" {
+				return fmt.Errorf("password must not be empty")
+			}
+			if user.PasswordHash, err = auth.HashPassword(password); err != nil {
+				return err
+			}
+		}
+
+		if err := store.UpdateUser(user); err != nil {
+			return err
+		}
+		if logout {
+			if err := store.RevokeUserSessions(user.Username, time.Now()); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Updated %s\n", user.Username)
+		return nil
+
+	case "delete":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: zeitpolizei user delete <username>")
+		}
+		if err := store.DeleteUser(fs.Arg(0)); err != nil {
+			return err
+		}
+		if err := store.RevokeUserSessions(fs.Arg(0), time.Now()); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s\n", fs.Arg(0))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown user action %q (want add, list, passwd, role or delete)", action)
+	}
+}
+
+// bootstrapAdmin creates the first admin from server.username and
+// server.password_hash (or the deprecated plaintext server.password) when the
+// database has no users yet
+func bootstrapAdmin(cfg *config.Config, store storage.Store) error {
+	hash := cfg.Server.PasswordHash
+	if hash == "" && cfg.Server.Password != "" {
+		log.Println("Warning: server.password is deprecated, store a bcrypt hash in server.password_hash instead (zeitpolizei hash-password)")
+		var err error
+		if hash, err = auth.HashPassword(cfg.Server.Password); err != nil {
+			return err
+		}
+	}
+
+	created, err := auth.Bootstrap(store, cfg.Server.Username, hash)
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("Created admin user %s from the configuration", cfg.Server.Username)
+		return nil
+	}
+
+	users, err := store.ListUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		log.Println("Warning: no users exist and none is configured, login is disabled (create one with: zeitpolizei user add -role admin <username>)")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+	"github.com/nadilas/zeitpolizei/internal/tracker"
+)
+
+// ActivityResponse lists the recent poll intervals of a device and the rules
+// they were counted by
+type ActivityResponse struct {
+	Rules   storage.ActivityRules    `json:"rules"`
+	Samples []tracker.ActivitySample `json:"samples"`
+}
+
+// getActivity returns the recent poll intervals of a device with whether
+// they counted as active time and why
+func (s *Server) getActivity(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	config, err := s.store.GetDeviceConfig(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	samples := s.tracker.Activity(mac)
+	if samples == nil {
+		samples = []tracker.ActivitySample{}
+	}
+
+	c.JSON(http.StatusOK, ActivityResponse{
+		Rules:   s.tracker.ActivityRules(config),
+		Samples: samples,
+	})
+}
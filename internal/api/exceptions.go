@@ -0,0 +1,190 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// ExceptionRequest represents a request to create or update a schedule exception
+type ExceptionRequest struct {
+	MAC            string                `json:"mac"` // empty = all devices
+	Name           string                `json:"name"`
+	StartDate      string                `json:"start_date" binding:"required"`
+	EndDate        string                `json:"end_date"` // default StartDate
+	Mode           string                `json:"mode" binding:"required,oneof=schedule unlimited blocked"`
+	DailySchedules []storage.DaySchedule `json:"daily_schedules"` // required in "schedule" mode
+}
+
+// listExceptions returns the schedule exceptions, optionally only those of a
+// device (including global ones) or covering a date
+func (s *Server) listExceptions(c *gin.Context) {
+	filter := storage.ExceptionFilter{
+		MAC:  strings.ToLower(c.Query("mac")),
+		Date: c.Query("date"),
+	}
+	if filter.Date != "" && !validDate(filter.Date) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	exceptions, err := s.store.ListScheduleExceptions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if exceptions == nil {
+		exceptions = []*storage.ScheduleException{}
+	}
+
+	c.JSON(http.StatusOK, exceptions)
+}
+
+// createException adds a schedule exception
+func (s *Server) createException(c *gin.Context) {
+	var req ExceptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	exception := &storage.ScheduleException{}
+	if !applyExceptionRequest(c, exception, &req) {
+		return
+	}
+
+	if err := s.enforcer.SaveScheduleException(exception, currentUser(c).Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, exception)
+}
+
+// getException returns a schedule exception
+func (s *Server) getException(c *gin.Context) {
+	exception, ok := s.loadException(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, exception)
+}
+
+// updateException changes a schedule exception
+func (s *Server) updateException(c *gin.Context) {
+	exception, ok := s.loadException(c)
+	if !ok || !editableException(c, exception) {
+		return
+	}
+
+	var req ExceptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	if !applyExceptionRequest(c, exception, &req) {
+		return
+	}
+
+	if err := s.enforcer.SaveScheduleException(exception, currentUser(c).Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, exception)
+}
+
+// deleteException removes a schedule exception
+func (s *Server) deleteException(c *gin.Context) {
+	exception, ok := s.loadException(c)
+	if !ok || !editableException(c, exception) {
+		return
+	}
+
+	if err := s.enforcer.DeleteScheduleException(exception, currentUser(c).Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// loadException looks up the schedule exception of the :id parameter,
+// responding with 404 when there is none
+func (s *Server) loadException(c *gin.Context) (*storage.ScheduleException, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "exception not found"})
+		return nil, false
+	}
+
+	exception, err := s.store.GetScheduleException(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if exception == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "exception not found"})
+		return nil, false
+	}
+	return exception, true
+}
+
+// editableException rejects changes to an exception imported from a
+// calendar, which would be undone by the next refresh
+func editableException(c *gin.Context, exception *storage.ScheduleException) bool {
+	if exception.Source != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "exception is imported from calendar " + strconv.Quote(exception.Source)})
+		return false
+	}
+	return true
+}
+
+// applyExceptionRequest validates req and copies it onto exception
+func applyExceptionRequest(c *gin.Context, exception *storage.ScheduleException, req *ExceptionRequest) bool {
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+	if !validDate(req.StartDate) || !validDate(req.EndDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_date and end_date must be YYYY-MM-DD"})
+		return false
+	}
+	if req.EndDate < req.StartDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date is before start_date"})
+		return false
+	}
+
+	schedules := req.DailySchedules
+	if req.Mode == storage.ExceptionSchedule {
+		if len(schedules) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "daily_schedules is required in schedule mode"})
+			return false
+		}
+		if err := storage.ValidateSchedules(schedules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	} else {
+		schedules = nil
+	}
+
+	exception.MAC = strings.ToLower(req.MAC)
+	exception.Name = req.Name
+	exception.StartDate = req.StartDate
+	exception.EndDate = req.EndDate
+	exception.Mode = req.Mode
+	exception.DailySchedules = schedules
+	return true
+}
+
+// validDate reports whether s is a YYYY-MM-DD date
+func validDate(s string) bool {
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
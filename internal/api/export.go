@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/report"
+)
+
+// maxExportDays limits the dates of an export through the API. The command
+// line export has no limit.
+const maxExportDays = 366
+
+// exportUsage streams the block usage records of all devices, or of the mac
+// parameter, from one date to another (default the last 30 days) as CSV or
+// JSON
+func (s *Server) exportUsage(c *gin.Context) {
+	format := c.DefaultQuery("format", report.FormatCSV)
+	today := time.Now().In(s.enforcer.Location(nil))
+	filter := report.ExportFilter{
+		MAC:  strings.ToLower(c.Query("mac")),
+		From: c.DefaultQuery("from", today.AddDate(0, 0, -30).Format("2006-01-02")),
+		To:   c.DefaultQuery("to", today.Format("2006-01-02")),
+	}
+	if err := report.ValidateExport(format, filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, _ := time.Parse("2006-01-02", filter.From)
+	to, _ := time.Parse("2006-01-02", filter.To)
+	if to.Sub(from) >= maxExportDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("exports are limited to %d days", maxExportDays)})
+		return
+	}
+
+	// Long exports outlive the server's write timeout
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming not supported"})
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == report.FormatJSON {
+		contentType = "application/json; charset=utf-8"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="zeitpolizei-usage-%s-%s.%s"`, filter.From, filter.To, format))
+
+	if err := report.Export(c.Writer, s.store, format, filter); err != nil {
+		// Once rows are on their way the status cannot change anymore
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error exporting usage: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/network"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// slowStore takes a while to read the usage of each day
+type slowStore struct {
+	storage.Store
+	delay time.Duration
+}
+
+func (s *slowStore) GetAllUsageForDate(date string) (map[string][]*storage.BlockUsage, error) {
+	time.Sleep(s.delay)
+	return s.Store.GetAllUsageForDate(date)
+}
+
+// TestExportUsage streams an export that takes longer than the server's
+// write timeout
+func TestExportUsage(t *testing.T) {
+	store := storage.NewMemory()
+	t.Cleanup(func() { store.Close() })
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		date := day.AddDate(0, 0, i).Format("2006-01-02")
+		if _, err := store.GetOrCreateBlockUsage("aa:bb:cc:dd:ee:ff", date, 0, "00:00", "24:00", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.Server.SessionSecret = "secret"
+	slow := &slowStore{Store: store, delay: 20 * time.Millisecond}
+	s := NewServer(cfg, slow, nil, enforcer.New(slow, network.NewMemory(), time.UTC), nil, nil)
+
+	router := gin.New()
+	router.GET("/export", s.exportUsage)
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/export?from=2024-03-01&to=2024-03-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading export: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 11 {
+		t.Errorf("export has %d lines, want a header and 10 rows:\n%s", lines, body)
+	}
+
+	for _, query := range []string{
+		"from=2023-01-01&to=2024-03-10",
+		"from=2024-03-10&to=2024-03-01",
+		"from=2024-03-01&to=2024-03-10&format=xml",
+	} {
+		resp, err := http.Get(server.URL + "/export?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("export?%s status = %d, want %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
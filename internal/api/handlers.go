@@ -1,12 +1,15 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
 	"github.com/nadilas/zeitpolizei/internal/storage"
 )
 
@@ -17,9 +20,7 @@ type LoginRequest struct {
 }
 
 // LoginResponse represents a login response
-type LoginResponse struct {
-	Token string `json:"token"`
-}
+type LoginResponse = auth.TokenPair
 
 // login handles user authentication
 func (s *Server) login(c *gin.Context) {
@@ -29,18 +30,62 @@ func (s *Server) login(c *gin.Context) {
 		return
 	}
 
-	if req.Username != s.config.Server.Username || req.Password != s.config.Server.Password {
+	user, err := auth.Authenticate(s.store, req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	token := generateToken(req.Username, req.Password)
-	c.JSON(http.StatusOK, LoginResponse{Token: token})
+	tokens, err := s.sessions.Login(user.Username, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RefreshRequest represents a token refresh request
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// refresh exchanges a refresh token for a new token pair
+func (s *Server) refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	tokens, err := s.sessions.Refresh(req.RefreshToken, time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// logout revokes the current session
+func (s *Server) logout(c *gin.Context) {
+	session := c.MustGet("session").(*storage.Session)
+
+	if err := s.sessions.Logout(session.ID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "logged out"})
 }
 
-// listDevices returns all known devices from UniFi
+// listDevices returns all known devices from the network backend
 func (s *Server) listDevices(c *gin.Context) {
-	clients, err := s.unifi.GetAllKnownClients()
+	clients, err := s.network.GetAllKnownClients()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -95,10 +140,12 @@ func (s *Server) listManagedDevices(c *gin.Context) {
 
 // DeviceConfigRequest represents a device configuration request
 type DeviceConfigRequest struct {
-	Name           string                `json:"name"`
-	Enabled        bool                  `json:"enabled"`
-	BlockOutside   bool                  `json:"block_outside_time_blocks"`
-	DailySchedules []storage.DaySchedule `json:"daily_schedules"`
+	Name           string                 `json:"name"`
+	Enabled        bool                   `json:"enabled"`
+	BlockOutside   bool                   `json:"block_outside_time_blocks"`
+	DailySchedules []storage.DaySchedule  `json:"daily_schedules"`
+	Timezone       string                 `json:"timezone"` // IANA zone, empty = configured default
+	Activity       *storage.ActivityRules `json:"activity"` // nil = configured default
 }
 
 // saveDeviceConfig creates or updates a device configuration
@@ -110,6 +157,21 @@ func (s *Server) saveDeviceConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
 		return
 	}
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone " + strconv.Quote(req.Timezone)})
+			return
+		}
+	}
+	if err := storage.ValidateSchedules(req.DailySchedules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rules := req.Activity; rules != nil && (rules.MinBytes < 0 || rules.MinRxBytes < 0 || rules.MinTxBytes < 0 ||
+		rules.BackgroundMaxBytes < 0 || rules.BackgroundWindowMinutes < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "activity rules must not be negative"})
+		return
+	}
 
 	config := &storage.DeviceConfig{
 		MAC:            mac,
@@ -117,9 +179,11 @@ func (s *Server) saveDeviceConfig(c *gin.Context) {
 		Enabled:        req.Enabled,
 		BlockOutside:   req.BlockOutside,
 		DailySchedules: req.DailySchedules,
+		Timezone:       req.Timezone,
+		Activity:       req.Activity,
 	}
 
-	if err := s.store.SaveDeviceConfig(config); err != nil {
+	if err := s.enforcer.SaveDeviceConfig(config, currentUser(c).Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -149,14 +213,11 @@ func (s *Server) getDeviceConfig(c *gin.Context) {
 func (s *Server) deleteDeviceConfig(c *gin.Context) {
 	mac := strings.ToLower(c.Param("mac"))
 
-	if err := s.store.DeleteDeviceConfig(mac); err != nil {
+	if err := s.enforcer.DeleteDeviceConfig(mac, currentUser(c).Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Unblock the device if it was blocked
-	s.enforcer.ManualUnblock(mac)
-
 	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 }
 
@@ -164,7 +225,7 @@ func (s *Server) deleteDeviceConfig(c *gin.Context) {
 func (s *Server) blockDevice(c *gin.Context) {
 	mac := strings.ToLower(c.Param("mac"))
 
-	if err := s.enforcer.ManualBlock(mac); err != nil {
+	if err := s.enforcer.ManualBlock(mac, currentUser(c).Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -176,7 +237,7 @@ func (s *Server) blockDevice(c *gin.Context) {
 func (s *Server) unblockDevice(c *gin.Context) {
 	mac := strings.ToLower(c.Param("mac"))
 
-	if err := s.enforcer.ManualUnblock(mac); err != nil {
+	if err := s.enforcer.ManualUnblock(mac, currentUser(c).Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -199,30 +260,11 @@ func (s *Server) addBonusTime(c *gin.Context) {
 		return
 	}
 
-	// Get device config
-	config, err := s.store.GetDeviceConfig(mac)
-	if err != nil || config == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
-		return
-	}
-
-	// Find current time block
-	now := time.Now()
-	activeBlock, blockIndex := s.enforcer.GetActiveTimeBlock(config, now)
-	if activeBlock == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no active time block"})
-		return
-	}
-
-	date := now.Format("2006-01-02")
-	if err := s.store.AddBonusTime(mac, date, blockIndex, req.Minutes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.enforcer.AddBonusTime(mac, req.Minutes, currentUser(c).Username); err != nil {
+		bonusError(c, err)
 		return
 	}
 
-	// Re-check enforcement to potentially unblock
-	s.enforcer.CheckAndEnforce(mac, config, now)
-
 	c.JSON(http.StatusOK, gin.H{"status": "added", "minutes": req.Minutes})
 }
 
@@ -246,36 +288,29 @@ func (s *Server) addBonusData(c *gin.Context) {
 	byteLimit := storage.ByteLimit{Value: req.Amount, Unit: req.Unit}
 	bytes := byteLimit.ToBytes()
 
-	// Get device config
-	config, err := s.store.GetDeviceConfig(mac)
-	if err != nil || config == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+	if err := s.enforcer.AddBonusData(mac, bytes, currentUser(c).Username); err != nil {
+		bonusError(c, err)
 		return
 	}
 
-	// Find current time block
-	now := time.Now()
-	activeBlock, blockIndex := s.enforcer.GetActiveTimeBlock(config, now)
-	if activeBlock == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no active time block"})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{"status": "added", "bytes": bytes})
+}
 
-	date := now.Format("2006-01-02")
-	if err := s.store.AddBonusData(mac, date, blockIndex, bytes); err != nil {
+// bonusError writes the response for a failed bonus grant
+func bonusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, enforcer.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+	case errors.Is(err, enforcer.ErrNoActiveBlock):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no active time block"})
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
 	}
-
-	// Re-check enforcement to potentially unblock
-	s.enforcer.CheckAndEnforce(mac, config, now)
-
-	c.JSON(http.StatusOK, gin.H{"status": "added", "bytes": bytes})
 }
 
 // getAllUsage returns today's usage for all managed devices
 func (s *Server) getAllUsage(c *gin.Context) {
-	date := time.Now().Format("2006-01-02")
+	now := time.Now()
 
 	configs, err := s.store.GetAllDeviceConfigs()
 	if err != nil {
@@ -285,7 +320,7 @@ func (s *Server) getAllUsage(c *gin.Context) {
 
 	var summaries []storage.UsageSummary
 	for _, config := range configs {
-		summary, err := s.buildUsageSummary(config, date)
+		summary, err := s.enforcer.UsageSummary(config, now)
 		if err != nil {
 			continue
 		}
@@ -298,7 +333,7 @@ func (s *Server) getAllUsage(c *gin.Context) {
 // getDeviceUsage returns today's usage for a specific device
 func (s *Server) getDeviceUsage(c *gin.Context) {
 	mac := strings.ToLower(c.Param("mac"))
-	date := time.Now().Format("2006-01-02")
+	now := time.Now()
 
 	config, err := s.store.GetDeviceConfig(mac)
 	if err != nil {
@@ -311,7 +346,7 @@ func (s *Server) getDeviceUsage(c *gin.Context) {
 		return
 	}
 
-	summary, err := s.buildUsageSummary(config, date)
+	summary, err := s.enforcer.UsageSummary(config, now)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -320,87 +355,6 @@ func (s *Server) getDeviceUsage(c *gin.Context) {
 	c.JSON(http.StatusOK, summary)
 }
 
-// buildUsageSummary builds a usage summary for a device
-func (s *Server) buildUsageSummary(config *storage.DeviceConfig, date string) (*storage.UsageSummary, error) {
-	now := time.Now()
-	activeBlock, activeIndex := s.enforcer.GetActiveTimeBlock(config, now)
-
-	usages, err := s.store.GetBlockUsageForDate(config.MAC, date)
-	if err != nil {
-		return nil, err
-	}
-
-	summary := &storage.UsageSummary{
-		MAC:  config.MAC,
-		Name: config.Name,
-	}
-
-	// Calculate totals and build block summaries
-	for _, usage := range usages {
-		summary.TodayTotal.UsedMinutes += usage.UsedMinutes
-		summary.TodayTotal.UsedBytes += usage.UsedBytes
-
-		blockSummary := storage.BlockSummary{
-			StartTime:    usage.StartTime,
-			EndTime:      usage.EndTime,
-			UsedMinutes:  usage.UsedMinutes,
-			UsedBytes:    usage.UsedBytes,
-			LimitMinutes: usage.LimitMinutes,
-			LimitBytes:   usage.LimitBytes,
-		}
-
-		// Check if this is the active block
-		if activeBlock != nil && usage.BlockIndex == activeIndex {
-			blockSummary.Active = true
-		} else if now.Format("15:04") > usage.EndTime {
-			blockSummary.Completed = true
-		}
-
-		summary.AllBlocksToday = append(summary.AllBlocksToday, blockSummary)
-	}
-
-	// Build current block info
-	if activeBlock != nil {
-		for _, usage := range usages {
-			if usage.BlockIndex == activeIndex {
-				currentBlock := &storage.CurrentBlock{
-					StartTime:     usage.StartTime,
-					EndTime:       usage.EndTime,
-					LimitMinutes:  usage.LimitMinutes,
-					LimitBytes:    usage.LimitBytes,
-					UsedMinutes:   usage.UsedMinutes,
-					UsedBytes:     usage.UsedBytes,
-					IsBlocked:     usage.IsBlocked,
-					BlockedReason: usage.BlockedReason,
-					BonusMinutes:  usage.BonusMinutes,
-					BonusBytes:    usage.BonusBytes,
-				}
-
-				// Calculate remaining
-				if usage.LimitMinutes != nil {
-					remaining := *usage.LimitMinutes + usage.BonusMinutes - usage.UsedMinutes
-					if remaining < 0 {
-						remaining = 0
-					}
-					currentBlock.RemainingMinutes = &remaining
-				}
-				if usage.LimitBytes != nil {
-					remaining := *usage.LimitBytes + usage.BonusBytes - usage.UsedBytes
-					if remaining < 0 {
-						remaining = 0
-					}
-					currentBlock.RemainingBytes = &remaining
-				}
-
-				summary.CurrentBlock = currentBlock
-				break
-			}
-		}
-	}
-
-	return summary, nil
-}
-
 // getUsageHistory returns historical usage for a device
 func (s *Server) getUsageHistory(c *gin.Context) {
 	mac := strings.ToLower(c.Param("mac"))
@@ -413,7 +367,15 @@ func (s *Server) getUsageHistory(c *gin.Context) {
 		}
 	}
 
-	history, err := s.store.GetUsageHistory(mac, days)
+	// Days are counted in the device's time zone
+	config, err := s.store.GetDeviceConfig(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	since := time.Now().In(s.enforcer.Location(config)).AddDate(0, 0, -days)
+
+	history, err := s.store.GetUsageHistory(mac, since.Format("2006-01-02"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -434,9 +396,9 @@ type StatusResponse struct {
 
 // getStatus returns system health and status
 func (s *Server) getStatus(c *gin.Context) {
-	// Check UniFi connection
-	_, unifiErr := s.unifi.GetClients()
-	unifiConnected := unifiErr == nil
+	// Check network backend connection
+	_, networkErr := s.network.GetClients()
+	networkConnected := networkErr == nil
 
 	// Count managed devices
 	configs, _ := s.store.GetAllDeviceConfigs()
@@ -452,15 +414,83 @@ func (s *Server) getStatus(c *gin.Context) {
 	}
 
 	status := "ok"
-	if !unifiConnected {
+	if !networkConnected {
 		status = "degraded"
 	}
 
 	c.JSON(http.StatusOK, StatusResponse{
 		Status:         status,
-		UniFiConnected: unifiConnected,
+		UniFiConnected: networkConnected,
 		ManagedDevices: managedCount,
 		BlockedDevices: blockedCount,
 		ServerTime:     time.Now(),
 	})
 }
+
+// getDriftEvents returns recent reconciliation drift events
+func (s *Server) getDriftEvents(c *gin.Context) {
+	mac := strings.ToLower(c.Query("mac"))
+
+	// Default to 100 events
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	events, err := s.store.GetDriftEvents(mac, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// getEvents returns the audit log, newest first. It can be filtered by
+// device (mac), event type and time range (from/to as RFC 3339 or YYYY-MM-DD).
+func (s *Server) getEvents(c *gin.Context) {
+	filter := storage.EventFilter{
+		MAC:   strings.ToLower(c.Query("mac")),
+		Type:  c.Query("type"),
+		Limit: 100,
+	}
+
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			filter.Limit = parsed
+		}
+	}
+
+	var err error
+	loc := s.enforcer.Location(nil)
+	if filter.From, err = parseTimeQuery(c.Query("from"), loc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from: " + err.Error()})
+		return
+	}
+	if filter.To, err = parseTimeQuery(c.Query("to"), loc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to: " + err.Error()})
+		return
+	}
+
+	events, err := s.store.ListEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// parseTimeQuery parses an RFC 3339 timestamp or a YYYY-MM-DD date in loc,
+// returning the zero time for an empty value
+func parseTimeQuery(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
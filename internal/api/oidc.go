@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+// oidcCookie holds the state, nonce and PKCE verifier of a login in progress
+const oidcCookie = "zeitpolizei_oidc"
+
+// oidcLoginTimeout bounds how long a user may take at the identity provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// errNoRole is returned when an identity maps to no Zeitpolizei role
+var errNoRole = errors.New("no zeitpolizei role for this account")
+
+// errAccountTaken is returned when the username of an identity belongs to a
+// local account or to another identity
+var errAccountTaken = errors.New("username is taken by another account")
+
+// oidcProvider runs the OpenID Connect authorization-code flow. Provider
+// discovery happens on first use, so Zeitpolizei still starts while the
+// identity provider is unreachable.
+type oidcProvider struct {
+	config config.OIDCConfig
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// newOIDCProvider returns nil when OIDC login is not configured
+func newOIDCProvider(cfg config.OIDCConfig) *oidcProvider {
+	if cfg.Issuer == "" {
+		return nil
+	}
+	return &oidcProvider{config: cfg}
+}
+
+// discover fetches the provider metadata once it is reachable
+func (p *oidcProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.config.Scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	return p.oauth2, p.verifier, nil
+}
+
+// role maps the role claim of an ID token to the most privileged configured
+// role, falling back to the default role
+func (p *oidcProvider) role(claims map[string]interface{}) (string, error) {
+	var values []string
+	switch v := claims[p.config.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	role := ""
+	for _, value := range values {
+		mapped, ok := p.config.RoleMapping[value]
+		if !ok || !auth.ValidRole(mapped) {
+			continue
+		}
+		if role == "" || !auth.HasRole(role, mapped) {
+			role = mapped
+		}
+	}
+
+	if role == "" {
+		role = p.config.DefaultRole
+	}
+	if !auth.ValidRole(role) {
+		return "", errNoRole
+	}
+	return role, nil
+}
+
+// AuthConfigResponse tells the login page which login methods are available
+type AuthConfigResponse struct {
+	OIDC bool `json:"oidc"`
+}
+
+// authConfig returns the available login methods
+func (s *Server) authConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, AuthConfigResponse{OIDC: s.oidc != nil})
+}
+
+// oidcLogin redirects the browser to the identity provider
+func (s *Server) oidcLogin(c *gin.Context) {
+	if s.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return
+	}
+
+	oauth2Config, _, err := s.oidc.discover(c.Request.Context())
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	state, err := auth.RandomID(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nonce, err := auth.RandomID(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, strings.Join([]string{state, nonce, verifier}, "."),
+		int(oidcLoginTimeout.Seconds()), "/api/v1/auth/oidc", "", c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)))
+}
+
+// oidcCallback completes the login: it exchanges the code, verifies the ID
+// token, creates or updates the user with the role mapped from its claims and
+// hands a session to the web UI in the URL fragment of /login
+func (s *Server) oidcCallback(c *gin.Context) {
+	if s.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return
+	}
+
+	user, err := s.oidcAuthenticate(c)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		message := "login failed"
+		if errors.Is(err, errNoRole) || errors.Is(err, errAccountTaken) {
+			message = err.Error()
+		}
+		c.Redirect(http.StatusFound, "/login#"+url.Values{"error": {message}}.Encode())
+		return
+	}
+
+	tokens, err := s.sessions.Login(user.Username, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("User %s logged in via OIDC as %s", user.Username, user.Role)
+	c.Redirect(http.StatusFound, "/login#"+url.Values{
+		"token":         {tokens.AccessToken},
+		"refresh_token": {tokens.RefreshToken},
+		"expires_at":    {tokens.ExpiresAt.Format(time.RFC3339)},
+	}.Encode())
+}
+
+// oidcAuthenticate validates the callback request and returns the logged in user
+func (s *Server) oidcAuthenticate(c *gin.Context) (*storage.User, error) {
+	cookie, err := c.Cookie(oidcCookie)
+	if err != nil {
+		return nil, fmt.Errorf("missing login state cookie")
+	}
+	c.SetCookie(oidcCookie, "", -1, "/api/v1/auth/oidc", "", c.Request.TLS != nil, true)
+
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 3 || c.Query("state") != parts[0] {
+		return nil, fmt.Errorf("state mismatch")
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	if e := c.Query("error"); e != "" {
+		return nil, fmt.Errorf("identity provider returned %s: %s", e, c.Query("error_description"))
+	}
+
+	ctx := c.Request.Context()
+	oauth2Config, idVerifier, err := s.oidc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth2Config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	if idToken.Subject == "" {
+		return nil, fmt.Errorf("ID token has no sub claim")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	username, _ := claims[s.oidc.config.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("ID token has no %s claim", s.oidc.config.UsernameClaim)
+	}
+	role, err := s.oidc.role(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", username, err)
+	}
+
+	return s.upsertOIDCUser(idToken.Issuer, idToken.Subject, username, role)
+}
+
+// upsertOIDCUser creates a user on first login and keeps its role in sync
+// with the identity provider afterwards. Users are identified by issuer and
+// subject; the username claim only names new accounts. An identity never
+// takes over a local account with a password or an account bound to another
+// identity. Accounts created by OIDC logins before identities were recorded
+// have no password and are bound on their next login.
+func (s *Server) upsertOIDCUser(issuer, subject, username, role string) (*storage.User, error) {
+	user, err := s.store.GetUserByOIDC(issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		existing, err := s.store.GetUser(username)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing == nil {
+			user = &storage.User{Username: username, Role: role, OIDCIssuer: issuer, OIDCSubject: subject}
+			if err := s.store.CreateUser(user); err != nil {
+				if errors.Is(err, storage.ErrUserExists) {
+					return nil, fmt.Errorf("%s: %w", username, errAccountTaken)
+				}
+				return nil, err
+			}
+			log.Printf("Created %s %s from OIDC login", role, username)
+			return user, nil
+		}
+
+		if existing.PasswordHash != "" || existing.OIDCSubject != "" {
+			return nil, fmt.Errorf("%s: %w", username, errAccountTaken)
+		}
+		log.Printf("Binding %s to OIDC subject %s of %s", username, subject, issuer)
+		user = existing
+		user.OIDCIssuer, user.OIDCSubject = issuer, subject
+	} else if user.Role == role {
+		return user, nil
+	}
+
+	if user.Role != role {
+		log.Printf("Changing role of %s from %s to %s from OIDC claims", user.Username, user.Role, role)
+		user.Role = role
+	}
+	if err := s.store.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// mockIdP is a minimal OpenID Connect provider: discovery, JWKS and a token
+// endpoint that returns an ID token with the claims of the next login.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]interface{}
+	nonce  string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "code" || r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.idToken(t),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// idToken signs the claims of the current login
+func (idp *mockIdP) idToken(t *testing.T) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"aud":   "zeitpolizei",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": idp.nonce,
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newOIDCTestServer(t *testing.T, idp *mockIdP) (*Server, storage.Store) {
+	cfg := &config.Config{}
+	cfg.Server.SessionSecret = "secret"
+	cfg.Server.AccessTokenTTL = 15 * time.Minute
+	cfg.Server.RefreshTokenTTL = time.Hour
+	cfg.Server.OIDC = config.OIDCConfig{
+		Issuer:        idp.server.URL,
+		ClientID:      "zeitpolizei",
+		ClientSecret:  "client-secret",
+		RedirectURL:   "http://zeitpolizei.test/api/v1/auth/oidc/callback",
+		Scopes:        []string{"openid", "profile", "groups"},
+		UsernameClaim: "preferred_username",
+		RoleClaim:     "groups",
+		RoleMapping:   map[string]string{"family-admins": "admin", "family": "parent"},
+	}
+
+	store := storage.NewMemory()
+	t.Cleanup(func() { store.Close() })
+	return NewServer(cfg, store, nil, nil, nil, nil), store
+}
+
+// oidcLogin runs a complete browser login for the given ID token claims and
+// returns the values handed to the web UI in the /login fragment
+func oidcLogin(t *testing.T, s *Server, idp *mockIdP, claims map[string]interface{}) url.Values {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body.String())
+	}
+	authorize, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authorize.String(), idp.server.URL+"/authorize") {
+		t.Fatalf("login redirected to %s, want the provider", authorize)
+	}
+	query := authorize.Query()
+	if query.Get("code_challenge") == "" {
+		t.Error("login did not send a PKCE challenge")
+	}
+
+	idp.mu.Lock()
+	idp.claims, idp.nonce = claims, query.Get("nonce")
+	idp.mu.Unlock()
+
+	callback := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?"+url.Values{
+		"state": {query.Get("state")},
+		"code":  {"code"},
+	}.Encode(), nil)
+	for _, cookie := range rec.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, callback)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	s, store := newOIDCTestServer(t, idp)
+
+	result := oidcLogin(t, s, idp, map[string]interface{}{
+		"sub":                "kid-1",
+		"preferred_username": "mum",
+		"groups":             []string{"family", "other"},
+	})
+	if result.Get("error") != "" || result.Get("token") == "" || result.Get("refresh_token") == "" {
+		t.Fatalf("login result = %v, want tokens", result)
+	}
+	if _, err := s.sessions.Authenticate(result.Get("token"), time.Now()); err != nil {
+		t.Errorf("access token is not valid: %v", err)
+	}
+
+	user, _ := store.GetUser("mum")
+	if user == nil || user.Role != "parent" || user.OIDCIssuer != idp.server.URL || user.OIDCSubject != "kid-1" {
+		t.Fatalf("user after first login = %+v, want parent bound to kid-1", user)
+	}
+
+	// The most privileged mapped role wins and is synced on every login
+	oidcLogin(t, s, idp, map[string]interface{}{
+		"sub":                "kid-1",
+		"preferred_username": "mum",
+		"groups":             []string{"family", "family-admins"},
+	})
+	if user, _ := store.GetUser("mum"); user.Role != "admin" {
+		t.Errorf("role after promotion = %s, want admin", user.Role)
+	}
+
+	// A renamed identity still logs into its account
+	oidcLogin(t, s, idp, map[string]interface{}{
+		"sub":                "kid-1",
+		"preferred_username": "mother",
+		"groups":             []string{"family"},
+	})
+	if user, _ := store.GetUser("mum"); user.Role != "parent" {
+		t.Errorf("role after rename = %s, want parent", user.Role)
+	}
+	if user, _ := store.GetUser("mother"); user != nil {
+		t.Errorf("rename created %+v, want no new account", user)
+	}
+
+	// Identities without a mapped role are denied without an account
+	result = oidcLogin(t, s, idp, map[string]interface{}{
+		"sub":                "guest-1",
+		"preferred_username": "guest",
+		"groups":             []string{"neighbours"},
+	})
+	if !strings.HasSuffix(result.Get("error"), errNoRole.Error()) || result.Get("token") != "" {
+		t.Errorf("unmapped login result = %v, want %q", result, errNoRole)
+	}
+	if user, _ := store.GetUser("guest"); user != nil {
+		t.Errorf("unmapped login created %+v", user)
+	}
+}
+
+func TestOIDCUsernameCollision(t *testing.T) {
+	idp := newMockIdP(t)
+	s, store := newOIDCTestServer(t, idp)
+
+	if err := store.CreateUser(&storage.User{Username: "admin", PasswordHash: "hash", Role: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateUser(&storage.User{Username: "dad", Role: "viewer", OIDCIssuer: idp.server.URL, OIDCSubject: "dad-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, sub, username string
+	}{
+		{"local account", "attacker-1", "admin"},
+		{"other identity", "attacker-2", "dad"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := store.GetUser(tt.username)
+
+			result := oidcLogin(t, s, idp, map[string]interface{}{
+				"sub":                tt.sub,
+				"preferred_username": tt.username,
+				"groups":             []string{"family-admins"},
+			})
+			if result.Get("token") != "" || !strings.HasSuffix(result.Get("error"), errAccountTaken.Error()) {
+				t.Fatalf("login result = %v, want %q", result, errAccountTaken)
+			}
+
+			after, _ := store.GetUser(tt.username)
+			if after.Role != before.Role || after.OIDCSubject != before.OIDCSubject || after.PasswordHash != before.PasswordHash {
+				t.Errorf("account changed from %+v to %+v", before, after)
+			}
+			if user, _ := store.GetUserByOIDC(idp.server.URL, tt.sub); user != nil {
+				t.Errorf("identity %s was bound to %+v", tt.sub, user)
+			}
+		})
+	}
+
+	// Accounts from OIDC logins before identities were recorded have no
+	// password and are bound on their next login
+	if err := store.CreateUser(&storage.User{Username: "grandma", Role: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	result := oidcLogin(t, s, idp, map[string]interface{}{
+		"sub":                "grandma-1",
+		"preferred_username": "grandma",
+		"groups":             []string{"family"},
+	})
+	if result.Get("token") == "" {
+		t.Fatalf("legacy login result = %v, want tokens", result)
+	}
+	if user, _ := store.GetUser("grandma"); user.OIDCSubject != "grandma-1" || user.Role != "parent" {
+		t.Errorf("legacy account after login = %+v, want parent bound to grandma-1", user)
+	}
+}
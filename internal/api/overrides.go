@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// OverrideRequest represents a request to block or unblock a device for a
+// while. Exactly one of Minutes and Until is required.
+type OverrideRequest struct {
+	Action  string `json:"action" binding:"required,oneof=block unblock"`
+	Minutes int    `json:"minutes"`
+	Until   string `json:"until"` // RFC 3339 time, or "HH:MM" for the next time the clock shows it
+}
+
+// expiresAt works out when the requested override ends
+func (r *OverrideRequest) expiresAt(now time.Time) (time.Time, error) {
+	switch {
+	case r.Minutes != 0 && r.Until != "":
+		return time.Time{}, errors.New("minutes and until are mutually exclusive")
+	case r.Minutes < 0:
+		return time.Time{}, errors.New("minutes must be positive")
+	case r.Minutes > 0:
+		return now.Add(time.Duration(r.Minutes) * time.Minute), nil
+	case r.Until == "":
+		return time.Time{}, errors.New("minutes or until is required")
+	}
+
+	if t, err := time.Parse(time.RFC3339, r.Until); err == nil {
+		return t, nil
+	}
+
+	clock, err := time.ParseInLocation("15:04", r.Until, now.Location())
+	if err != nil {
+		return time.Time{}, errors.New("until must be an RFC 3339 time or HH:MM")
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// setOverride blocks or unblocks a device until a duration has passed or a
+// point in time is reached
+func (s *Server) setOverride(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	var req OverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	// "HH:MM" is read on the device's clock
+	config, err := s.store.GetDeviceConfig(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt, err := req.expiresAt(time.Now().In(s.enforcer.Location(config)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	override, err := s.enforcer.SetOverride(mac, req.Action, expiresAt, currentUser(c).Username)
+	if err != nil {
+		overrideError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, override)
+}
+
+// getOverride returns the override in effect for a device
+func (s *Server) getOverride(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	override, err := s.store.GetOverride(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if override == nil || !override.Active(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no override"})
+		return
+	}
+
+	c.JSON(http.StatusOK, override)
+}
+
+// clearOverride cancels the override of a device
+func (s *Server) clearOverride(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	if err := s.enforcer.ClearOverride(mac, currentUser(c).Username); err != nil {
+		overrideError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "cancelled", "mac": mac})
+}
+
+// listOverrides returns the overrides in effect for all devices, soonest
+// expiry first
+func (s *Server) listOverrides(c *gin.Context) {
+	overrides, err := s.store.ListOverrides()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	active := []*storage.Override{}
+	for _, override := range overrides {
+		if override.Active(now) {
+			active = append(active, override)
+		}
+	}
+
+	c.JSON(http.StatusOK, active)
+}
+
+// overrideError writes the response for a failed override change
+func overrideError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, enforcer.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+	case errors.Is(err, enforcer.ErrNoOverride):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no override"})
+	case errors.Is(err, enforcer.ErrOverrideExpired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
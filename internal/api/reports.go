@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/report"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// getUsageHeatmap returns the active minutes of a device per weekday and
+// hour over the last weeks (default 4), including today. Hours are rolled up
+// into days after tracker.retention.hour_days, so weeks cannot reach further.
+func (s *Server) getUsageHeatmap(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	weeks := 4
+	if w := c.Query("weeks"); w != "" {
+		parsed, err := strconv.Atoi(w)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "weeks must be a positive number"})
+			return
+		}
+		weeks = parsed
+	}
+	if maxWeeks := max(s.config.Tracker.Retention.HourDays/7, 1); weeks > maxWeeks {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("weeks must be at most %d, hourly usage is kept for %d days", maxWeeks, s.config.Tracker.Retention.HourDays),
+		})
+		return
+	}
+
+	// Days and hours are those of the device's time zone
+	config, err := s.store.GetDeviceConfig(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	loc := s.enforcer.Location(config)
+	year, month, day := time.Now().In(loc).Date()
+	to := time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+	from := to.AddDate(0, 0, -7*weeks)
+
+	samples, err := s.store.ListTrafficSamples(storage.TrafficFilter{MAC: mac, From: from, To: to})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report.NewHeatmap(mac, from, to, samples, loc))
+}
+
+// getWeeklyReport returns the usage of a device in the week of the week
+// parameter (default today), compared with the week before and per time block
+func (s *Server) getWeeklyReport(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	config, err := s.store.GetDeviceConfig(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	day := time.Now().In(s.enforcer.Location(config))
+	if w := c.Query("week"); w != "" {
+		if day, err = time.Parse("2006-01-02", w); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "week must be YYYY-MM-DD"})
+			return
+		}
+	}
+	monday := report.WeekStart(day)
+
+	usages, err := s.store.GetBlockUsageRange(mac,
+		monday.AddDate(0, 0, -7).Format("2006-01-02"),
+		monday.AddDate(0, 0, 6).Format("2006-01-02"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report.NewWeekly(mac, monday, usages))
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/network"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// TestUsageHeatmapWeeks limits the heatmap to the weeks hourly usage is kept
+func TestUsageHeatmapWeeks(t *testing.T) {
+	store := storage.NewMemory()
+	t.Cleanup(func() { store.Close() })
+
+	cfg := &config.Config{}
+	cfg.Server.SessionSecret = "secret"
+	cfg.Tracker.Retention.HourDays = 30
+	s := NewServer(cfg, store, nil, enforcer.New(store, network.NewMemory(), time.UTC), nil, nil)
+
+	router := gin.New()
+	router.GET("/usage/:mac/heatmap", s.getUsageHeatmap)
+
+	for _, tt := range []struct {
+		weeks string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"4", http.StatusOK},
+		{"5", http.StatusBadRequest},
+		{"520", http.StatusBadRequest},
+		{"0", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage/aa:bb:cc:dd:ee:ff/heatmap?weeks="+tt.weeks, nil))
+		if w.Code != tt.want {
+			t.Errorf("weeks=%s: status %d, want %d: %s", tt.weeks, w.Code, tt.want, w.Body)
+		}
+	}
+}
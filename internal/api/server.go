@@ -2,43 +2,72 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/bus"
 	"github.com/nadilas/zeitpolizei/internal/config"
 	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/network"
 	"github.com/nadilas/zeitpolizei/internal/storage"
-	"github.com/nadilas/zeitpolizei/internal/unifi"
+	"github.com/nadilas/zeitpolizei/internal/tracker"
 )
 
 // Server represents the HTTP API server
 type Server struct {
 	config   *config.Config
-	store    *storage.SQLite
-	unifi    *unifi.Client
+	store    storage.Store
+	network  network.Backend
 	enforcer *enforcer.Enforcer
+	tracker  *tracker.Tracker
+	bus      *bus.Bus
+	sessions *auth.Manager
+	oidc     *oidcProvider // nil unless OIDC login is configured
 	router   *gin.Engine
 	server   *http.Server
+	shutdown chan struct{} // Closed on shutdown to end open streams
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, store *storage.SQLite, unifiClient *unifi.Client, enf *enforcer.Enforcer) *Server {
+func NewServer(cfg *config.Config, store storage.Store, backend network.Backend, enf *enforcer.Enforcer, track *tracker.Tracker, events *bus.Bus) *Server {
 	gin.SetMode(gin.ReleaseMode)
 
 	s := &Server{
 		config:   cfg,
 		store:    store,
-		unifi:    unifiClient,
+		network:  backend,
 		enforcer: enf,
+		tracker:  track,
+		bus:      events,
+		sessions: auth.NewManager(store, sessionSecret(cfg.Server.SessionSecret), cfg.Server.AccessTokenTTL, cfg.Server.RefreshTokenTTL),
+		oidc:     newOIDCProvider(cfg.Server.OIDC),
 		router:   gin.New(),
+		shutdown: make(chan struct{}),
 	}
 
 	s.setupRoutes()
 	return s
 }
 
+// sessionSecret returns the configured token signing secret, or a random one
+func sessionSecret(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	log.Println("Warning: server.session_secret is not set, using a random secret (sessions end on restart)")
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Fatalf("Failed to generate session secret: %v", err)
+	}
+	return random
+}
+
 // setupRoutes configures all API routes
 func (s *Server) setupRoutes() {
 	// Recovery middleware
@@ -58,29 +87,82 @@ func (s *Server) setupRoutes() {
 	{
 		// Authentication
 		v1.POST("/auth/login", s.login)
+		v1.POST("/auth/refresh", s.refresh)
+		v1.GET("/auth/config", s.authConfig)
+		v1.GET("/auth/oidc/login", s.oidcLogin)
+		v1.GET("/auth/oidc/callback", s.oidcCallback)
+
+		// Protected routes. Viewers can read everything, parents can also
+		// block, unblock and grant bonuses, admins can also change schedules
+		// and manage users.
+		viewer := s.requireRole(auth.RoleViewer)
+		parent := s.requireRole(auth.RoleParent)
+		admin := s.requireRole(auth.RoleAdmin)
 
-		// Protected routes
 		protected := v1.Group("")
 		protected.Use(s.authMiddleware())
 		{
+			protected.POST("/auth/logout", s.logout)
+			protected.GET("/auth/me", viewer, s.me)
+
 			// Devices
-			protected.GET("/devices", s.listDevices)
-			protected.GET("/devices/managed", s.listManagedDevices)
-			protected.POST("/devices/:mac/config", s.saveDeviceConfig)
-			protected.GET("/devices/:mac/config", s.getDeviceConfig)
-			protected.DELETE("/devices/:mac/config", s.deleteDeviceConfig)
-			protected.POST("/devices/:mac/block", s.blockDevice)
-			protected.POST("/devices/:mac/unblock", s.unblockDevice)
-			protected.POST("/devices/:mac/add-time", s.addBonusTime)
-			protected.POST("/devices/:mac/add-data", s.addBonusData)
+			protected.GET("/devices", viewer, s.listDevices)
+			protected.GET("/devices/managed", viewer, s.listManagedDevices)
+			protected.POST("/devices/:mac/config", admin, s.saveDeviceConfig)
+			protected.GET("/devices/:mac/config", viewer, s.getDeviceConfig)
+			protected.DELETE("/devices/:mac/config", admin, s.deleteDeviceConfig)
+			protected.POST("/devices/:mac/block", parent, s.blockDevice)
+			protected.POST("/devices/:mac/unblock", parent, s.unblockDevice)
+			protected.POST("/devices/:mac/add-time", parent, s.addBonusTime)
+			protected.POST("/devices/:mac/add-data", parent, s.addBonusData)
+			protected.GET("/devices/:mac/activity", viewer, s.getActivity)
+			protected.GET("/devices/:mac/override", viewer, s.getOverride)
+			protected.POST("/devices/:mac/override", parent, s.setOverride)
+			protected.DELETE("/devices/:mac/override", parent, s.clearOverride)
+			protected.GET("/overrides", viewer, s.listOverrides)
+
+			// Schedule exceptions
+			protected.GET("/exceptions", viewer, s.listExceptions)
+			protected.POST("/exceptions", admin, s.createException)
+			protected.GET("/exceptions/:id", viewer, s.getException)
+			protected.PUT("/exceptions/:id", admin, s.updateException)
+			protected.DELETE("/exceptions/:id", admin, s.deleteException)
 
 			// Usage
-			protected.GET("/usage", s.getAllUsage)
-			protected.GET("/usage/:mac", s.getDeviceUsage)
-			protected.GET("/usage/:mac/history", s.getUsageHistory)
+			protected.GET("/usage", viewer, s.getAllUsage)
+			protected.GET("/usage/:mac", viewer, s.getDeviceUsage)
+			protected.GET("/usage/:mac/history", viewer, s.getUsageHistory)
+			protected.GET("/usage/:mac/timeline", viewer, s.getUsageTimeline)
+			protected.GET("/usage/:mac/heatmap", viewer, s.getUsageHeatmap)
+			protected.GET("/usage/:mac/weekly", viewer, s.getWeeklyReport)
+
+			// Export
+			protected.GET("/export/usage", viewer, s.exportUsage)
 
 			// Status
-			protected.GET("/status", s.getStatus)
+			protected.GET("/status", viewer, s.getStatus)
+			protected.GET("/status/drift", viewer, s.getDriftEvents)
+
+			// Audit log
+			protected.GET("/events", viewer, s.getEvents)
+
+			// Live updates
+			protected.GET("/stream", viewer, s.stream)
+
+			// Users
+			protected.GET("/users", admin, s.listUsers)
+			protected.POST("/users", admin, s.createUser)
+			protected.PUT("/users/:username", admin, s.updateUser)
+			protected.DELETE("/users/:username", admin, s.deleteUser)
+
+			// Webhooks
+			protected.GET("/webhooks", admin, s.listWebhooks)
+			protected.POST("/webhooks", admin, s.createWebhook)
+			protected.GET("/webhooks/:id", admin, s.getWebhook)
+			protected.PUT("/webhooks/:id", admin, s.updateWebhook)
+			protected.DELETE("/webhooks/:id", admin, s.deleteWebhook)
+			protected.GET("/webhooks/:id/deliveries", admin, s.listWebhookDeliveries)
+			protected.POST("/webhooks/:id/ping", admin, s.pingWebhook)
 		}
 	}
 
@@ -104,33 +186,54 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// authMiddleware validates authentication
+// authMiddleware validates the session access token and loads the user it
+// belongs to, so role changes and deleted accounts take effect immediately
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Simple token-based auth
-		token := c.GetHeader("Authorization")
-		if token == "" {
+		header := c.GetHeader("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if header == "" || token == header {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			c.Abort()
 			return
 		}
 
-		// Validate token (simple implementation - in production use JWT)
-		expectedToken := "Bearer " + generateToken(s.config.Server.Username, s.config.Server.Password)
-		if token != expectedToken {
+		session, err := s.sessions.Authenticate(token, time.Now())
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
+		user, err := s.store.GetUser(session.Username)
+		if err != nil || user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			c.Abort()
+			return
+		}
+
+		c.Set("session", session)
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// requireRole rejects requests from users without at least the given role
+func (s *Server) requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasRole(currentUser(c).Role, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
-// generateToken creates a simple auth token
-func generateToken(username, password string) string {
-	// Simple token generation (in production, use proper JWT)
-	return username + ":" + password
+// currentUser returns the authenticated user of a request
+func currentUser(c *gin.Context) *storage.User {
+	return c.MustGet("user").(*storage.User)
 }
 
 // Start starts the HTTP server
@@ -150,6 +253,7 @@ func (s *Server) Shutdown() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	close(s.shutdown)
 	if err := s.server.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/bus"
+)
+
+const (
+	// streamKeepalive is how often an idle stream sends a comment so proxies
+	// keep the connection open
+	streamKeepalive = 15 * time.Second
+
+	// streamRetry is the reconnect delay suggested to clients, in milliseconds
+	streamRetry = 3000
+
+	// messageResync tells a client that messages were missed and it has to
+	// reload its state
+	messageResync = "resync"
+)
+
+// stream sends live updates as server-sent events. Clients resume after a
+// reconnect by sending the ID of the last event they received in the
+// Last-Event-ID header (or the last_event_id query parameter).
+func (s *Server) stream(c *gin.Context) {
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+
+	sub, replay, ok := s.bus.Subscribe(lastID)
+	defer sub.Close()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming not supported"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	if !ok {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", messageResync)
+	}
+	for _, msg := range replay {
+		writeEvent(w, msg)
+	}
+	w.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		case msg, open := <-sub.C():
+			if !open {
+				// Dropped for falling behind; the client resumes on reconnect
+				return
+			}
+			writeEvent(w, msg)
+			w.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			w.Flush()
+		}
+	}
+}
+
+// writeEvent writes a bus message as a server-sent event
+func writeEvent(w gin.ResponseWriter, msg bus.Message) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", msg.ID, msg.Type, msg.Data)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+	"github.com/nadilas/zeitpolizei/internal/tracker"
+)
+
+// TimelineResponse is the traffic of a device over time. Points keep the
+// resolution they are stored in when it is coarser than the requested one.
+type TimelineResponse struct {
+	MAC        string                   `json:"mac"`
+	From       time.Time                `json:"from"`
+	To         time.Time                `json:"to"`
+	Resolution string                   `json:"resolution"`
+	Points     []*storage.TrafficSample `json:"points"`
+}
+
+// getUsageTimeline returns the traffic and active time of a device per
+// minute, hour or day. The range defaults to the last 24 hours and the
+// resolution to the finest that keeps the number of points manageable.
+func (s *Server) getUsageTimeline(c *gin.Context) {
+	mac := strings.ToLower(c.Param("mac"))
+
+	// Hours and days are those of the device's time zone
+	config, err := s.store.GetDeviceConfig(mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	loc := s.enforcer.Location(config)
+
+	from, err := parseTimeQuery(c.Query("from"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from: " + err.Error()})
+		return
+	}
+	to, err := parseTimeQuery(c.Query("to"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to: " + err.Error()})
+		return
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	resolution := c.DefaultQuery("resolution", "auto")
+	switch {
+	case resolution == "auto":
+		resolution = autoResolution(to.Sub(from))
+	case !validResolution(resolution):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resolution must be minute, hour, day or auto"})
+		return
+	}
+	from = tracker.BucketStart(from, resolution, loc)
+
+	samples, err := s.store.ListTrafficSamples(storage.TrafficFilter{MAC: mac, From: from, To: to})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	points := tracker.Downsample(samples, resolution, loc)
+	if points == nil {
+		points = []*storage.TrafficSample{}
+	}
+
+	c.JSON(http.StatusOK, TimelineResponse{
+		MAC:        mac,
+		From:       from.In(loc),
+		To:         to.In(loc),
+		Resolution: resolution,
+		Points:     points,
+	})
+}
+
+// autoResolution picks the resolution for a timeline spanning d
+func autoResolution(d time.Duration) string {
+	switch {
+	case d <= 48*time.Hour:
+		return storage.ResolutionMinute
+	case d <= 62*24*time.Hour:
+		return storage.ResolutionHour
+	default:
+		return storage.ResolutionDay
+	}
+}
+
+// validResolution reports whether resolution is a traffic sample resolution
+func validResolution(resolution string) bool {
+	for _, r := range storage.Resolutions {
+		if resolution == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// me returns the authenticated user
+func (s *Server) me(c *gin.Context) {
+	c.JSON(http.StatusOK, currentUser(c))
+}
+
+// listUsers returns all users
+func (s *Server) listUsers(c *gin.Context) {
+	users, err := s.store.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUserRequest represents a request to create a user
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"required"` // "admin", "parent" or "viewer"
+}
+
+// createUser adds a new user
+func (s *Server) createUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	user, err := auth.NewUser(req.Username, req.Password, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.store.CreateUser(user); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUserRequest represents a request to change a user's password or role.
+// Empty fields are left unchanged.
+type UpdateUserRequest struct {
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// updateUser changes a user's password or role. A new password or a less
+// privileged role signs the user out everywhere.
+func (s *Server) updateUser(c *gin.Context) {
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, err := s.store.GetUser(c.Param("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	logout := false
+	if req.Role != "" && req.Role != user.Role {
+		if !auth.ValidRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + req.Role})
+			return
+		}
+		if user.Role == auth.RoleAdmin {
+			if ok, err := s.otherAdminExists(user.Username); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			} else if !ok {
+				c.JSON(http.StatusConflict, gin.H{"error": "cannot demote the last admin"})
+				return
+			}
+		}
+		logout = !auth.HasRole(req.Role, user.Role)
+		user.Role = req.Role
+	}
+
+	if req.Password != "" {
+		user.PasswordHash, err = auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logout = true
+	}
+
+	if err := s.store.UpdateUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if logout {
+		if err := s.sessions.LogoutUser(user.Username, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// deleteUser removes a user. Admins cannot delete themselves or the last admin.
+func (s *Server) deleteUser(c *gin.Context) {
+	username := c.Param("username")
+	if username == currentUser(c).Username {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot delete your own account"})
+		return
+	}
+
+	user, err := s.store.GetUser(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.Role == auth.RoleAdmin {
+		if ok, err := s.otherAdminExists(username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		} else if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot delete the last admin"})
+			return
+		}
+	}
+
+	if err := s.store.DeleteUser(username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.sessions.LogoutUser(username, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// otherAdminExists reports whether an admin other than username exists
+func (s *Server) otherAdminExists(username string) (bool, error) {
+	users, err := s.store.ListUsers()
+	if err != nil {
+		return false, err
+	}
+	for _, user := range users {
+		if user.Role == auth.RoleAdmin && user.Username != username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// TestUpdateUserLogout signs users out when their password changes or they
+// lose privileges, and only then
+func TestUpdateUserLogout(t *testing.T) {
+	store := storage.NewMemory()
+	t.Cleanup(func() { store.Close() })
+
+	cfg := &config.Config{}
+	cfg.Server.SessionSecret = "secret"
+	cfg.Server.AccessTokenTTL = time.Hour
+	cfg.Server.RefreshTokenTTL = time.Hour
+	s := NewServer(cfg, store, nil, nil, nil, nil)
+	router := gin.New()
+	router.PUT("/users/:username", s.updateUser)
+
+	for username, role := range map[string]string{"mum": auth.RoleAdmin, "dad": auth.RoleViewer} {
+		user, err := auth.NewUser(username, "old-password", role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		body       string
+		wantLogout bool
+	}{
+		{`{"role": "parent"}`, false},
+		{`{"role": "admin"}`, false},
+		{`{"role": "viewer"}`, true},
+		{`{"password": "new-password"}`, true},
+		{`{"role": "viewer"}`, false},
+	} {
+		tokens, err := s.sessions.Login("dad", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users/dad", strings.NewReader(tt.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.body, w.Code, w.Body)
+		}
+
+		_, err = s.sessions.Authenticate(tokens.AccessToken, time.Now())
+		if loggedOut := err != nil; loggedOut != tt.wantLogout {
+			t.Errorf("%s: logged out = %v (%v), want %v", tt.body, loggedOut, err, tt.wantLogout)
+		}
+	}
+}
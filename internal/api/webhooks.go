@@ -0,0 +1,256 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nadilas/zeitpolizei/internal/auth"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+	"github.com/nadilas/zeitpolizei/internal/webhook"
+)
+
+// WebhookRequest represents a request to create or update a webhook
+type WebhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Events      []string `json:"events"` // empty = all event types
+	Description string   `json:"description"`
+	Enabled     *bool    `json:"enabled"` // default true
+	// Secret signs the payloads. A random secret is generated on create when
+	// empty, and on update when RotateSecret is set.
+	Secret       string `json:"secret"`
+	RotateSecret bool   `json:"rotate_secret"`
+}
+
+// WebhookResponse is a webhook including its secret, which is only returned
+// when it was set or generated by the request
+type WebhookResponse struct {
+	*storage.Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+// listWebhooks returns all webhooks
+func (s *Server) listWebhooks(c *gin.Context) {
+	webhooks, err := s.store.ListWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if webhooks == nil {
+		webhooks = []*storage.Webhook{}
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// createWebhook subscribes a URL to events
+func (s *Server) createWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	hook := &storage.Webhook{Enabled: true, CreatedBy: currentUser(c).Username}
+	if !applyWebhookRequest(c, hook, &req) {
+		return
+	}
+	if hook.Secret == "" {
+		if !rotateSecret(c, hook) {
+			return
+		}
+	}
+
+	if err := s.store.CreateWebhook(hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, WebhookResponse{Webhook: hook, Secret: hook.Secret})
+}
+
+// getWebhook returns a webhook
+func (s *Server) getWebhook(c *gin.Context) {
+	hook, ok := s.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// updateWebhook changes a webhook and optionally rotates its secret
+func (s *Server) updateWebhook(c *gin.Context) {
+	hook, ok := s.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	secret := hook.Secret
+	if !applyWebhookRequest(c, hook, &req) {
+		return
+	}
+	if req.RotateSecret && req.Secret == "" {
+		if !rotateSecret(c, hook) {
+			return
+		}
+	}
+
+	if err := s.store.UpdateWebhook(hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := WebhookResponse{Webhook: hook}
+	if hook.Secret != secret {
+		resp.Secret = hook.Secret
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// deleteWebhook removes a webhook and its delivery log
+func (s *Server) deleteWebhook(c *gin.Context) {
+	hook, ok := s.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.DeleteWebhook(hook.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// listWebhookDeliveries returns the delivery log of a webhook, newest first
+func (s *Server) listWebhookDeliveries(c *gin.Context) {
+	hook, ok := s.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := s.store.ListWebhookDeliveries(hook.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*storage.WebhookDelivery{}
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// pingWebhook queues a ping delivery to check the receiver
+func (s *Server) pingWebhook(c *gin.Context) {
+	hook, ok := s.loadWebhook(c)
+	if !ok {
+		return
+	}
+	if !hook.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "webhook is disabled"})
+		return
+	}
+
+	delivery, err := webhook.Enqueue(s.store, hook, webhook.EventPing, &storage.Event{
+		Time:  time.Now(),
+		Type:  webhook.EventPing,
+		Actor: currentUser(c).Username,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// loadWebhook looks up the webhook of the :id parameter, responding with 404
+// when there is none
+func (s *Server) loadWebhook(c *gin.Context) (*storage.Webhook, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return nil, false
+	}
+
+	hook, err := s.store.GetWebhook(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if hook == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return nil, false
+	}
+	return hook, true
+}
+
+// applyWebhookRequest validates req and copies it onto hook
+func applyWebhookRequest(c *gin.Context, hook *storage.Webhook, req *WebhookRequest) bool {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute http or https URL"})
+		return false
+	}
+
+	events := []string{}
+	for _, event := range req.Events {
+		if !validEventType(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown event type " + strconv.Quote(event)})
+			return false
+		}
+		events = append(events, event)
+	}
+
+	hook.URL = req.URL
+	hook.Events = events
+	hook.Description = req.Description
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	return true
+}
+
+// rotateSecret gives hook a new random secret
+func rotateSecret(c *gin.Context, hook *storage.Webhook) bool {
+	secret, err := auth.RandomID(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	hook.Secret = secret
+	return true
+}
+
+// validEventType reports whether t is a known event type
+func validEventType(t string) bool {
+	for _, eventType := range storage.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
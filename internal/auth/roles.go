@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Roles, from least to most privileged. Each role includes the permissions of
+// the ones before it.
+const (
+	// RoleViewer can read the dashboard, devices and usage
+	RoleViewer = "viewer"
+	// RoleParent can also block and unblock devices and grant bonus time or data
+	RoleParent = "parent"
+	// RoleAdmin can also edit device schedules and manage users
+	RoleAdmin = "admin"
+)
+
+// roleRank orders the roles by privilege
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleParent: 2,
+	RoleAdmin:  3,
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the permissions of required
+func HasRole(role, required string) bool {
+	return ValidRole(role) && roleRank[role] >= roleRank[required]
+}
+
+// NewUser validates a role and password and returns a user ready to be stored
+func NewUser(username, password, role string) (*storage.User, error) {
+	if username == "" {
+		return nil, fmt.Errorf("username must not be empty")
+	}
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("unknown role %q (want %s, %s or %s)", role, RoleAdmin, RoleParent, RoleViewer)
+	}
+	if password == "" {
+		return nil, fmt.Errorf("password must not be empty")
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.User{Username: username, PasswordHash: hash, Role: role}, nil
+}
+
+// Authenticate checks a username and password against the stored users and
+// returns the matching user, or nil if the credentials are wrong
+func Authenticate(store storage.Store, username, password string) (*storage.User, error) {
+	user, err := store.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !CheckPassword(user.PasswordHash, password) {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// Bootstrap creates an admin account from the configured credentials when no
+// users exist yet, so upgrading from the single shared password keeps working.
+// It reports whether a user was created.
+func Bootstrap(store storage.Store, username, passwordHash string) (bool, error) {
+	if username == "" || passwordHash == "" {
+		return false, nil
+	}
+
+	users, err := store.ListUsers()
+	if err != nil {
+		return false, err
+	}
+	if len(users) > 0 {
+		return false, nil
+	}
+
+	err = store.CreateUser(&storage.User{
+		Username:     username,
+		PasswordHash: passwordHash,
+		Role:         RoleAdmin,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// ErrSessionRevoked is returned for tokens whose session was logged out or expired
+var ErrSessionRevoked = errors.New("session revoked")
+
+// TokenPair is the result of a login or refresh
+type TokenPair struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Manager issues session tokens backed by server-side sessions, so tokens can
+// be revoked before they expire
+type Manager struct {
+	store      storage.Store
+	signer     *Signer
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewManager creates a session manager. Access tokens are short-lived and
+// renewed with the refresh token, which also extends the session.
+func NewManager(store storage.Store, secret []byte, accessTTL, refreshTTL time.Duration) *Manager {
+	return &Manager{
+		store:      store,
+		signer:     NewSigner(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// Login starts a new session for username and returns its tokens
+func (m *Manager) Login(username string, now time.Time) (*TokenPair, error) {
+	// Opportunistically clean up old sessions
+	if err := m.store.DeleteExpiredSessions(now); err != nil {
+		return nil, err
+	}
+
+	id, err := RandomID(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshID, err := RandomID(16)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &storage.Session{
+		ID:        id,
+		Username:  username,
+		RefreshID: refreshID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.refreshTTL),
+	}
+	if err := m.store.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	return m.issue(session, now)
+}
+
+// Refresh exchanges a refresh token for a new token pair. Refresh tokens are
+// single-use: each refresh rotates the session's refresh ID.
+func (m *Manager) Refresh(refreshToken string, now time.Time) (*TokenPair, error) {
+	claims, err := m.signer.Verify(refreshToken, now)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenRefresh {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := m.activeSession(claims.SessionID, now)
+	if err != nil {
+		return nil, err
+	}
+	if session.RefreshID != claims.ID {
+		// A rotated refresh token was replayed; end the session to be safe
+		if err := m.store.RevokeSession(session.ID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionRevoked
+	}
+
+	session.RefreshID, err = RandomID(16)
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = now.Add(m.refreshTTL)
+	if err := m.store.UpdateSession(session); err != nil {
+		return nil, err
+	}
+
+	return m.issue(session, now)
+}
+
+// Authenticate validates an access token and returns its session
+func (m *Manager) Authenticate(accessToken string, now time.Time) (*storage.Session, error) {
+	claims, err := m.signer.Verify(accessToken, now)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenAccess {
+		return nil, ErrInvalidToken
+	}
+
+	return m.activeSession(claims.SessionID, now)
+}
+
+// Logout revokes a session; its tokens stop working immediately
+func (m *Manager) Logout(sessionID string, now time.Time) error {
+	return m.store.RevokeSession(sessionID, now)
+}
+
+// LogoutUser revokes every session of a user, signing them out everywhere
+func (m *Manager) LogoutUser(username string, now time.Time) error {
+	return m.store.RevokeUserSessions(username, now)
+}
+
+// activeSession loads a session and checks it is neither revoked nor expired
+func (m *Manager) activeSession(id string, now time.Time) (*storage.Session, error) {
+	session, err := m.store.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || !session.RevokedAt.IsZero() || !now.Before(session.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+	return session, nil
+}
+
+// issue signs a new access and refresh token for a session
+func (m *Manager) issue(session *storage.Session, now time.Time) (*TokenPair, error) {
+	accessID, err := RandomID(8)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(m.accessTTL)
+	access, err := m.signer.Sign(&Claims{
+		SessionID: session.ID,
+		Subject:   session.Username,
+		Type:      TokenAccess,
+		ID:        accessID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := m.signer.Sign(&Claims{
+		SessionID: session.ID,
+		Subject:   session.Username,
+		Type:      TokenRefresh,
+		ID:        session.RefreshID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: session.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,161 @@
+// Package bus is an in-process publish/subscribe bus carrying live updates
+// from the tracker and the enforcer to API clients.
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistory is the number of recent messages kept for replay
+	DefaultHistory = 1024
+
+	// subscriberBuffer is the number of messages a subscriber may fall behind
+	// before it is dropped
+	subscriberBuffer = 64
+)
+
+// Message is a published update. IDs are "<epoch>-<sequence>", where the
+// epoch changes with every start so IDs from a previous run are recognized.
+type Message struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+
+	seq uint64
+}
+
+// Bus fans published messages out to its subscribers and keeps a history of
+// recent messages so reconnecting subscribers can catch up
+type Bus struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []Message
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the messages published after it was created
+type Subscription struct {
+	bus *Bus
+	ch  chan Message
+}
+
+// New creates a bus keeping the last historySize messages for replay
+func New(historySize int) *Bus {
+	if historySize <= 0 {
+		historySize = DefaultHistory
+	}
+	return &Bus{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		historySize: historySize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends data, marshaled to JSON, to all subscribers. Subscribers that
+// have fallen too far behind are dropped; they can resume from the history.
+func (b *Bus) Publish(typ string, data interface{}) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s message: %v", typ, err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	msg := Message{
+		ID:   fmt.Sprintf("%s-%d", b.epoch, b.seq),
+		Type: typ,
+		Data: raw,
+		seq:  b.seq,
+	}
+
+	b.history = append(b.history, msg)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- msg:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe creates a subscription. With the ID of the last message a
+// subscriber received, the messages published since then are returned for
+// replay; ok is false if they are no longer all available, in which case the
+// subscriber has to reload its state.
+func (b *Bus) Subscribe(lastID string) (sub *Subscription, replay []Message, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{bus: b, ch: make(chan Message, subscriberBuffer)}
+	b.subscribers[sub] = struct{}{}
+
+	if lastID == "" {
+		return sub, nil, true
+	}
+
+	seq, ok := b.parseID(lastID)
+	if !ok || seq > b.seq {
+		return sub, nil, false
+	}
+
+	// The message after the last one received must still be in the history
+	if len(b.history) > 0 && b.history[0].seq > seq+1 {
+		return sub, nil, false
+	}
+	for _, msg := range b.history {
+		if msg.seq > seq {
+			replay = append(replay, msg)
+		}
+	}
+	return sub, replay, true
+}
+
+// parseID returns the sequence number of a message ID of the current epoch
+func (b *Bus) parseID(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// drop removes a subscriber and closes its channel. The caller must hold mu.
+func (b *Bus) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// C returns the channel messages are delivered on. It is closed when the
+// subscription is closed or dropped for falling behind.
+func (s *Subscription) C() <-chan Message {
+	return s.ch
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
@@ -0,0 +1,299 @@
+// Package calendar imports iCalendar files, such as the school holidays of a
+// region or a family calendar, as schedule exceptions.
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a calendar event, reduced to what schedule exceptions need
+type Event struct {
+	UID        string
+	Summary    string
+	Categories []string
+	Start      time.Time
+	End        time.Time // Exclusive
+	AllDay     bool
+	// RRule is the recurrence rule of a recurring event, which Occurrences
+	// expands. ExDates are the starts of the occurrences it leaves out.
+	RRule   string
+	ExDates []time.Time
+}
+
+// Dates returns the first and last date the event covers in loc. All-day
+// events cover their dates regardless of the time zone.
+func (e Event) Dates(loc *time.Location) (start, end string) {
+	first, last := e.Start, e.End
+	if !e.AllDay {
+		first, last = first.In(loc), last.In(loc)
+	}
+
+	// The end is exclusive, so an event ending at midnight does not cover
+	// the day that starts then
+	if last.After(first) {
+		last = last.Add(-time.Nanosecond)
+	} else {
+		last = first
+	}
+	return first.Format("2006-01-02"), last.Format("2006-01-02")
+}
+
+// Parse reads the events of an iCalendar (RFC 5545) stream, reading floating
+// times in loc. Cancelled events are skipped. Recurring events keep their
+// rule; occurrences that were moved or cancelled, which are separate events
+// with the UID of the recurring event and a RECURRENCE-ID, are added to its
+// ExDates.
+func Parse(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var event *Event
+	var duration string
+	var status string
+	var recurrenceID time.Time
+	depth := 0 // Nesting inside the event, e.g. VALARM
+	replaced := make(map[string][]time.Time)
+
+	for n, line := range lines {
+		name, params, value, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && event == nil:
+			event = &Event{}
+			duration, status, recurrenceID, depth = "", "", time.Time{}, 0
+			continue
+		case name == "BEGIN" && event != nil:
+			depth++
+			continue
+		case name == "END" && event != nil && depth > 0:
+			depth--
+			continue
+		case name == "END" && strings.EqualFold(value, "VEVENT") && event != nil:
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no DTSTART", n+1, event.Summary)
+			}
+			if event.End.IsZero() {
+				event.End, err = defaultEnd(event, duration)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", n+1, err)
+				}
+			}
+			if !recurrenceID.IsZero() {
+				replaced[event.UID] = append(replaced[event.UID], recurrenceID)
+			}
+			if !strings.EqualFold(status, "CANCELLED") {
+				events = append(events, *event)
+			}
+			event = nil
+			continue
+		}
+
+		if event == nil || depth > 0 {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			event.UID = unescape(value)
+		case "SUMMARY":
+			event.Summary = unescape(value)
+		case "CATEGORIES":
+			for _, category := range splitText(value) {
+				if category = strings.TrimSpace(category); category != "" {
+					event.Categories = append(event.Categories, category)
+				}
+			}
+		case "STATUS":
+			status = value
+		case "DURATION":
+			duration = value
+		case "DTSTART":
+			event.Start, event.AllDay, err = parseTime(value, params, loc)
+		case "DTEND":
+			event.End, _, err = parseTime(value, params, loc)
+		case "RRULE":
+			event.RRule = value
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				var exdate time.Time
+				if exdate, _, err = parseTime(v, params, loc); err != nil {
+					break
+				}
+				event.ExDates = append(event.ExDates, exdate)
+			}
+		case "RECURRENCE-ID":
+			recurrenceID, _, err = parseTime(value, params, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n+1, name, err)
+		}
+	}
+
+	for n := range events {
+		if events[n].RRule != "" {
+			events[n].ExDates = append(events[n].ExDates, replaced[events[n].UID]...)
+		}
+	}
+	return events, nil
+}
+
+// unfold reads the content lines of a stream, joining folded lines
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into its upper-cased name, its parameters
+// and its value
+func parseLine(line string) (name string, params map[string]string, value string, err error) {
+	// The value starts at the first colon outside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", fmt.Errorf("malformed content line %q", line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params = make(map[string]string)
+	for _, param := range parts[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], nil
+}
+
+// parseTime parses a DATE or DATE-TIME value. Times with a TZID are read in
+// that zone (falling back to loc for zones Go does not know), UTC times end
+// in "Z" and floating times are read in loc.
+func parseTime(value string, params map[string]string, loc *time.Location) (t time.Time, allDay bool, err error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err = time.Parse("20060102", value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// defaultEnd works out the end of an event without DTEND: after its DURATION
+// if it has one, otherwise the next day for all-day events and the start for
+// others
+func defaultEnd(event *Event, duration string) (time.Time, error) {
+	if duration != "" {
+		d, days, err := parseDuration(duration)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("DURATION: %w", err)
+		}
+		return event.Start.AddDate(0, 0, days).Add(d), nil
+	}
+	if event.AllDay {
+		return event.Start.AddDate(0, 0, 1), nil
+	}
+	return event.Start, nil
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration parses an RFC 5545 duration into days, which span 23 or 25
+// hours across DST changes, and the remaining time
+func parseDuration(value string) (time.Duration, int, error) {
+	m := durationPattern.FindStringSubmatch(value)
+	if m == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	number := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	days := number(m[2])*7 + number(m[3])
+	d := time.Duration(number(m[4]))*time.Hour +
+		time.Duration(number(m[5]))*time.Minute +
+		time.Duration(number(m[6]))*time.Second
+	if m[1] == "-" {
+		return -d, -days, nil
+	}
+	return d, days, nil
+}
+
+// splitText splits a list of TEXT values at unescaped commas and unescapes them
+func splitText(value string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			values = append(values, unescape(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, unescape(value[start:]))
+}
+
+// unescape resolves the backslash escapes of a TEXT value
+func unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,319 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+const (
+	// defaultRefresh is how often a calendar is read again unless configured
+	defaultRefresh = 6 * time.Hour
+
+	// horizonDays is how far ahead recurring events are expanded
+	horizonDays = 366
+
+	// maxCalendarSize limits the size of a downloaded calendar
+	maxCalendarSize = 10 << 20
+)
+
+// Importer keeps the schedule exceptions imported from calendars in sync
+// with their sources. Each calendar's exceptions are replaced as a whole on
+// every refresh; a source that cannot be read keeps its previous exceptions.
+type Importer struct {
+	calendars []config.CalendarConfig
+	store     storage.Store
+	enforcer  *enforcer.Enforcer
+	client    *http.Client
+}
+
+// New validates the calendar configuration and creates an importer that
+// works out the dates of timed events in the time zone of the schedules they
+// apply to, that of the device for device-scoped calendars
+func New(calendars []config.CalendarConfig, store storage.Store, enf *enforcer.Enforcer) (*Importer, error) {
+	i := &Importer{
+		store:    store,
+		enforcer: enf,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	seen := make(map[string]bool)
+	for _, cal := range calendars {
+		if cal.Name == "" {
+			return nil, fmt.Errorf("calendar without a name")
+		}
+		if seen[cal.Name] {
+			return nil, fmt.Errorf("duplicate calendar %q", cal.Name)
+		}
+		seen[cal.Name] = true
+
+		if cal.Source == "" {
+			return nil, fmt.Errorf("calendar %q: source is required", cal.Name)
+		}
+		if cal.Refresh <= 0 {
+			cal.Refresh = defaultRefresh
+		}
+		if cal.Mode == "" {
+			cal.Mode = storage.ExceptionSchedule
+		}
+		if !validMode(cal.Mode) {
+			return nil, fmt.Errorf("calendar %q: unknown mode %q", cal.Name, cal.Mode)
+		}
+		if cal.Mode == storage.ExceptionSchedule && len(cal.DailySchedules) == 0 {
+			return nil, fmt.Errorf("calendar %q: daily_schedules is required in schedule mode", cal.Name)
+		}
+		if err := storage.ValidateSchedules(cal.DailySchedules); err != nil {
+			return nil, fmt.Errorf("calendar %q: %w", cal.Name, err)
+		}
+
+		devices := make([]string, len(cal.Devices))
+		for i, mac := range cal.Devices {
+			devices[i] = strings.ToLower(mac)
+		}
+		cal.Devices = devices
+
+		i.calendars = append(i.calendars, cal)
+	}
+
+	return i, nil
+}
+
+// validMode reports whether mode is a schedule exception mode
+func validMode(mode string) bool {
+	for _, m := range storage.ExceptionModes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Calendars returns the names of the configured calendars
+func (i *Importer) Calendars() []string {
+	names := make([]string, len(i.calendars))
+	for n, cal := range i.calendars {
+		names[n] = cal.Name
+	}
+	return names
+}
+
+// Start imports every calendar now and again at its refresh interval until
+// ctx is cancelled
+func (i *Importer) Start(ctx context.Context) {
+	if len(i.calendars) == 0 {
+		return
+	}
+	log.Printf("Importing %d calendar(s)", len(i.calendars))
+
+	for _, cal := range i.calendars {
+		go i.run(ctx, cal)
+	}
+	<-ctx.Done()
+}
+
+// run keeps one calendar up to date
+func (i *Importer) run(ctx context.Context, cal config.CalendarConfig) {
+	ticker := time.NewTicker(cal.Refresh)
+	defer ticker.Stop()
+
+	for {
+		if n, err := i.importCalendar(ctx, cal, time.Now()); err != nil {
+			log.Printf("Error importing calendar %s: %v", cal.Name, err)
+		} else {
+			log.Printf("Imported %d schedule exception(s) from calendar %s", n, cal.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Import reads the named calendar, or all calendars if name is empty, and
+// replaces its exceptions. It returns the number of exceptions imported.
+func (i *Importer) Import(ctx context.Context, name string) (int, error) {
+	total := 0
+	found := false
+	for _, cal := range i.calendars {
+		if name != "" && cal.Name != name {
+			continue
+		}
+		found = true
+
+		n, err := i.importCalendar(ctx, cal, time.Now())
+		if err != nil {
+			return total, fmt.Errorf("calendar %s: %w", cal.Name, err)
+		}
+		total += n
+	}
+
+	if !found {
+		return 0, fmt.Errorf("unknown calendar %q", name)
+	}
+	return total, nil
+}
+
+// importCalendar reads a calendar and replaces its exceptions with one per
+// matching event occurrence (and device) that has not ended before the date
+// of now. Dates are worked out in the time zone of each device, or the
+// default one for global exceptions. Recurring events are expanded up to
+// horizonDays ahead; those with a rule that cannot be expanded are logged and
+// skipped.
+func (i *Importer) importCalendar(ctx context.Context, cal config.CalendarConfig, now time.Time) (int, error) {
+	data, err := i.read(ctx, cal.Source)
+	if err != nil {
+		return 0, err
+	}
+
+	zones, err := i.zones(cal.Devices)
+	if err != nil {
+		return 0, err
+	}
+
+	var exceptions []*storage.ScheduleException
+	for _, zone := range zones {
+		events, err := Parse(bytes.NewReader(data), zone.location)
+		if err != nil {
+			return 0, err
+		}
+		exceptions = append(exceptions, i.expand(cal, events, zone, now)...)
+	}
+
+	if err := i.store.ReplaceScheduleExceptions(cal.Name, exceptions); err != nil {
+		return 0, err
+	}
+	return len(exceptions), nil
+}
+
+// zone is a time zone and the devices whose exceptions are dated in it
+type zone struct {
+	location *time.Location
+	devices  []string
+}
+
+// zones groups devices by the time zone of their schedules. Without devices
+// the exceptions are global and dated in the default time zone.
+func (i *Importer) zones(devices []string) ([]zone, error) {
+	if len(devices) == 0 {
+		return []zone{{location: i.enforcer.Location(nil), devices: []string{""}}}, nil
+	}
+
+	var zones []zone
+	index := make(map[*time.Location]int)
+	for _, mac := range devices {
+		config, err := i.store.GetDeviceConfig(mac)
+		if err != nil {
+			return nil, err
+		}
+		loc := i.enforcer.Location(config)
+
+		n, ok := index[loc]
+		if !ok {
+			n = len(zones)
+			index[loc] = n
+			zones = append(zones, zone{location: loc})
+		}
+		zones[n].devices = append(zones[n].devices, mac)
+	}
+	return zones, nil
+}
+
+// expand turns the matching events into exceptions for the devices of a zone
+func (i *Importer) expand(cal config.CalendarConfig, events []Event, zone zone, now time.Time) []*storage.ScheduleException {
+	now = now.In(zone.location)
+	today := now.Format("2006-01-02")
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, zone.location)
+	until := from.AddDate(0, 0, horizonDays)
+
+	var exceptions []*storage.ScheduleException
+	for _, event := range events {
+		if !matches(event, cal.Match) {
+			continue
+		}
+		occurrences, err := event.Occurrences(from, until)
+		if err != nil {
+			log.Printf("Calendar %s: skipping recurring event %q: %v", cal.Name, event.Summary, err)
+			continue
+		}
+
+		for _, occurrence := range occurrences {
+			start, end := occurrence.Dates(zone.location)
+			if end < today {
+				continue
+			}
+
+			for _, mac := range zone.devices {
+				exceptions = append(exceptions, &storage.ScheduleException{
+					MAC:            mac,
+					Name:           occurrence.Summary,
+					StartDate:      start,
+					EndDate:        end,
+					Mode:           cal.Mode,
+					DailySchedules: cal.DailySchedules,
+					CreatedBy:      "calendar",
+				})
+			}
+		}
+	}
+	return exceptions
+}
+
+// read fetches a calendar from a file or URL
+func (i *Importer) read(ctx context.Context, source string) ([]byte, error) {
+	if strings.HasPrefix(source, "webcal://") {
+		source = "https://" + strings.TrimPrefix(source, "webcal://")
+	}
+
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxCalendarSize))
+}
+
+// matches reports whether an event has one of words in its categories or
+// summary. An empty list matches every event.
+func matches(event Event, words []string) bool {
+	if len(words) == 0 {
+		return true
+	}
+
+	summary := strings.ToLower(event.Summary)
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if strings.Contains(summary, word) {
+			return true
+		}
+		for _, category := range event.Categories {
+			if strings.EqualFold(category, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
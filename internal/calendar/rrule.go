@@ -0,0 +1,375 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rule is a recurrence rule (RRULE). The FREQ, INTERVAL, COUNT, UNTIL,
+// BYMONTH, BYMONTHDAY, BYDAY and WKST parts are supported.
+type rule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	untilDate  bool // UNTIL is a DATE and includes that whole day
+	byMonth    []time.Month
+	byMonthDay []int
+	byDay      []weekdayNum
+	weekStart  time.Weekday
+}
+
+// weekdayNum is a BYDAY entry such as MO, 2TU or -1FR
+type weekdayNum struct {
+	n       int // The n-th weekday of the month or year, from the end if negative; 0 for all
+	weekday time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// parseRule parses an RRULE value, reading a floating UNTIL in loc
+func parseRule(value string, loc *time.Location) (*rule, error) {
+	r := &rule{interval: 1, weekStart: time.Monday}
+
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		key = strings.ToUpper(key)
+		val = strings.ToUpper(val)
+
+		var err error
+		switch key {
+		case "FREQ":
+			r.freq = val
+		case "INTERVAL":
+			r.interval, err = parseNumber(val, 1, 0)
+		case "COUNT":
+			r.count, err = parseNumber(val, 1, 0)
+		case "UNTIL":
+			r.until, r.untilDate, err = parseTime(val, nil, loc)
+		case "BYMONTH":
+			for _, s := range strings.Split(val, ",") {
+				var month int
+				if month, err = parseNumber(s, 1, 12); err != nil {
+					break
+				}
+				r.byMonth = append(r.byMonth, time.Month(month))
+			}
+		case "BYMONTHDAY":
+			for _, s := range strings.Split(val, ",") {
+				var day int
+				if day, err = parseNumber(s, -31, 31); err != nil {
+					break
+				}
+				if day == 0 {
+					err = fmt.Errorf("invalid day 0")
+					break
+				}
+				r.byMonthDay = append(r.byMonthDay, day)
+			}
+		case "BYDAY":
+			for _, s := range strings.Split(val, ",") {
+				var wd weekdayNum
+				if wd, err = parseWeekdayNum(s); err != nil {
+					break
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "WKST":
+			var ok bool
+			if r.weekStart, ok = weekdays[val]; !ok {
+				err = fmt.Errorf("unknown weekday %q", val)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	case "":
+		return nil, fmt.Errorf("rule without FREQ")
+	default:
+		return nil, fmt.Errorf("unsupported frequency %s", r.freq)
+	}
+	if r.count > 0 && !r.until.IsZero() {
+		return nil, fmt.Errorf("rule has both COUNT and UNTIL")
+	}
+	return r, nil
+}
+
+// parseNumber parses an integer in [min, max], without an upper bound if
+// max is 0
+func parseNumber(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil || n < min || (max != 0 && n > max) {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func parseWeekdayNum(s string) (weekdayNum, error) {
+	if len(s) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid weekday %q", s)
+	}
+	weekday, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid weekday %q", s)
+	}
+	wd := weekdayNum{weekday: weekday}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := parseNumber(prefix, -53, 53)
+		if err != nil || n == 0 {
+			return weekdayNum{}, fmt.Errorf("invalid weekday %q", s)
+		}
+		wd.n = n
+	}
+	return wd, nil
+}
+
+// Occurrences returns the occurrences of the event that do not end before
+// from and start before until. Events without a recurrence rule are returned as they
+// are, recurring ones are expanded from their start.
+func (e Event) Occurrences(from, until time.Time) ([]Event, error) {
+	if e.RRule == "" {
+		return []Event{e}, nil
+	}
+
+	r, err := parseRule(e.RRule, e.Start.Location())
+	if err != nil {
+		return nil, err
+	}
+	last := r.until
+	if r.untilDate {
+		last = time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, e.Start.Location()).Add(-time.Nanosecond)
+	}
+
+	length := e.End.Sub(e.Start)
+	var occurrences []Event
+	n := 0
+	for k := 0; ; k++ {
+		periodStart, dates := r.period(e.Start, k)
+		if !e.at(periodStart).Before(until) || (!last.IsZero() && e.at(periodStart).After(last)) {
+			return occurrences, nil
+		}
+
+		for _, date := range dates {
+			start := e.at(date)
+			if start.Before(e.Start) {
+				continue
+			}
+			if !start.Before(until) || (!last.IsZero() && start.After(last)) {
+				return occurrences, nil
+			}
+			// Excluded occurrences still count towards COUNT
+			n++
+			if r.count > 0 && n > r.count {
+				return occurrences, nil
+			}
+			if e.excluded(start) {
+				continue
+			}
+
+			occurrence := e
+			occurrence.Start, occurrence.End = start, start.Add(length)
+			occurrence.RRule, occurrence.ExDates = "", nil
+			if !occurrence.End.Before(from) {
+				occurrences = append(occurrences, occurrence)
+			}
+		}
+	}
+}
+
+// at returns the start time of the event on a date
+func (e Event) at(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), e.Start.Hour(), e.Start.Minute(), e.Start.Second(), 0, e.Start.Location())
+}
+
+// excluded reports whether an occurrence is listed in EXDATE
+func (e Event) excluded(start time.Time) bool {
+	for _, exdate := range e.ExDates {
+		if exdate.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// period returns the first date of the k-th period of the rule after the
+// one containing start, and the dates it selects in that period in order.
+// Dates are midnight UTC.
+func (r *rule) period(start time.Time, k int) (time.Time, []time.Time) {
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	step := k * r.interval
+
+	var first time.Time
+	var dates []time.Time
+	switch r.freq {
+	case "DAILY":
+		first = day.AddDate(0, 0, step)
+		if r.matchesMonthDay(first) && r.matchesWeekday(first) {
+			dates = []time.Time{first}
+		}
+
+	case "WEEKLY":
+		offset := (7 + int(day.Weekday()) - int(r.weekStart)) % 7
+		first = day.AddDate(0, 0, 7*step-offset)
+		for i := 0; i < 7; i++ {
+			date := first.AddDate(0, 0, i)
+			if len(r.byDay) > 0 && r.matchesWeekday(date) || len(r.byDay) == 0 && date.Weekday() == day.Weekday() {
+				dates = append(dates, date)
+			}
+		}
+
+	case "MONTHLY":
+		first = time.Date(day.Year(), day.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		dates = r.monthDates(first, day.Day())
+
+	case "YEARLY":
+		first = time.Date(day.Year()+step, time.January, 1, 0, 0, 0, 0, time.UTC)
+		if len(r.byDay) > 0 && len(r.byMonth) == 0 && len(r.byMonthDay) == 0 {
+			dates = r.weekdayDates(first, first.AddDate(1, 0, 0))
+			break
+		}
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []time.Month{day.Month()}
+		}
+		for _, month := range months {
+			dates = append(dates, r.monthDates(time.Date(first.Year(), month, 1, 0, 0, 0, 0, time.UTC), day.Day())...)
+		}
+	}
+
+	var selected []time.Time
+	for _, date := range dates {
+		if len(r.byMonth) == 0 || containsMonth(r.byMonth, date.Month()) {
+			selected = append(selected, date)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+	return first, selected
+}
+
+// monthDates returns the dates a rule selects in the month starting at
+// first: its BYMONTHDAY days limited to BYDAY, its BYDAY weekdays, or
+// otherwise the day of the month of the start
+func (r *rule) monthDates(first time.Time, startDay int) []time.Time {
+	next := first.AddDate(0, 1, 0)
+	days := next.AddDate(0, 0, -1).Day()
+
+	switch {
+	case len(r.byMonthDay) > 0:
+		var dates []time.Time
+		for _, day := range r.byMonthDay {
+			if day < 0 {
+				day += days + 1
+			}
+			if day < 1 || day > days {
+				continue
+			}
+			date := first.AddDate(0, 0, day-1)
+			if r.matchesWeekday(date) && !containsDate(dates, date) {
+				dates = append(dates, date)
+			}
+		}
+		return dates
+	case len(r.byDay) > 0:
+		return r.weekdayDates(first, next)
+	case startDay <= days:
+		return []time.Time{first.AddDate(0, 0, startDay-1)}
+	default:
+		// Months without the day of the start are skipped
+		return nil
+	}
+}
+
+// weekdayDates returns the BYDAY dates between first and next, where n-th
+// weekdays count within that span
+func (r *rule) weekdayDates(first, next time.Time) []time.Time {
+	var dates []time.Time
+	for _, wd := range r.byDay {
+		var matching []time.Time
+		offset := (7 + int(wd.weekday) - int(first.Weekday())) % 7
+		for date := first.AddDate(0, 0, offset); date.Before(next); date = date.AddDate(0, 0, 7) {
+			matching = append(matching, date)
+		}
+
+		switch {
+		case wd.n == 0:
+		case wd.n > 0 && wd.n <= len(matching):
+			matching = matching[wd.n-1 : wd.n]
+		case wd.n < 0 && -wd.n <= len(matching):
+			matching = matching[len(matching)+wd.n : len(matching)+wd.n+1]
+		default:
+			matching = nil
+		}
+		for _, date := range matching {
+			if !containsDate(dates, date) {
+				dates = append(dates, date)
+			}
+		}
+	}
+	return dates
+}
+
+// matchesMonthDay reports whether a date is one of the BYMONTHDAY days
+func (r *rule) matchesMonthDay(date time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	days := date.AddDate(0, 1, -date.Day()).Day()
+	for _, day := range r.byMonthDay {
+		if day == date.Day() || day < 0 && day+days+1 == date.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekday reports whether a date falls on one of the BYDAY weekdays,
+// ignoring their numbers
+func (r *rule) matchesWeekday(date time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, wd := range r.byDay {
+		if wd.weekday == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDate(dates []time.Time, date time.Time) bool {
+	for _, d := range dates {
+		if d.Equal(date) {
+			return true
+		}
+	}
+	return false
+}
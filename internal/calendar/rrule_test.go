@@ -0,0 +1,212 @@
+package calendar
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+func TestOccurrences(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, berlin)
+	until := from.AddDate(0, 0, horizonDays)
+
+	for _, tt := range []struct {
+		name    string
+		event   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "weekly by day with count",
+			event: "DTSTART;TZID=Europe/Berlin:20240101T150000\nDTEND;TZID=Europe/Berlin:20240101T170000\nRRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=5",
+			want:  []string{"2024-01-01 15:00", "2024-01-03 15:00", "2024-01-08 15:00", "2024-01-10 15:00", "2024-01-15 15:00"},
+		},
+		{
+			name:  "daily with interval and until",
+			event: "DTSTART:20240110T080000\nRRULE:FREQ=DAILY;INTERVAL=3;UNTIL=20240120T235959Z",
+			want:  []string{"2024-01-10 08:00", "2024-01-13 08:00", "2024-01-16 08:00", "2024-01-19 08:00"},
+		},
+		{
+			name:  "until date includes its day",
+			event: "DTSTART;VALUE=DATE:20240105\nRRULE:FREQ=DAILY;UNTIL=20240107",
+			want:  []string{"2024-01-05 00:00", "2024-01-06 00:00", "2024-01-07 00:00"},
+		},
+		{
+			name:  "exdates",
+			event: "DTSTART:20240101T090000\nRRULE:FREQ=DAILY;COUNT=4\nEXDATE:20240102T090000,20240103T090000",
+			want:  []string{"2024-01-01 09:00", "2024-01-04 09:00"},
+		},
+		{
+			name:  "started before from",
+			event: "DTSTART;VALUE=DATE:20231229\nRRULE:FREQ=WEEKLY;COUNT=3",
+			want:  []string{"2024-01-05 00:00", "2024-01-12 00:00"},
+		},
+		{
+			name:  "monthly last friday",
+			event: "DTSTART:20240126T180000\nRRULE:FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			want:  []string{"2024-01-26 18:00", "2024-02-23 18:00", "2024-03-29 18:00"},
+		},
+		{
+			name:  "monthly skips short months",
+			event: "DTSTART:20240131T100000\nRRULE:FREQ=MONTHLY;COUNT=3",
+			want:  []string{"2024-01-31 10:00", "2024-03-31 10:00", "2024-05-31 10:00"},
+		},
+		{
+			name:  "yearly by month across dst",
+			event: "DTSTART;TZID=Europe/Berlin:20240215T120000\nRRULE:FREQ=YEARLY;BYMONTH=2,4,11;BYMONTHDAY=15;COUNT=3",
+			want:  []string{"2024-02-15 12:00", "2024-04-15 12:00", "2024-11-15 12:00"},
+		},
+		{
+			name:  "ends within the horizon",
+			event: "DTSTART:20241201T100000\nRRULE:FREQ=MONTHLY;INTERVAL=2",
+			want:  []string{"2024-12-01 10:00"},
+		},
+		{name: "unsupported part", event: "DTSTART:20240101T100000\nRRULE:FREQ=MONTHLY;BYSETPOS=-1;BYDAY=MO,TU", wantErr: true},
+		{name: "unsupported frequency", event: "DTSTART:20240101T100000\nRRULE:FREQ=HOURLY", wantErr: true},
+		{name: "count and until", event: "DTSTART:20240101T100000\nRRULE:FREQ=DAILY;COUNT=2;UNTIL=20240105", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ics := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:test\nSUMMARY:Test\n" + tt.event + "\nEND:VEVENT\nEND:VCALENDAR\n"
+			events, err := Parse(strings.NewReader(ics), berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			occurrences, err := events[0].Occurrences(from, until)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Occurrences() = %v, want error", occurrences)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, o := range occurrences {
+				got = append(got, o.Start.Format("2006-01-02 15:04"))
+				if o.End.Sub(o.Start) != events[0].End.Sub(events[0].Start) {
+					t.Errorf("occurrence %s lasts %v, want %v", o.Start, o.End.Sub(o.Start), events[0].End.Sub(events[0].Start))
+				}
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("Occurrences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestImportRecurring imports a weekly event that started before today, with
+// a moved and a cancelled occurrence
+func TestImportRecurring(t *testing.T) {
+	ics := `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:club
+SUMMARY:Football club
+DTSTART:20240103T160000
+DTEND:20240103T180000
+RRULE:FREQ=WEEKLY;UNTIL=20240228T235959Z
+END:VEVENT
+BEGIN:VEVENT
+UID:club
+RECURRENCE-ID:20240214T160000
+SUMMARY:Football club
+DTSTART:20240215T160000
+DTEND:20240215T180000
+END:VEVENT
+BEGIN:VEVENT
+UID:club
+RECURRENCE-ID:20240221T160000
+STATUS:CANCELLED
+SUMMARY:Football club
+DTSTART:20240221T160000
+DTEND:20240221T180000
+END:VEVENT
+BEGIN:VEVENT
+UID:broken
+SUMMARY:Every hour
+DTSTART:20240201T100000
+RRULE:FREQ=HOURLY
+END:VEVENT
+END:VCALENDAR
+`
+	path := t.TempDir() + "/club.ics"
+	if err := os.WriteFile(path, []byte(ics), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemory()
+	importer, err := New([]config.CalendarConfig{{Name: "club", Source: path, Mode: storage.ExceptionBlocked}}, store, enforcer.New(store, nil, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := importer.importCalendar(context.Background(), importer.calendars[0], time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exceptions, err := store.ListScheduleExceptions(storage.ExceptionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dates []string
+	for _, e := range exceptions {
+		dates = append(dates, e.StartDate)
+	}
+	want := "2024-02-07, 2024-02-15, 2024-02-28"
+	if n != 3 || strings.Join(dates, ", ") != want {
+		t.Errorf("imported %d exceptions on %v, want %s", n, dates, want)
+	}
+}
+
+// TestImportDeviceZones dates a late evening event in the time zone of each
+// device of the calendar
+func TestImportDeviceZones(t *testing.T) {
+	ics := `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:party
+SUMMARY:Sleepover
+DTSTART:20240301T230000Z
+DTEND:20240301T235900Z
+END:VEVENT
+END:VCALENDAR
+`
+	path := t.TempDir() + "/party.ics"
+	if err := os.WriteFile(path, []byte(ics), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemory()
+	if err := store.SaveDeviceConfig(&storage.DeviceConfig{MAC: "aa:bb:cc:dd:ee:01", Timezone: "Pacific/Auckland"}); err != nil {
+		t.Fatal(err)
+	}
+	importer, err := New([]config.CalendarConfig{
+		{Name: "party", Source: path, Mode: storage.ExceptionBlocked, Devices: []string{"AA:BB:CC:DD:EE:01", "aa:bb:cc:dd:ee:02"}},
+	}, store, enforcer.New(store, nil, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importer.importCalendar(context.Background(), importer.calendars[0], time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	for mac, want := range map[string]string{"aa:bb:cc:dd:ee:01": "2024-03-02", "aa:bb:cc:dd:ee:02": "2024-03-01"} {
+		exceptions, err := store.ListScheduleExceptions(storage.ExceptionFilter{MAC: mac})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exceptions) != 1 || exceptions[0].StartDate != want || exceptions[0].EndDate != want {
+			t.Errorf("exceptions of %s = %+v, want one on %s", mac, exceptions, want)
+		}
+	}
+}
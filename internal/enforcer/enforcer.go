@@ -1,39 +1,142 @@
 package enforcer
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/nadilas/zeitpolizei/internal/network"
 	"github.com/nadilas/zeitpolizei/internal/storage"
-	"github.com/nadilas/zeitpolizei/internal/unifi"
+)
+
+// ActorSystem is recorded as the actor for transitions made by the enforcer
+// itself rather than by a user
+const ActorSystem = "system"
+
+var (
+	// ErrDeviceNotFound is returned for actions on a device that is not managed
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrNoActiveBlock is returned when granting a bonus outside of a time block
+	ErrNoActiveBlock = errors.New("no active time block")
 )
 
 // Enforcer handles checking limits and blocking/unblocking devices
 type Enforcer struct {
-	store *storage.SQLite
-	unifi *unifi.Client
+	store    storage.Store
+	network  network.Backend
+	location *time.Location // Default time zone of schedules
+
+	locations sync.Map // Time zone name -> *time.Location
+	devices   sync.Map // MAC -> *sync.Mutex serializing enforcement of a device
+
+	handlersMu sync.RWMutex
+	handlers   []EventHandler
 }
 
-// New creates a new Enforcer instance
-func New(store *storage.SQLite, unifiClient *unifi.Client) *Enforcer {
+// New creates a new Enforcer instance interpreting schedules in loc unless
+// a device has its own time zone
+func New(store storage.Store, backend network.Backend, loc *time.Location) *Enforcer {
 	return &Enforcer{
-		store: store,
-		unifi: unifiClient,
+		store:    store,
+		network:  backend,
+		location: loc,
+	}
+}
+
+// Location returns the time zone the schedules of a device are interpreted
+// in, the default one for a nil config or a device without a valid zone
+func (e *Enforcer) Location(config *storage.DeviceConfig) *time.Location {
+	if config == nil || config.Timezone == "" {
+		return e.location
+	}
+	if loc, ok := e.locations.Load(config.Timezone); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		log.Printf("Invalid time zone %q of %s, using %s: %v", config.Timezone, config.MAC, e.location, err)
+		return e.location
+	}
+	e.locations.Store(config.Timezone, loc)
+	return loc
+}
+
+// GetActiveTimeBlock finds the currently active time block for a device and
+// the date it started on in the device's time zone, taking schedule
+// exceptions into account. Between midnight and its end, a block that crosses
+// midnight belongs to the previous day, so the date is the one its usage is
+// recorded under.
+func (e *Enforcer) GetActiveTimeBlock(config *storage.DeviceConfig, now time.Time) (*storage.TimeBlock, int, string) {
+	block, index, date, _ := e.activeTimeBlock(config, now)
+	return block, index, date
+}
+
+// activeTimeBlock finds the currently active time block for a device, the
+// date it started on and the schedule exception it comes from, if any. The
+// exception is the one of that date, or of today outside all time blocks.
+//
+// Blocks are matched by the instants their start and end times fall on, so on
+// DST switch days a block starting in the skipped hour starts when the clocks
+// have gone forward, and one in the repeated hour runs once.
+func (e *Enforcer) activeTimeBlock(config *storage.DeviceConfig, now time.Time) (*storage.TimeBlock, int, string, *storage.ScheduleException) {
+	now = now.In(e.Location(config))
+
+	// An overnight block of yesterday's schedule may still be running
+	yesterday := now.AddDate(0, 0, -1)
+	schedules, exception := e.daySchedules(config, yesterday)
+	if block, index := findTimeBlock(schedules, yesterday, now); block != nil {
+		return block, index, yesterday.Format("2006-01-02"), exception
+	}
+
+	schedules, exception = e.daySchedules(config, now)
+	block, index := findTimeBlock(schedules, now, now)
+	return block, index, now.Format("2006-01-02"), exception
+}
+
+// daySchedules returns the daily schedules in effect for a device on the date
+// of day and the schedule exception they come from, if any. A "blocked"
+// exception has no time blocks, an "unlimited" one a single block without
+// limits spanning the day.
+func (e *Enforcer) daySchedules(config *storage.DeviceConfig, day time.Time) ([]storage.DaySchedule, *storage.ScheduleException) {
+	exception, err := e.ActiveException(config.MAC, day)
+	if err != nil {
+		log.Printf("Error getting schedule exceptions for %s, using the weekly schedule: %v", config.MAC, err)
+	}
+	if exception == nil {
+		return config.DailySchedules, nil
+	}
+
+	switch exception.Mode {
+	case storage.ExceptionSchedule:
+		return exception.DailySchedules, exception
+	case storage.ExceptionUnlimited:
+		return unlimitedSchedules, exception
+	default:
+		return nil, exception
 	}
 }
 
-// GetActiveTimeBlock finds the currently active time block for a device
-func (e *Enforcer) GetActiveTimeBlock(config *storage.DeviceConfig, now time.Time) (*storage.TimeBlock, int) {
-	dayName := strings.ToLower(now.Weekday().String())
-	currentTime := now.Format("15:04")
+// findTimeBlock returns the first time block of the schedules for the
+// weekday of day that started on that day and is running at now, and its
+// index
+func findTimeBlock(schedules []storage.DaySchedule, day, now time.Time) (*storage.TimeBlock, int) {
+	dayName := strings.ToLower(day.Weekday().String())
 
-	for _, schedule := range config.DailySchedules {
+	for _, schedule := range schedules {
 		if !containsDay(schedule.Days, dayName) {
 			continue
 		}
 		for i, block := range schedule.TimeBlocks {
-			if currentTime >= block.StartTime && currentTime < block.EndTime {
+			start, end, err := blockTimes(day, block.StartTime, block.EndTime)
+			if err != nil {
+				log.Printf("Skipping time block %s-%s: %v", block.StartTime, block.EndTime, err)
+				continue
+			}
+			if !now.Before(start) && now.Before(end) {
 				return &block, i
 			}
 		}
@@ -41,6 +144,33 @@ func (e *Enforcer) GetActiveTimeBlock(config *storage.DeviceConfig, now time.Tim
 	return nil, -1 // No active time block
 }
 
+// blockTimes returns when a block from startTime to endTime on the date of
+// day starts and ends, on the next day for the end of an overnight block
+func blockTimes(day time.Time, startTime, endTime string) (start, end time.Time, err error) {
+	startMinutes, err := storage.ParseClock(startTime)
+	if err != nil {
+		return start, end, err
+	}
+	endMinutes, err := storage.ParseClock(endTime)
+	if err != nil {
+		return start, end, err
+	}
+
+	start = clockTime(day, startMinutes)
+	if endMinutes <= startMinutes {
+		day = day.AddDate(0, 0, 1)
+	}
+	return start, clockTime(day, endMinutes), nil
+}
+
+// clockTime returns the instant a clock time in minutes after midnight falls
+// on at the date of day, the next midnight for 24:00. A time skipped by DST
+// is moved forward by the gap, and a repeated one is the later occurrence.
+func clockTime(day time.Time, minutes int) time.Time {
+	year, month, date := day.Date()
+	return time.Date(year, month, date, minutes/60, minutes%60, 0, 0, day.Location())
+}
+
 // containsDay checks if a day is in the schedule days list
 func containsDay(days []string, day string) bool {
 	for _, d := range days {
@@ -63,83 +193,86 @@ func containsDay(days []string, day string) bool {
 	return false
 }
 
-// CheckAndEnforce checks limits and enforces blocking if needed
+// CheckAndEnforce works out the desired state of a device and applies any
+// transition between blocked and unblocked. It compares against the stored
+// device state rather than the block usage record, so a device blocked in a
+// previous time block (or overnight for outside_hours) is unblocked as soon
+// as a block with remaining quota starts.
 func (e *Enforcer) CheckAndEnforce(mac string, config *storage.DeviceConfig, now time.Time) error {
-	activeBlock, blockIndex := e.GetActiveTimeBlock(config, now)
+	return e.enforce(mac, config, now, ActorSystem)
+}
 
-	// Handle outside time blocks
-	if activeBlock == nil {
-		if config.BlockOutside {
-			return e.BlockDevice(mac, "outside_hours")
-		}
-		return nil
-	}
+// enforce is CheckAndEnforce with the actor recorded for the transitions, so
+// changes that take effect immediately are attributed to the user who made them
+func (e *Enforcer) enforce(mac string, config *storage.DeviceConfig, now time.Time, actor string) error {
+	defer e.lockDevice(mac)()
+	return e.enforceLocked(mac, config, now, actor)
+}
 
-	// Get usage for current time block
-	date := now.Format("2006-01-02")
-	usage, err := e.store.GetOrCreateBlockUsage(
-		mac, date, blockIndex,
-		activeBlock.StartTime, activeBlock.EndTime,
-		activeBlock.LimitMinutes, activeBlock.LimitBytes,
-	)
+// lockDevice locks a device until the returned function is called. Polls and
+// actions such as bonuses or manual blocks change a device one at a time, so
+// a transition or warning is not applied twice from the same stale state.
+func (e *Enforcer) lockDevice(mac string) func() {
+	mu, _ := e.devices.LoadOrStore(strings.ToLower(mac), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// enforceLocked is enforce for a device the caller has locked
+func (e *Enforcer) enforceLocked(mac string, config *storage.DeviceConfig, now time.Time, actor string) error {
+	state, err := e.store.GetDeviceState(mac)
 	if err != nil {
 		return err
 	}
 
-	// Calculate effective limits (base + bonus)
-	effectiveLimitMinutes := addBonusInt(activeBlock.LimitMinutes, usage.BonusMinutes)
-	effectiveLimitBytes := addBonusInt64(activeBlock.LimitBytes, usage.BonusBytes)
+	decision, err := e.Decide(mac, config, state, now)
+	if err != nil {
+		return err
+	}
 
-	// Check time limit
-	if effectiveLimitMinutes != nil && usage.UsedMinutes >= *effectiveLimitMinutes {
-		if !usage.IsBlocked || usage.BlockedReason != "time_limit" {
-			log.Printf("Device %s reached time limit (%d/%d minutes)", mac, usage.UsedMinutes, *effectiveLimitMinutes)
-			if err := e.BlockDevice(mac, "time_limit"); err != nil {
+	if decision.Blocked {
+		if !state.IsBlocked || state.BlockedReason != decision.Reason {
+			log.Printf("Device %s blocked (%s)", mac, decision.Reason)
+			if err := e.blockDevice(mac, decision.Reason, actor); err != nil {
 				return err
 			}
-			usage.IsBlocked = true
-			usage.BlockedReason = "time_limit"
-			return e.store.UpdateBlockUsage(usage)
 		}
-		return nil
+	} else if state.IsBlocked {
+		log.Printf("Device %s unblocked (was %s)", mac, state.BlockedReason)
+		if err := e.unblockDevice(mac, actor); err != nil {
+			return err
+		}
 	}
 
-	// Check data limit
-	if effectiveLimitBytes != nil && usage.UsedBytes >= *effectiveLimitBytes {
-		if !usage.IsBlocked || usage.BlockedReason != "data_limit" {
-			log.Printf("Device %s reached data limit (%d/%d bytes)", mac, usage.UsedBytes, *effectiveLimitBytes)
-			if err := e.BlockDevice(mac, "data_limit"); err != nil {
-				return err
-			}
-			usage.IsBlocked = true
-			usage.BlockedReason = "data_limit"
-			return e.store.UpdateBlockUsage(usage)
-		}
+	usage := decision.Usage
+	if usage == nil {
 		return nil
 	}
 
-	// Unblock if was blocked but now has remaining quota
-	// (e.g., bonus time/data was added, or we're in a new time block)
-	if usage.IsBlocked && usage.BlockedReason != "manual" {
-		hasTimeQuota := effectiveLimitMinutes == nil || usage.UsedMinutes < *effectiveLimitMinutes
-		hasDataQuota := effectiveLimitBytes == nil || usage.UsedBytes < *effectiveLimitBytes
+	changed := e.checkWarnings(mac, decision, now)
 
-		if hasTimeQuota && hasDataQuota {
-			log.Printf("Device %s unblocked (quota available)", mac)
-			if err := e.UnblockDevice(mac); err != nil {
-				return err
-			}
-			usage.IsBlocked = false
-			usage.BlockedReason = ""
-			return e.store.UpdateBlockUsage(usage)
-		}
+	// Mirror the decision onto the active block's usage record
+	if usage.IsBlocked != decision.Blocked || usage.BlockedReason != decision.Reason {
+		usage.IsBlocked = decision.Blocked
+		usage.BlockedReason = decision.Reason
+		changed = true
 	}
 
+	if changed {
+		return e.store.UpdateBlockUsage(usage)
+	}
 	return nil
 }
 
-// BlockDevice blocks a device via UniFi and updates state
-func (e *Enforcer) BlockDevice(mac string, reason string) error {
+// BlockDevice blocks a device via the network backend and updates state,
+// recording actor as the one who made the change
+func (e *Enforcer) BlockDevice(mac string, reason string, actor string) error {
+	defer e.lockDevice(mac)()
+	return e.blockDevice(mac, reason, actor)
+}
+
+// blockDevice is BlockDevice for a device the caller has locked
+func (e *Enforcer) blockDevice(mac string, reason string, actor string) error {
 	state, err := e.store.GetDeviceState(mac)
 	if err != nil {
 		return err
@@ -150,8 +283,8 @@ func (e *Enforcer) BlockDevice(mac string, reason string) error {
 		return nil
 	}
 
-	// Block via UniFi
-	if err := e.unifi.BlockClient(mac); err != nil {
+	// Block via the network backend
+	if err := e.network.BlockClient(mac); err != nil {
 		return err
 	}
 
@@ -159,12 +292,31 @@ func (e *Enforcer) BlockDevice(mac string, reason string) error {
 	state.IsBlocked = true
 	state.BlockedReason = reason
 	state.BlockedAt = time.Now()
+	state.ChangedBy = actor
 
-	return e.store.SaveDeviceState(state)
+	if err := e.store.SaveDeviceState(state); err != nil {
+		return err
+	}
+
+	e.RecordEvent(&storage.Event{
+		Time:   state.BlockedAt,
+		Type:   storage.EventBlock,
+		MAC:    mac,
+		Actor:  actor,
+		Reason: reason,
+	})
+	return nil
 }
 
-// UnblockDevice unblocks a device via UniFi and updates state
-func (e *Enforcer) UnblockDevice(mac string) error {
+// UnblockDevice unblocks a device via the network backend and updates state,
+// recording actor as the one who made the change
+func (e *Enforcer) UnblockDevice(mac string, actor string) error {
+	defer e.lockDevice(mac)()
+	return e.unblockDevice(mac, actor)
+}
+
+// unblockDevice is UnblockDevice for a device the caller has locked
+func (e *Enforcer) unblockDevice(mac string, actor string) error {
 	state, err := e.store.GetDeviceState(mac)
 	if err != nil {
 		return err
@@ -175,29 +327,56 @@ func (e *Enforcer) UnblockDevice(mac string) error {
 		return nil
 	}
 
-	// Unblock via UniFi
-	if err := e.unifi.UnblockClient(mac); err != nil {
+	// Unblock via the network backend
+	if err := e.network.UnblockClient(mac); err != nil {
 		return err
 	}
 
 	// Update state
+	previousReason := state.BlockedReason
 	state.IsBlocked = false
 	state.BlockedReason = ""
 	state.UnblockedAt = time.Now()
+	state.ChangedBy = actor
 
-	return e.store.SaveDeviceState(state)
+	if err := e.store.SaveDeviceState(state); err != nil {
+		return err
+	}
+
+	e.RecordEvent(&storage.Event{
+		Time:   state.UnblockedAt,
+		Type:   storage.EventUnblock,
+		MAC:    mac,
+		Actor:  actor,
+		Reason: previousReason,
+	})
+	return nil
 }
 
-// ManualBlock manually blocks a device
-func (e *Enforcer) ManualBlock(mac string) error {
-	return e.BlockDevice(mac, "manual")
+// ManualBlock manually blocks a device on behalf of actor until it is
+// manually unblocked, replacing any override
+func (e *Enforcer) ManualBlock(mac string, actor string) error {
+	defer e.lockDevice(mac)()
+
+	log.Printf("Device %s manually blocked by %s", mac, actor)
+	if err := e.store.DeleteOverride(mac); err != nil {
+		return err
+	}
+	return e.blockDevice(mac, ReasonManual, actor)
 }
 
-// ManualUnblock manually unblocks a device
-func (e *Enforcer) ManualUnblock(mac string) error {
+// ManualUnblock manually unblocks a device on behalf of actor for the rest of
+// the active time block, replacing any override
+func (e *Enforcer) ManualUnblock(mac string, actor string) error {
+	defer e.lockDevice(mac)()
+
+	log.Printf("Device %s manually unblocked by %s", mac, actor)
+	if err := e.store.DeleteOverride(mac); err != nil {
+		return err
+	}
+
 	// Get current usage to update blocked status
 	now := time.Now()
-	date := now.Format("2006-01-02")
 
 	config, err := e.store.GetDeviceConfig(mac)
 	if err != nil {
@@ -205,7 +384,7 @@ func (e *Enforcer) ManualUnblock(mac string) error {
 	}
 
 	if config != nil {
-		activeBlock, blockIndex := e.GetActiveTimeBlock(config, now)
+		activeBlock, blockIndex, date := e.GetActiveTimeBlock(config, now)
 		if activeBlock != nil {
 			usage, err := e.store.GetOrCreateBlockUsage(
 				mac, date, blockIndex,
@@ -223,7 +402,134 @@ func (e *Enforcer) ManualUnblock(mac string) error {
 		}
 	}
 
-	return e.UnblockDevice(mac)
+	return e.unblockDevice(mac, actor)
+}
+
+// SaveDeviceConfig creates or updates a device configuration on behalf of actor
+func (e *Enforcer) SaveDeviceConfig(config *storage.DeviceConfig, actor string) error {
+	config.UpdatedBy = actor
+	if err := e.store.SaveDeviceConfig(config); err != nil {
+		return err
+	}
+
+	e.RecordEvent(&storage.Event{
+		Type:    storage.EventConfigSaved,
+		MAC:     config.MAC,
+		Actor:   actor,
+		Details: config.Name,
+	})
+	return nil
+}
+
+// DeleteDeviceConfig removes a device from management on behalf of actor and
+// lifts any block Zeitpolizei placed on it
+func (e *Enforcer) DeleteDeviceConfig(mac string, actor string) error {
+	defer e.lockDevice(mac)()
+
+	if err := e.store.DeleteDeviceConfig(mac); err != nil {
+		return err
+	}
+	if err := e.store.DeleteOverride(mac); err != nil {
+		log.Printf("Error removing override of %s: %v", mac, err)
+	}
+
+	e.RecordEvent(&storage.Event{
+		Type:  storage.EventConfigDeleted,
+		MAC:   mac,
+		Actor: actor,
+	})
+
+	if err := e.unblockDevice(mac, actor); err != nil {
+		log.Printf("Error unblocking removed device %s: %v", mac, err)
+	}
+	return nil
+}
+
+// AddBonusTime grants extra minutes in the device's active time block on
+// behalf of actor and re-checks enforcement, which may unblock the device
+func (e *Enforcer) AddBonusTime(mac string, minutes int, actor string) error {
+	defer e.lockDevice(mac)()
+
+	now := time.Now()
+	config, usage, err := e.activeUsage(mac, now)
+	if err != nil {
+		return err
+	}
+
+	if err := e.store.AddBonusTime(mac, usage.Date, usage.BlockIndex, minutes); err != nil {
+		return err
+	}
+
+	log.Printf("Added %d bonus minutes for %s (by %s)", minutes, mac, actor)
+	e.RecordEvent(&storage.Event{
+		Time:    now,
+		Type:    storage.EventBonusTime,
+		MAC:     mac,
+		Actor:   actor,
+		Details: fmt.Sprintf("+%d minutes", minutes),
+	})
+
+	if err := e.enforceLocked(mac, config, now, ActorSystem); err != nil {
+		log.Printf("Error enforcing %s after bonus: %v", mac, err)
+	}
+	return nil
+}
+
+// AddBonusData grants extra bytes in the device's active time block on
+// behalf of actor and re-checks enforcement, which may unblock the device
+func (e *Enforcer) AddBonusData(mac string, bytes int64, actor string) error {
+	defer e.lockDevice(mac)()
+
+	now := time.Now()
+	config, usage, err := e.activeUsage(mac, now)
+	if err != nil {
+		return err
+	}
+
+	if err := e.store.AddBonusData(mac, usage.Date, usage.BlockIndex, bytes); err != nil {
+		return err
+	}
+
+	log.Printf("Added %d bonus bytes for %s (by %s)", bytes, mac, actor)
+	e.RecordEvent(&storage.Event{
+		Time:    now,
+		Type:    storage.EventBonusData,
+		MAC:     mac,
+		Actor:   actor,
+		Details: fmt.Sprintf("+%d bytes", bytes),
+	})
+
+	if err := e.enforceLocked(mac, config, now, ActorSystem); err != nil {
+		log.Printf("Error enforcing %s after bonus: %v", mac, err)
+	}
+	return nil
+}
+
+// activeUsage returns the config of a managed device and the usage record of
+// its active time block, creating the record if the block just started
+func (e *Enforcer) activeUsage(mac string, now time.Time) (*storage.DeviceConfig, *storage.BlockUsage, error) {
+	config, err := e.store.GetDeviceConfig(mac)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config == nil {
+		return nil, nil, ErrDeviceNotFound
+	}
+
+	activeBlock, blockIndex, date := e.GetActiveTimeBlock(config, now)
+	if activeBlock == nil {
+		return nil, nil, ErrNoActiveBlock
+	}
+
+	usage, err := e.store.GetOrCreateBlockUsage(
+		mac, date, blockIndex,
+		activeBlock.StartTime, activeBlock.EndTime,
+		activeBlock.LimitMinutes, activeBlock.LimitBytes,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, usage, nil
 }
 
 // addBonusInt adds bonus to a limit, returning nil if base is nil
@@ -0,0 +1,170 @@
+package enforcer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/network"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+func TestBlockTimes(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	day := time.Date(2024, 3, 30, 0, 0, 0, 0, berlin) // DST starts the next night
+
+	for _, tt := range []struct {
+		start, end         string
+		wantStart, wantEnd string
+		wantErr            bool
+	}{
+		{start: "09:00", end: "17:00", wantStart: "2024-03-30 09:00", wantEnd: "2024-03-30 17:00"},
+		{start: "09:30", end: "10:00", wantStart: "2024-03-30 09:30", wantEnd: "2024-03-30 10:00"},
+		{start: "20:00", end: "01:00", wantStart: "2024-03-30 20:00", wantEnd: "2024-03-31 01:00"},
+		{start: "22:00", end: "22:00", wantStart: "2024-03-30 22:00", wantEnd: "2024-03-31 22:00"},
+		{start: "00:00", end: "24:00", wantStart: "2024-03-30 00:00", wantEnd: "2024-03-31 00:00"},
+		{start: "23:00", end: "02:30", wantStart: "2024-03-30 23:00", wantEnd: "2024-03-31 03:30"}, // 02:30 is skipped
+		{start: "9:00", end: "17:00", wantErr: true},
+		{start: "09:00", end: "abc", wantErr: true},
+		{start: "09:00", end: "24:30", wantErr: true},
+		{start: "09:60", end: "10:00", wantErr: true},
+	} {
+		start, end, err := blockTimes(day, tt.start, tt.end)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("blockTimes(%s, %s) = %v, %v, want error", tt.start, tt.end, start, end)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("blockTimes(%s, %s): %v", tt.start, tt.end, err)
+			continue
+		}
+		if got := start.Format("2006-01-02 15:04"); got != tt.wantStart {
+			t.Errorf("blockTimes(%s, %s) start = %s, want %s", tt.start, tt.end, got, tt.wantStart)
+		}
+		if got := end.Format("2006-01-02 15:04"); got != tt.wantEnd {
+			t.Errorf("blockTimes(%s, %s) end = %s, want %s", tt.start, tt.end, got, tt.wantEnd)
+		}
+	}
+}
+
+// yieldingStore pauses after reading a usage record, so concurrent callers
+// interleave their read-modify-writes of the record unless they are serialized
+type yieldingStore struct {
+	storage.Store
+}
+
+func (s yieldingStore) GetOrCreateBlockUsage(mac, date string, blockIndex int, startTime, endTime string, limitMinutes *int, limitBytes *int64) (*storage.BlockUsage, error) {
+	usage, err := s.Store.GetOrCreateBlockUsage(mac, date, blockIndex, startTime, endTime, limitMinutes, limitBytes)
+	time.Sleep(time.Millisecond)
+	return usage, err
+}
+
+// TestManualUnblockDuringPolls runs manual unblocks alongside polls of the
+// same device; the warning crossed before them must be recorded only once.
+// Run with -race to also check the per-device locking.
+func TestManualUnblockDuringPolls(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	limit := 60
+	config := &storage.DeviceConfig{
+		MAC:     mac,
+		Enabled: true,
+		DailySchedules: []storage.DaySchedule{{
+			Days:       []string{"weekdays", "weekends"},
+			TimeBlocks: []storage.TimeBlock{{StartTime: "00:00", EndTime: "24:00", LimitMinutes: &limit}},
+		}},
+	}
+	store := storage.NewMemory()
+	if err := store.SaveDeviceConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	e := New(yieldingStore{store}, network.NewMemory(network.ClientInfo{MAC: mac}), time.UTC)
+
+	// 50 of 60 minutes used is past the default warning threshold
+	block, index, date := e.GetActiveTimeBlock(config, time.Now())
+	usage, err := store.GetOrCreateBlockUsage(mac, date, index, block.StartTime, block.EndTime, block.LimitMinutes, block.LimitBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddBlockUsage(usage.ID, 50*60, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := e.CheckAndEnforce(mac, config, time.Now()); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := e.ManualUnblock(mac, "parent"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	events, err := store.ListEvents(storage.EventFilter{MAC: mac, Type: storage.EventWarning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("recorded %d warnings, want 1", len(events))
+	}
+	if blocked, reason, _ := e.IsDeviceBlocked(mac); blocked {
+		t.Errorf("device blocked (%s), want unblocked", reason)
+	}
+}
+
+// TestReconcileDisabled unblocks disabled devices, whether Zeitpolizei left
+// them blocked or they were blocked on the network only
+func TestReconcileDisabled(t *testing.T) {
+	const left, drifted = "aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02"
+
+	store := storage.NewMemory()
+	backend := network.NewMemory(network.ClientInfo{MAC: left}, network.ClientInfo{MAC: drifted})
+	e := New(store, backend, time.UTC)
+	for _, mac := range []string{left, drifted} {
+		if err := store.SaveDeviceConfig(&storage.DeviceConfig{MAC: mac, Enabled: false}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.BlockDevice(left, "time_limit", ActorSystem); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.BlockClient(drifted); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Reconcile(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	blocked, err := backend.GetBlockedClients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 0 {
+		t.Errorf("network has %d clients blocked, want none", len(blocked))
+	}
+	if blocked, reason, _ := e.IsDeviceBlocked(left); blocked {
+		t.Errorf("device blocked (%s), want unblocked", reason)
+	}
+	if events, err := store.ListEvents(storage.EventFilter{MAC: left, Type: storage.EventUnblock}); err != nil || len(events) != 1 {
+		t.Errorf("recorded %d unblocks (%v), want 1", len(events), err)
+	}
+	if events, err := store.GetDriftEvents(drifted, 10); err != nil || len(events) != 1 || events[0].WantBlocked || !events[0].Corrected {
+		t.Errorf("drift events = %v (%v), want one corrected unblock", events, err)
+	}
+}
@@ -0,0 +1,73 @@
+package enforcer
+
+import (
+	"log"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// EventHandler is called with every recorded event. Handlers run on the
+// enforcement path, so they must not block; slow work such as sending
+// notifications belongs in a goroutine or queue.
+type EventHandler func(event *storage.Event)
+
+// AddHandler registers a handler for events recorded from now on
+func (e *Enforcer) AddHandler(handler EventHandler) {
+	e.handlersMu.Lock()
+	defer e.handlersMu.Unlock()
+	e.handlers = append(e.handlers, handler)
+}
+
+// RecordEvent appends an event to the audit log, filling in the time and the
+// device's usage and effective limits in its active time block, and passes it
+// on to the registered handlers. Failures are logged rather than returned so
+// auditing never gets in the way of enforcement.
+func (e *Enforcer) RecordEvent(event *storage.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	e.snapshotUsage(event)
+
+	if err := e.store.SaveEvent(event); err != nil {
+		log.Printf("Error recording %s event for %s: %v", event.Type, event.MAC, err)
+	}
+
+	e.handlersMu.RLock()
+	handlers := e.handlers
+	e.handlersMu.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// snapshotUsage copies the usage of the device's active time block into event
+func (e *Enforcer) snapshotUsage(event *storage.Event) {
+	if event.MAC == "" {
+		return
+	}
+
+	config, err := e.store.GetDeviceConfig(event.MAC)
+	if err != nil || config == nil {
+		return
+	}
+
+	activeBlock, blockIndex, date := e.GetActiveTimeBlock(config, event.Time)
+	if activeBlock == nil {
+		return
+	}
+
+	usages, err := e.store.GetBlockUsageForDate(event.MAC, date)
+	if err != nil {
+		return
+	}
+	for _, usage := range usages {
+		if usage.BlockIndex == blockIndex {
+			event.UsedMinutes = usage.UsedMinutes()
+			event.UsedBytes = usage.UsedBytes
+			event.LimitMinutes = addBonusInt(usage.LimitMinutes, usage.BonusMinutes)
+			event.LimitBytes = addBonusInt64(usage.LimitBytes, usage.BonusBytes)
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package enforcer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// unlimitedSchedules are the schedules of a day without limits
+var unlimitedSchedules = []storage.DaySchedule{{
+	Days:       []string{"weekdays", "weekends"},
+	TimeBlocks: []storage.TimeBlock{{StartTime: "00:00", EndTime: "24:00"}},
+}}
+
+// ActiveException returns the schedule exception in effect for a device on
+// the date of now, nil if there is none. The device's own exceptions take
+// precedence over global ones, and among those the one that started last
+// wins, so a sick day during the school holidays applies.
+func (e *Enforcer) ActiveException(mac string, now time.Time) (*storage.ScheduleException, error) {
+	exceptions, err := e.store.ListScheduleExceptions(storage.ExceptionFilter{
+		MAC:  strings.ToLower(mac),
+		Date: now.Format("2006-01-02"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var active *storage.ScheduleException
+	for _, exception := range exceptions {
+		if active == nil || precedes(exception, active) {
+			active = exception
+		}
+	}
+	return active, nil
+}
+
+// precedes reports whether exception a takes precedence over b
+func precedes(a, b *storage.ScheduleException) bool {
+	if (a.MAC != "") != (b.MAC != "") {
+		return a.MAC != ""
+	}
+	if a.StartDate != b.StartDate {
+		return a.StartDate > b.StartDate
+	}
+	return a.ID > b.ID
+}
+
+// SaveScheduleException creates or updates a schedule exception on behalf of actor
+func (e *Enforcer) SaveScheduleException(exception *storage.ScheduleException, actor string) error {
+	var err error
+	if exception.ID == 0 {
+		exception.CreatedBy = actor
+		err = e.store.CreateScheduleException(exception)
+	} else {
+		err = e.store.UpdateScheduleException(exception)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.RecordEvent(&storage.Event{
+		Type:    storage.EventExceptionSaved,
+		MAC:     exception.MAC,
+		Actor:   actor,
+		Details: describeException(exception),
+	})
+	return nil
+}
+
+// DeleteScheduleException removes a schedule exception on behalf of actor
+func (e *Enforcer) DeleteScheduleException(exception *storage.ScheduleException, actor string) error {
+	if err := e.store.DeleteScheduleException(exception.ID); err != nil {
+		return err
+	}
+
+	e.RecordEvent(&storage.Event{
+		Type:    storage.EventExceptionDeleted,
+		MAC:     exception.MAC,
+		Actor:   actor,
+		Details: describeException(exception),
+	})
+	return nil
+}
+
+// describeException summarizes an exception for the audit log
+func describeException(exception *storage.ScheduleException) string {
+	dates := exception.StartDate
+	if exception.EndDate != exception.StartDate {
+		dates += " to " + exception.EndDate
+	}
+	if exception.Name == "" {
+		return fmt.Sprintf("%s, %s", dates, exception.Mode)
+	}
+	return fmt.Sprintf("%s: %s, %s", exception.Name, dates, exception.Mode)
+}
@@ -0,0 +1,133 @@
+package enforcer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+var (
+	// ErrOverrideExpired is returned for an override that would already have expired
+	ErrOverrideExpired = errors.New("override expires in the past")
+	// ErrNoOverride is returned when cancelling an override a device does not have
+	ErrNoOverride = errors.New("no override")
+)
+
+// SetOverride blocks or unblocks a device until expiresAt on behalf of actor,
+// regardless of its schedule, limits and any manual block. It replaces the
+// previous override of the device. Once it expires, the schedule applies
+// again.
+func (e *Enforcer) SetOverride(mac, action string, expiresAt time.Time, actor string) (*storage.Override, error) {
+	if action != storage.OverrideBlock && action != storage.OverrideUnblock {
+		return nil, fmt.Errorf("unknown override action %q", action)
+	}
+
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return nil, ErrOverrideExpired
+	}
+
+	config, err := e.store.GetDeviceConfig(mac)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	override := &storage.Override{
+		MAC:       mac,
+		Action:    action,
+		ExpiresAt: expiresAt,
+		CreatedBy: actor,
+		CreatedAt: now,
+	}
+	if err := e.store.SaveOverride(override); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Device %s %sed until %s by %s", mac, action, expiresAt.Format(time.RFC3339), actor)
+	e.RecordEvent(&storage.Event{
+		Time:    now,
+		Type:    storage.EventOverride,
+		MAC:     mac,
+		Actor:   actor,
+		Details: fmt.Sprintf("%sed until %s", action, formatUntil(expiresAt, now, e.Location(config))),
+	})
+
+	if err := e.enforce(mac, config, now, actor); err != nil {
+		return nil, err
+	}
+	return override, nil
+}
+
+// ClearOverride cancels the override of a device on behalf of actor, so its
+// schedule applies again right away
+func (e *Enforcer) ClearOverride(mac string, actor string) error {
+	override, err := e.store.GetOverride(mac)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if override == nil || !override.Active(now) {
+		return ErrNoOverride
+	}
+
+	if err := e.store.DeleteOverride(mac); err != nil {
+		return err
+	}
+
+	log.Printf("Override for %s cancelled by %s", mac, actor)
+	e.RecordEvent(&storage.Event{
+		Time:    now,
+		Type:    storage.EventOverride,
+		MAC:     mac,
+		Actor:   actor,
+		Details: fmt.Sprintf("%s override cancelled", override.Action),
+	})
+
+	config, err := e.store.GetDeviceConfig(mac)
+	if err != nil || config == nil {
+		return err
+	}
+	return e.enforce(mac, config, now, actor)
+}
+
+// activeOverride returns the override of a device in effect at now. An
+// expired override is removed, so it is only reported once.
+func (e *Enforcer) activeOverride(mac string, now time.Time) (*storage.Override, error) {
+	override, err := e.store.GetOverride(mac)
+	if err != nil || override == nil {
+		return nil, err
+	}
+	if override.Active(now) {
+		return override, nil
+	}
+
+	if err := e.store.DeleteOverride(mac); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Override for %s expired", mac)
+	e.RecordEvent(&storage.Event{
+		Time:    now,
+		Type:    storage.EventOverride,
+		MAC:     mac,
+		Actor:   ActorSystem,
+		Details: fmt.Sprintf("%s override expired", override.Action),
+	})
+	return nil, nil
+}
+
+// formatUntil formats the end of an override in loc, with the day unless it
+// is today
+func formatUntil(t, now time.Time, loc *time.Location) string {
+	t, now = t.In(loc), now.In(loc)
+	if t.Format("2006-01-02") == now.Format("2006-01-02") {
+		return t.Format("15:04")
+	}
+	return t.Format("Mon Jan 2 15:04")
+}
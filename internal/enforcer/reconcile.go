@@ -0,0 +1,93 @@
+package enforcer
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Reconcile compares the desired blocking state of every managed device with
+// the clients the network backend actually has blocked and corrects any
+// drift. BlockDevice and UnblockDevice skip the backend call when the stored
+// state already matches, so a device unblocked in the UniFi app (or a stamgr
+// command that silently failed) would otherwise stay out of sync forever.
+// Disabled devices are no longer enforced, so their desired state is
+// unblocked.
+func (e *Enforcer) Reconcile(now time.Time) error {
+	configs, err := e.store.GetAllDeviceConfigs()
+	if err != nil {
+		return err
+	}
+
+	blockedClients, err := e.network.GetBlockedClients()
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[string]bool)
+	for _, client := range blockedClients {
+		blocked[strings.ToLower(client.MAC)] = true
+	}
+
+	for _, config := range configs {
+		mac := strings.ToLower(config.MAC)
+		e.reconcileDevice(mac, config.Enabled, blocked[mac], now)
+	}
+
+	return nil
+}
+
+// reconcileDevice corrects the network state of a device if it differs from
+// the stored state, locking the device so a concurrent manual block or
+// unblock is not undone from the state read before it. A disabled device
+// that was left blocked is unblocked.
+func (e *Enforcer) reconcileDevice(mac string, enabled bool, isBlocked bool, now time.Time) {
+	defer e.lockDevice(mac)()
+
+	state, err := e.store.GetDeviceState(mac)
+	if err != nil {
+		log.Printf("Error getting state for %s during reconciliation: %v", mac, err)
+		return
+	}
+
+	if !enabled && state.IsBlocked {
+		log.Printf("Device %s unblocked (disabled, was %s)", mac, state.BlockedReason)
+		if err := e.unblockDevice(mac, ActorSystem); err != nil {
+			log.Printf("Error unblocking disabled device %s: %v", mac, err)
+		}
+		return
+	}
+
+	if state.IsBlocked == isBlocked {
+		return
+	}
+
+	event := &storage.DriftEvent{
+		MAC:           mac,
+		WantBlocked:   state.IsBlocked,
+		WasBlocked:    isBlocked,
+		BlockedReason: state.BlockedReason,
+		DetectedAt:    now,
+	}
+
+	if state.IsBlocked {
+		log.Printf("Drift detected: device %s should be blocked (%s) but is not blocked on the network", mac, state.BlockedReason)
+		err = e.network.BlockClient(mac)
+	} else {
+		log.Printf("Drift detected: device %s should be unblocked but is blocked on the network", mac)
+		err = e.network.UnblockClient(mac)
+	}
+
+	if err != nil {
+		log.Printf("Error correcting drift for %s: %v", mac, err)
+		event.Error = err.Error()
+	} else {
+		event.Corrected = true
+	}
+
+	if err := e.store.SaveDriftEvent(event); err != nil {
+		log.Printf("Error recording drift event for %s: %v", mac, err)
+	}
+}
@@ -0,0 +1,103 @@
+package enforcer
+
+import (
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Block reasons stored in device states and block usage records
+const (
+	ReasonTimeLimit    = "time_limit"
+	ReasonDataLimit    = "data_limit"
+	ReasonOutsideHours = "outside_hours"
+	ReasonManual       = "manual"
+	ReasonOverride     = "override"
+	ReasonException    = "exception"
+)
+
+// Decision is the desired blocking state of a device at a point in time
+type Decision struct {
+	Blocked bool
+	Reason  string
+	// Block is the active time block, nil outside all time blocks
+	Block *storage.TimeBlock
+	// Usage is the usage record of the active time block, nil outside all time blocks
+	Usage *storage.BlockUsage
+	// Override is the override in effect, if any
+	Override *storage.Override
+	// Exception is the schedule exception in effect, if any
+	Exception *storage.ScheduleException
+}
+
+// Decide works out the desired state of a device from, in order of precedence,
+// a timed override, a manual block, a "blocked" schedule exception, the
+// outside-hours rule and the usage of the active time block against its
+// limits (including bonus)
+func (e *Enforcer) Decide(mac string, config *storage.DeviceConfig, state *storage.DeviceState, now time.Time) (*Decision, error) {
+	decision := &Decision{}
+
+	activeBlock, blockIndex, date, exception := e.activeTimeBlock(config, now)
+	decision.Exception = exception
+	if activeBlock != nil {
+		usage, err := e.store.GetOrCreateBlockUsage(
+			mac, date, blockIndex,
+			activeBlock.StartTime, activeBlock.EndTime,
+			activeBlock.LimitMinutes, activeBlock.LimitBytes,
+		)
+		if err != nil {
+			return nil, err
+		}
+		decision.Block = activeBlock
+		decision.Usage = usage
+	}
+
+	override, err := e.activeOverride(mac, now)
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		decision.Override = override
+		if override.Action == storage.OverrideBlock {
+			decision.Blocked = true
+			decision.Reason = ReasonOverride
+		}
+		return decision, nil
+	}
+
+	// A manual block lasts until it is manually lifted
+	if state.IsBlocked && state.BlockedReason == ReasonManual {
+		decision.Blocked = true
+		decision.Reason = ReasonManual
+		return decision, nil
+	}
+
+	if exception != nil && exception.Mode == storage.ExceptionBlocked {
+		decision.Blocked = true
+		decision.Reason = ReasonException
+		return decision, nil
+	}
+
+	if activeBlock == nil {
+		if config.BlockOutside {
+			decision.Blocked = true
+			decision.Reason = ReasonOutsideHours
+		}
+		return decision, nil
+	}
+
+	usage := decision.Usage
+	effectiveLimitMinutes := addBonusInt(activeBlock.LimitMinutes, usage.BonusMinutes)
+	effectiveLimitBytes := addBonusInt64(activeBlock.LimitBytes, usage.BonusBytes)
+
+	switch {
+	case effectiveLimitMinutes != nil && usage.UsedSeconds >= *effectiveLimitMinutes*60:
+		decision.Blocked = true
+		decision.Reason = ReasonTimeLimit
+	case effectiveLimitBytes != nil && usage.UsedBytes >= *effectiveLimitBytes:
+		decision.Blocked = true
+		decision.Reason = ReasonDataLimit
+	}
+
+	return decision, nil
+}
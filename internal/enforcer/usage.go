@@ -0,0 +1,107 @@
+package enforcer
+
+import (
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// UsageSummary builds the usage summary of a device for the schedule day of
+// now in its time zone, which is the previous day while an overnight block of
+// it is running
+func (e *Enforcer) UsageSummary(config *storage.DeviceConfig, now time.Time) (*storage.UsageSummary, error) {
+	now = now.In(e.Location(config))
+	activeBlock, activeIndex, date := e.GetActiveTimeBlock(config, now)
+
+	usages, err := e.store.GetBlockUsageForDate(config.MAC, date)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &storage.UsageSummary{
+		MAC:  config.MAC,
+		Name: config.Name,
+		Date: date,
+	}
+
+	// Calculate totals and build block summaries
+	for _, usage := range usages {
+		summary.TodayTotal.UsedSeconds += usage.UsedSeconds
+		summary.TodayTotal.UsedBytes += usage.UsedBytes
+
+		blockSummary := usage.Summary()
+
+		// Check if this is the active block
+		if activeBlock != nil && usage.BlockIndex == activeIndex {
+			blockSummary.Active = true
+		} else if !now.Before(blockEnd(usage, now.Location())) {
+			blockSummary.Completed = true
+		}
+
+		summary.AllBlocksToday = append(summary.AllBlocksToday, blockSummary)
+	}
+
+	summary.TodayTotal.UsedMinutes = summary.TodayTotal.UsedSeconds / 60
+
+	// Build current block info
+	if activeBlock != nil {
+		for _, usage := range usages {
+			if usage.BlockIndex == activeIndex {
+				currentBlock := &storage.CurrentBlock{
+					StartTime:     usage.StartTime,
+					EndTime:       usage.EndTime,
+					LimitMinutes:  usage.LimitMinutes,
+					LimitBytes:    usage.LimitBytes,
+					UsedMinutes:   usage.UsedMinutes(),
+					UsedSeconds:   usage.UsedSeconds,
+					UsedBytes:     usage.UsedBytes,
+					IsBlocked:     usage.IsBlocked,
+					BlockedReason: usage.BlockedReason,
+					BonusMinutes:  usage.BonusMinutes,
+					BonusBytes:    usage.BonusBytes,
+				}
+
+				// Calculate remaining
+				if usage.LimitMinutes != nil {
+					limit := *usage.LimitMinutes + usage.BonusMinutes
+					remaining := remainingMinutes(limit, usage.UsedSeconds)
+					remainingSeconds := max(limit*60-usage.UsedSeconds, 0)
+					currentBlock.RemainingMinutes = &remaining
+					currentBlock.RemainingSeconds = &remainingSeconds
+				}
+				if usage.LimitBytes != nil {
+					remaining := *usage.LimitBytes + usage.BonusBytes - usage.UsedBytes
+					if remaining < 0 {
+						remaining = 0
+					}
+					currentBlock.RemainingBytes = &remaining
+				}
+
+				summary.CurrentBlock = currentBlock
+				break
+			}
+		}
+	}
+
+	return summary, nil
+}
+
+// remainingMinutes returns the time left under a limit in minutes, counting
+// a started minute as left so that used and remaining add up to the limit
+func remainingMinutes(limitMinutes, usedSeconds int) int {
+	return max(limitMinutes*60-usedSeconds+59, 0) / 60
+}
+
+// blockEnd returns when the time block of a usage record ends, on the day
+// after its date for an overnight block
+func blockEnd(usage *storage.BlockUsage, loc *time.Location) time.Time {
+	date, err := time.ParseInLocation("2006-01-02", usage.Date, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	_, end, err := blockTimes(date, usage.StartTime, usage.EndTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return end
+}
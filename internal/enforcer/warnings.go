@@ -0,0 +1,75 @@
+package enforcer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// DefaultWarningThresholdPercent applies to time blocks without a threshold
+const DefaultWarningThresholdPercent = 80
+
+// Warning reasons recorded in warning events
+const (
+	WarningTimeThreshold = "time_threshold"
+	WarningDataThreshold = "data_threshold"
+	WarningMinutesLeft   = "minutes_left"
+)
+
+// Bits of BlockUsage.WarningsSent, one per warning reason
+const (
+	warnedTimeThreshold = 1 << iota
+	warnedDataThreshold
+	warnedMinutesLeft
+)
+
+// checkWarnings emits each warning of the active time block at most once,
+// when usage first crosses its mark. A warning is re-armed when bonus moves
+// the limit away again. It reports whether usage.WarningsSent changed.
+func (e *Enforcer) checkWarnings(mac string, decision *Decision, now time.Time) bool {
+	block, usage := decision.Block, decision.Usage
+	if decision.Blocked || block == nil || usage == nil {
+		return false
+	}
+
+	threshold := block.WarningThresholdPercent
+	if threshold <= 0 || threshold >= 100 {
+		threshold = DefaultWarningThresholdPercent
+	}
+	limitMinutes := addBonusInt(block.LimitMinutes, usage.BonusMinutes)
+	limitBytes := addBonusInt64(block.LimitBytes, usage.BonusBytes)
+
+	sent := usage.WarningsSent
+	warn := func(bit int, reached bool, reason, details string) {
+		switch {
+		case reached && usage.WarningsSent&bit == 0:
+			usage.WarningsSent |= bit
+			e.RecordEvent(&storage.Event{
+				Time:    now,
+				Type:    storage.EventWarning,
+				MAC:     mac,
+				Actor:   ActorSystem,
+				Reason:  reason,
+				Details: details,
+			})
+		case !reached:
+			usage.WarningsSent &^= bit
+		}
+	}
+
+	if limitMinutes != nil {
+		warn(warnedTimeThreshold, usage.UsedSeconds*100 >= *limitMinutes*60*threshold,
+			WarningTimeThreshold, fmt.Sprintf("%d%% of time limit used", threshold))
+
+		left := remainingMinutes(*limitMinutes, usage.UsedSeconds)
+		warn(warnedMinutesLeft, block.WarningMinutesLeft > 0 && left <= block.WarningMinutesLeft,
+			WarningMinutesLeft, fmt.Sprintf("%d minutes left", left))
+	}
+	if limitBytes != nil {
+		warn(warnedDataThreshold, usage.UsedBytes*100 >= *limitBytes*int64(threshold),
+			WarningDataThreshold, fmt.Sprintf("%d%% of data limit used", threshold))
+	}
+
+	return usage.WarningsSent != sent
+}
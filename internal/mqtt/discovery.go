@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// entity is a Home Assistant entity announced for every managed device
+type entity struct {
+	component string
+	object    string
+	name      string
+	config    map[string]interface{}
+}
+
+// entities returns the entities of a device
+func (p *Publisher) entities(id string) []entity {
+	stateTopic := p.topic(id, "state")
+	blocked := "{{ 'ON' if value_json.blocked else 'OFF' }}"
+
+	return []entity{
+		{"sensor", "remaining_minutes", "Remaining time", map[string]interface{}{
+			"state_topic":         stateTopic,
+			"value_template":      "{{ value_json.remaining_minutes }}",
+			"unit_of_measurement": "min",
+			"device_class":        "duration",
+			"icon":                "mdi:timer-sand",
+		}},
+		{"sensor", "used_minutes", "Time used today", map[string]interface{}{
+			"state_topic":         stateTopic,
+			"value_template":      "{{ value_json.used_minutes }}",
+			"unit_of_measurement": "min",
+			"device_class":        "duration",
+			"state_class":         "total_increasing",
+		}},
+		{"sensor", "used_bytes", "Data used today", map[string]interface{}{
+			"state_topic":         stateTopic,
+			"value_template":      "{{ value_json.used_bytes }}",
+			"unit_of_measurement": "B",
+			"device_class":        "data_size",
+			"state_class":         "total_increasing",
+		}},
+		{"binary_sensor", "blocked", "Blocked", map[string]interface{}{
+			"state_topic":           stateTopic,
+			"value_template":        blocked,
+			"icon":                  "mdi:lan-disconnect",
+			"json_attributes_topic": stateTopic,
+		}},
+		{"switch", "block", "Block", map[string]interface{}{
+			"state_topic":    stateTopic,
+			"value_template": blocked,
+			"command_topic":  p.topic(id, "block", "set"),
+			"payload_on":     payloadOn,
+			"payload_off":    payloadOff,
+			"icon":           "mdi:cancel",
+		}},
+		{"button", "bonus", fmt.Sprintf("+%d min", p.config.BonusMinutes), map[string]interface{}{
+			"command_topic": p.topic(id, "bonus", "set"),
+			"payload_press": payloadPress,
+			"icon":          "mdi:clock-plus-outline",
+		}},
+	}
+}
+
+// announce publishes the discovery payloads of a device
+func (p *Publisher) announce(config *storage.DeviceConfig) error {
+	id := deviceID(config.MAC)
+	name := config.Name
+	if name == "" {
+		name = config.MAC
+	}
+	device := map[string]interface{}{
+		"identifiers":  []string{"zeitpolizei_" + id},
+		"connections":  [][]string{{"mac", strings.ToLower(config.MAC)}},
+		"name":         name,
+		"manufacturer": "Zeitpolizei",
+	}
+
+	for _, e := range p.entities(id) {
+		payload := map[string]interface{}{
+			"name":               e.name,
+			"unique_id":          fmt.Sprintf("zeitpolizei_%s_%s", id, e.object),
+			"availability_topic": p.availabilityTopic(),
+			"device":             device,
+		}
+		for key, value := range e.config {
+			payload[key] = value
+		}
+
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := p.publish(p.discoveryTopic(id, e), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withdraw removes a device from Home Assistant and clears its state
+func (p *Publisher) withdraw(id string) error {
+	for _, e := range p.entities(id) {
+		if err := p.publish(p.discoveryTopic(id, e), ""); err != nil {
+			return err
+		}
+	}
+	return p.publish(p.topic(id, "state"), "")
+}
+
+func (p *Publisher) discoveryTopic(id string, e entity) string {
+	return fmt.Sprintf("%s/%s/zeitpolizei_%s/%s/config", p.config.DiscoveryPrefix, e.component, id, e.object)
+}
@@ -0,0 +1,339 @@
+// Package mqtt publishes the state of managed devices to an MQTT broker,
+// accepts block, unblock and bonus commands and announces the devices to
+// Home Assistant through MQTT discovery.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// ActorMQTT is recorded as the actor for commands received over MQTT
+const ActorMQTT = "mqtt"
+
+// Command payloads
+const (
+	payloadOn    = "ON"
+	payloadOff   = "OFF"
+	payloadPress = "PRESS"
+)
+
+// publishTimeout bounds how long a publish may wait for the broker
+const publishTimeout = 10 * time.Second
+
+// State is the retained JSON payload of a device's state topic
+type State struct {
+	Name    string `json:"name"`
+	Blocked bool   `json:"blocked"`
+	Reason  string `json:"reason"`
+	// InBlock is true while a time block is active
+	InBlock bool `json:"in_block"`
+	// Remaining time and data of the active time block, including bonus.
+	// Nil without an active block or limit.
+	RemainingMinutes *int   `json:"remaining_minutes"`
+	RemainingBytes   *int64 `json:"remaining_bytes"`
+	// Usage summed over all of today's time blocks
+	UsedMinutes int   `json:"used_minutes"`
+	UsedBytes   int64 `json:"used_bytes"`
+}
+
+// Publisher keeps the broker up to date with the managed devices
+type Publisher struct {
+	config   config.MQTTConfig
+	store    storage.Store
+	enforcer *enforcer.Enforcer
+	client   paho.Client
+	trigger  chan struct{}
+
+	mu sync.Mutex
+	// announced maps the device IDs with discovery payloads to their names
+	announced map[string]string
+}
+
+// New returns nil when MQTT is not configured
+func New(cfg config.MQTTConfig, store storage.Store, enf *enforcer.Enforcer) *Publisher {
+	if cfg.Broker == "" {
+		return nil
+	}
+
+	p := &Publisher{
+		config:    cfg,
+		store:     store,
+		enforcer:  enf,
+		trigger:   make(chan struct{}, 1),
+		announced: make(map[string]string),
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetWill(p.availabilityTopic(), "offline", 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		})
+	p.client = paho.NewClient(opts)
+
+	return p
+}
+
+// Refresh schedules a publish of all device states. It never blocks, so it
+// can be registered as an enforcer event handler and a tracker poll handler.
+func (p *Publisher) Refresh() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start connects to the broker and publishes state until ctx is cancelled.
+// The broker does not need to be reachable yet; the client keeps retrying.
+func (p *Publisher) Start(ctx context.Context) {
+	log.Printf("Connecting to MQTT broker %s", p.config.Broker)
+	p.client.Connect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if p.client.IsConnectionOpen() {
+				p.publish(p.availabilityTopic(), "offline")
+			}
+			p.client.Disconnect(250)
+			return
+		case <-p.trigger:
+			if p.client.IsConnectionOpen() {
+				if err := p.publishAll(); err != nil {
+					log.Printf("Error publishing MQTT state: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// onConnect announces availability and subscribes to the command topics. It
+// runs after every (re)connect, as the session is not persistent.
+func (p *Publisher) onConnect(client paho.Client) {
+	log.Printf("Connected to MQTT broker %s", p.config.Broker)
+
+	p.mu.Lock()
+	p.announced = make(map[string]string)
+	p.mu.Unlock()
+
+	p.publish(p.availabilityTopic(), "online")
+
+	subscriptions := map[string]paho.MessageHandler{
+		p.topic("+", "block", "set"): p.handleBlock,
+		p.topic("+", "bonus", "set"): p.handleBonus,
+	}
+	if p.config.Discovery {
+		// Home Assistant forgets discovered entities when it restarts
+		subscriptions[p.config.DiscoveryPrefix+"/status"] = func(_ paho.Client, msg paho.Message) {
+			if string(msg.Payload()) == "online" {
+				p.mu.Lock()
+				p.announced = make(map[string]string)
+				p.mu.Unlock()
+				p.Refresh()
+			}
+		}
+	}
+	for topic, handler := range subscriptions {
+		token := client.Subscribe(topic, 1, handler)
+		if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+			log.Printf("Error subscribing to MQTT topic %s: %v", topic, token.Error())
+		}
+	}
+
+	p.Refresh()
+}
+
+// publishAll publishes the state of every managed device, announces new or
+// renamed devices and withdraws removed ones
+func (p *Publisher) publishAll() error {
+	configs, err := p.store.GetAllDeviceConfigs()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, config := range configs {
+		id := deviceID(config.MAC)
+		current[id] = true
+
+		if p.config.Discovery {
+			p.mu.Lock()
+			name, announced := p.announced[id]
+			p.mu.Unlock()
+			if !announced || name != config.Name {
+				if err := p.announce(config); err != nil {
+					return err
+				}
+				p.mu.Lock()
+				p.announced[id] = config.Name
+				p.mu.Unlock()
+			}
+		}
+
+		state, err := p.deviceState(config, now)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := p.publish(p.topic(id, "state"), payload); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	var removed []string
+	for id := range p.announced {
+		if !current[id] {
+			removed = append(removed, id)
+		}
+	}
+	p.mu.Unlock()
+	for _, id := range removed {
+		if err := p.withdraw(id); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		delete(p.announced, id)
+		p.mu.Unlock()
+	}
+
+	return nil
+}
+
+// deviceState combines the device's blocking state with today's usage
+func (p *Publisher) deviceState(config *storage.DeviceConfig, now time.Time) (*State, error) {
+	deviceState, err := p.store.GetDeviceState(config.MAC)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := p.enforcer.UsageSummary(config, now)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{
+		Name:        config.Name,
+		Blocked:     deviceState.IsBlocked,
+		Reason:      deviceState.BlockedReason,
+		UsedMinutes: summary.TodayTotal.UsedMinutes,
+		UsedBytes:   summary.TodayTotal.UsedBytes,
+	}
+	if block := summary.CurrentBlock; block != nil {
+		state.InBlock = true
+		state.RemainingMinutes = block.RemainingMinutes
+		state.RemainingBytes = block.RemainingBytes
+	}
+	return state, nil
+}
+
+// handleBlock blocks or unblocks a device on ON/OFF
+func (p *Publisher) handleBlock(_ paho.Client, msg paho.Message) {
+	mac, ok := p.commandDevice(msg.Topic())
+	if !ok {
+		return
+	}
+
+	var err error
+	switch strings.ToUpper(string(msg.Payload())) {
+	case payloadOn:
+		err = p.enforcer.ManualBlock(mac, ActorMQTT)
+	case payloadOff:
+		err = p.enforcer.ManualUnblock(mac, ActorMQTT)
+	default:
+		log.Printf("Ignoring MQTT block command %q for %s", msg.Payload(), mac)
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling MQTT block command for %s: %v", mac, err)
+	}
+	p.Refresh()
+}
+
+// handleBonus grants the configured bonus minutes on PRESS
+func (p *Publisher) handleBonus(_ paho.Client, msg paho.Message) {
+	mac, ok := p.commandDevice(msg.Topic())
+	if !ok {
+		return
+	}
+	if strings.ToUpper(string(msg.Payload())) != payloadPress {
+		log.Printf("Ignoring MQTT bonus command %q for %s", msg.Payload(), mac)
+		return
+	}
+
+	err := p.enforcer.AddBonusTime(mac, p.config.BonusMinutes, ActorMQTT)
+	if errors.Is(err, enforcer.ErrNoActiveBlock) {
+		log.Printf("Ignoring MQTT bonus for %s outside of a time block", mac)
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling MQTT bonus command for %s: %v", mac, err)
+	}
+	p.Refresh()
+}
+
+// commandDevice finds the managed device a command topic addresses
+func (p *Publisher) commandDevice(topic string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(topic, p.config.TopicPrefix+"/"), "/")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	configs, err := p.store.GetAllDeviceConfigs()
+	if err != nil {
+		log.Printf("Error handling MQTT command on %s: %v", topic, err)
+		return "", false
+	}
+	for _, config := range configs {
+		if deviceID(config.MAC) == parts[0] {
+			return strings.ToLower(config.MAC), true
+		}
+	}
+
+	log.Printf("Ignoring MQTT command on %s for unmanaged device", topic)
+	return "", false
+}
+
+// publish sends a retained message and waits for the broker to accept it
+func (p *Publisher) publish(topic string, payload interface{}) error {
+	token := p.client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timeout publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// topic joins parts under the topic prefix
+func (p *Publisher) topic(parts ...string) string {
+	return strings.Join(append([]string{p.config.TopicPrefix}, parts...), "/")
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return p.topic("status")
+}
+
+// deviceID turns a MAC address into a topic and entity ID
+func deviceID(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+}
@@ -0,0 +1,212 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/network"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// testBroker is an embedded MQTT broker that records the last message of
+// every topic
+type testBroker struct {
+	*broker.Server
+	addr string
+
+	mu       sync.Mutex
+	messages map[string]string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	// Reserve a free port for the listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	b := &testBroker{
+		Server:   broker.New(&broker.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}),
+		addr:     addr,
+		messages: make(map[string]string),
+	}
+	if err := b.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr})); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	err = b.Subscribe("#", 1, func(_ *broker.Client, _ packets.Subscription, pk packets.Packet) {
+		b.mu.Lock()
+		b.messages[pk.TopicName] = string(pk.Payload)
+		b.mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// message returns the last payload published to topic
+func (b *testBroker) message(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.messages[topic]
+	return payload, ok
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	const mac, id = "AA:BB:CC:DD:EE:FF", "aabbccddeeff"
+
+	b := newTestBroker(t)
+
+	limit := 60
+	device := &storage.DeviceConfig{
+		MAC:     "aa:bb:cc:dd:ee:ff",
+		Name:    "Tablet",
+		Enabled: true,
+		DailySchedules: []storage.DaySchedule{{
+			Days:       []string{"weekdays", "weekends"},
+			TimeBlocks: []storage.TimeBlock{{StartTime: "00:00", EndTime: "24:00", LimitMinutes: &limit}},
+		}},
+	}
+	store := storage.NewMemory()
+	if err := store.SaveDeviceConfig(device); err != nil {
+		t.Fatal(err)
+	}
+	enf := enforcer.New(store, network.NewMemory(network.ClientInfo{MAC: mac}), time.UTC)
+
+	// The tracker creates the record of the active block on its first poll
+	block, index, date := enf.GetActiveTimeBlock(device, time.Now())
+	if _, err := store.GetOrCreateBlockUsage(device.MAC, date, index, block.StartTime, block.EndTime, block.LimitMinutes, block.LimitBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(config.MQTTConfig{
+		Broker:          "tcp://" + b.addr,
+		ClientID:        "zeitpolizei-test",
+		TopicPrefix:     "zeitpolizei",
+		Discovery:       true,
+		DiscoveryPrefix: "homeassistant",
+		BonusMinutes:    15,
+	}, store, enf)
+	enf.AddHandler(func(*storage.Event) { p.Refresh() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	state := func() State {
+		var s State
+		payload, _ := b.message("zeitpolizei/" + id + "/state")
+		json.Unmarshal([]byte(payload), &s)
+		return s
+	}
+	waitFor(t, "device state", func() bool { return state().Name == "Tablet" })
+
+	if s := state(); !s.InBlock || s.Blocked || s.RemainingMinutes == nil || *s.RemainingMinutes != 60 {
+		t.Errorf("state = %+v, want unblocked in a block with 60 minutes left", s)
+	}
+	if payload, _ := b.message("zeitpolizei/status"); payload != "online" {
+		t.Errorf("availability = %q, want online", payload)
+	}
+
+	// Discovery announces every entity with its topics and the device
+	for _, e := range p.entities(id) {
+		topic := p.discoveryTopic(id, e)
+		payload, ok := b.message(topic)
+		if !ok {
+			t.Errorf("no discovery payload on %s", topic)
+			continue
+		}
+		var discovery map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &discovery); err != nil {
+			t.Errorf("discovery payload on %s: %v", topic, err)
+			continue
+		}
+		device, _ := discovery["device"].(map[string]interface{})
+		if discovery["unique_id"] != "zeitpolizei_"+id+"_"+e.object || discovery["availability_topic"] != "zeitpolizei/status" ||
+			device["name"] != "Tablet" {
+			t.Errorf("discovery payload on %s = %s", topic, payload)
+		}
+	}
+	bonus, _ := b.message("homeassistant/button/zeitpolizei_" + id + "/bonus/config")
+	var button map[string]interface{}
+	json.Unmarshal([]byte(bonus), &button)
+	if button["command_topic"] != "zeitpolizei/"+id+"/bonus/set" || button["payload_press"] != "PRESS" || button["name"] != "+15 min" {
+		t.Errorf("bonus button = %s", bonus)
+	}
+
+	// Pressing the bonus button grants the configured minutes
+	if err := b.Publish("zeitpolizei/"+id+"/bonus/set", []byte("PRESS"), false, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "bonus in state", func() bool {
+		s := state()
+		return s.RemainingMinutes != nil && *s.RemainingMinutes == 75
+	})
+	events, err := store.ListEvents(storage.EventFilter{MAC: "aa:bb:cc:dd:ee:ff", Type: storage.EventBonusTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Actor != ActorMQTT {
+		t.Errorf("bonus events = %+v, want one by %s", events, ActorMQTT)
+	}
+
+	// The block switch blocks and unblocks the device
+	if err := b.Publish("zeitpolizei/"+id+"/block/set", []byte("ON"), false, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "blocked state", func() bool { return state().Blocked })
+	if s := state(); s.Reason != enforcer.ReasonManual {
+		t.Errorf("blocked reason = %q, want %q", s.Reason, enforcer.ReasonManual)
+	}
+	if err := b.Publish("zeitpolizei/"+id+"/block/set", []byte("OFF"), false, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "unblocked state", func() bool { return !state().Blocked })
+
+	cancel()
+	<-done
+	if payload, _ := b.message("zeitpolizei/status"); payload != "offline" {
+		t.Errorf("availability after stop = %q, want offline", payload)
+	}
+}
@@ -0,0 +1,33 @@
+package network
+
+// Backend is a network controller that Zeitpolizei can read client traffic
+// counters from and block or unblock clients on
+type Backend interface {
+	// Login authenticates with the controller
+	Login() error
+	// GetClients retrieves all connected clients with their traffic counters
+	GetClients() ([]ClientInfo, error)
+	// BlockClient blocks a client by MAC address
+	BlockClient(mac string) error
+	// UnblockClient unblocks a client by MAC address
+	UnblockClient(mac string) error
+	// GetBlockedClients retrieves all blocked clients
+	GetBlockedClients() ([]ClientInfo, error)
+	// GetAllKnownClients retrieves all known clients (connected and historical)
+	GetAllKnownClients() ([]ClientInfo, error)
+}
+
+// ClientInfo represents a network client reported by a backend
+type ClientInfo struct {
+	MAC       string `json:"mac"`
+	Name      string `json:"name,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	TxBytes   int64  `json:"tx_bytes"`
+	RxBytes   int64  `json:"rx_bytes"`
+	Blocked   bool   `json:"blocked"`
+	IsWired   bool   `json:"is_wired"`
+	LastSeen  int64  `json:"last_seen"`
+	Uptime    int64  `json:"uptime"`
+	AssocTime int64  `json:"assoc_time"`
+}
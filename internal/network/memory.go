@@ -0,0 +1,122 @@
+package network
+
+import (
+	"strings"
+	"sync"
+)
+
+// Memory is an in-memory Backend for dry runs and tests. It never touches a
+// real network; clients and their counters are set by the caller.
+type Memory struct {
+	mu      sync.RWMutex
+	clients map[string]*ClientInfo
+	online  map[string]bool
+}
+
+// NewMemory creates a new in-memory backend with the given known clients
+func NewMemory(clients ...ClientInfo) *Memory {
+	m := &Memory{
+		clients: make(map[string]*ClientInfo),
+		online:  make(map[string]bool),
+	}
+	for _, client := range clients {
+		m.SetClient(client, true)
+	}
+	return m
+}
+
+// SetClient adds or replaces a client and marks it online or offline
+func (m *Memory) SetClient(client ClientInfo, online bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mac := strings.ToLower(client.MAC)
+	client.MAC = mac
+	if existing, ok := m.clients[mac]; ok {
+		client.Blocked = existing.Blocked
+	}
+	m.clients[mac] = &client
+	m.online[mac] = online
+}
+
+// AddTraffic increases the traffic counters of a client
+func (m *Memory) AddTraffic(mac string, txBytes, rxBytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if client, ok := m.clients[strings.ToLower(mac)]; ok {
+		client.TxBytes += txBytes
+		client.RxBytes += rxBytes
+	}
+}
+
+// Login is a no-op for the in-memory backend
+func (m *Memory) Login() error {
+	return nil
+}
+
+// GetClients retrieves all online clients
+func (m *Memory) GetClients() ([]ClientInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var clients []ClientInfo
+	for mac, client := range m.clients {
+		if m.online[mac] {
+			clients = append(clients, *client)
+		}
+	}
+	return clients, nil
+}
+
+// BlockClient blocks a client by MAC address
+func (m *Memory) BlockClient(mac string) error {
+	m.setBlocked(mac, true)
+	return nil
+}
+
+// UnblockClient unblocks a client by MAC address
+func (m *Memory) UnblockClient(mac string) error {
+	m.setBlocked(mac, false)
+	return nil
+}
+
+// setBlocked updates the blocked flag, adding the client if it is unknown
+func (m *Memory) setBlocked(mac string, blocked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mac = strings.ToLower(mac)
+	client, ok := m.clients[mac]
+	if !ok {
+		client = &ClientInfo{MAC: mac}
+		m.clients[mac] = client
+	}
+	client.Blocked = blocked
+}
+
+// GetBlockedClients retrieves all blocked clients
+func (m *Memory) GetBlockedClients() ([]ClientInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var blocked []ClientInfo
+	for _, client := range m.clients {
+		if client.Blocked {
+			blocked = append(blocked, *client)
+		}
+	}
+	return blocked, nil
+}
+
+// GetAllKnownClients retrieves all known clients, online or not
+func (m *Memory) GetAllKnownClients() ([]ClientInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var clients []ClientInfo
+	for _, client := range m.clients {
+		clients = append(clients, *client)
+	}
+	return clients, nil
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Gotify sends notifications as messages of a Gotify application
+type Gotify struct {
+	url      string
+	token    string
+	priority int
+}
+
+func newGotify(cfg config.ChannelConfig) (*Gotify, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("gotify channel needs a url and an application token")
+	}
+	return &Gotify{
+		url:      strings.TrimSuffix(cfg.URL, "/") + "/message",
+		token:    cfg.Token,
+		priority: cfg.Priority,
+	}, nil
+}
+
+// Send creates a message. Blocks are sent with high priority unless the
+// channel sets one.
+func (c *Gotify) Send(ctx context.Context, n *Notification) error {
+	priority := c.priority
+	if priority == 0 {
+		priority = 5
+		if n.Event.Type == storage.EventBlock {
+			priority = 8
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    n.Title,
+		"message":  n.Message,
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", c.token)
+	return doHTTP(req)
+}
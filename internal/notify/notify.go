@@ -0,0 +1,296 @@
+// Package notify delivers enforcement events to people outside the dashboard
+// through webhook, SMTP, ntfy and Gotify channels.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Retry defaults for channels that do not configure a policy
+const (
+	defaultAttempts = 3
+	defaultBackoff  = 5 * time.Second
+)
+
+// queueSize bounds the events waiting for a channel; further events are dropped
+const queueSize = 100
+
+// defaultEvents are delivered by channels that do not list their events
+var defaultEvents = []string{
+	storage.EventBlock,
+	storage.EventUnblock,
+	storage.EventWarning,
+	storage.EventBonusTime,
+	storage.EventBonusData,
+}
+
+// eventTypes are the event types a channel may subscribe to
+var eventTypes = make(map[string]bool)
+
+func init() {
+	for _, eventType := range storage.EventTypes {
+		eventTypes[eventType] = true
+	}
+}
+
+// Notification is a rendered event ready to be sent
+type Notification struct {
+	Title   string
+	Message string
+	// Device is the configured name of the device, or its MAC address
+	Device string
+	Event  *storage.Event
+}
+
+// Channel sends notifications to one destination
+type Channel interface {
+	Send(ctx context.Context, n *Notification) error
+}
+
+// permanentError marks a failure that retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Dispatcher routes events to the channels that subscribe to them. Each
+// channel has its own queue, so a slow or failing channel does not hold up
+// the others.
+type Dispatcher struct {
+	store  storage.Store
+	routes []*route
+}
+
+// route is a channel with its routing rules, templates and retry policy
+type route struct {
+	name      string
+	channel   Channel
+	events    map[string]bool
+	devices   map[string]bool
+	templates *templates
+	attempts  int
+	backoff   time.Duration
+	queue     chan *storage.Event
+}
+
+// New creates a dispatcher for the configured channels
+func New(cfg config.NotifyConfig, store storage.Store) (*Dispatcher, error) {
+	d := &Dispatcher{store: store}
+	names := make(map[string]bool)
+
+	for i, channelConfig := range cfg.Channels {
+		if channelConfig.Name == "" {
+			channelConfig.Name = fmt.Sprintf("%s-%d", channelConfig.Type, i+1)
+		}
+		if names[channelConfig.Name] {
+			return nil, fmt.Errorf("notify channel %q is defined twice", channelConfig.Name)
+		}
+		names[channelConfig.Name] = true
+
+		r, err := newRoute(channelConfig)
+		if err != nil {
+			return nil, fmt.Errorf("notify channel %q: %w", channelConfig.Name, err)
+		}
+		d.routes = append(d.routes, r)
+	}
+
+	return d, nil
+}
+
+// newRoute validates a channel configuration
+func newRoute(cfg config.ChannelConfig) (*route, error) {
+	channel, err := newChannel(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	events := cfg.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	r := &route{
+		name:     cfg.Name,
+		channel:  channel,
+		events:   make(map[string]bool),
+		attempts: cfg.Retry.Attempts,
+		backoff:  cfg.Retry.Backoff,
+		queue:    make(chan *storage.Event, queueSize),
+	}
+	for _, event := range events {
+		if !eventTypes[event] {
+			return nil, fmt.Errorf("unknown event type %q", event)
+		}
+		r.events[event] = true
+	}
+	if len(cfg.Devices) > 0 {
+		r.devices = make(map[string]bool)
+		for _, mac := range cfg.Devices {
+			r.devices[strings.ToLower(mac)] = true
+		}
+	}
+	if r.attempts <= 0 {
+		r.attempts = defaultAttempts
+	}
+	if r.backoff <= 0 {
+		r.backoff = defaultBackoff
+	}
+
+	r.templates, err = newTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newChannel creates the channel for a configuration's type
+func newChannel(cfg config.ChannelConfig) (Channel, error) {
+	switch cfg.Type {
+	case "webhook":
+		return newWebhook(cfg)
+	case "smtp":
+		return newSMTP(cfg)
+	case "ntfy":
+		return newNtfy(cfg)
+	case "gotify":
+		return newGotify(cfg)
+	default:
+		return nil, fmt.Errorf("unknown channel type %q", cfg.Type)
+	}
+}
+
+// Channels returns the names of the configured channels
+func (d *Dispatcher) Channels() []string {
+	names := make([]string, len(d.routes))
+	for i, r := range d.routes {
+		names[i] = r.name
+	}
+	return names
+}
+
+// Handle queues an event for every channel that subscribes to it. It never
+// blocks, so it can be registered with Enforcer.AddHandler.
+func (d *Dispatcher) Handle(event *storage.Event) {
+	for _, r := range d.routes {
+		if !r.matches(event) {
+			continue
+		}
+		copied := *event
+		select {
+		case r.queue <- &copied:
+		default:
+			log.Printf("Notify channel %s is backed up, dropping %s event for %s", r.name, event.Type, event.MAC)
+		}
+	}
+}
+
+// matches reports whether the route subscribes to the event
+func (r *route) matches(event *storage.Event) bool {
+	if !r.events[event.Type] {
+		return false
+	}
+	return r.devices == nil || r.devices[strings.ToLower(event.MAC)]
+}
+
+// Start delivers queued events until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range d.routes {
+		wg.Add(1)
+		go func(r *route) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-r.queue:
+					if err := d.deliver(ctx, r, event); err != nil {
+						log.Printf("Notify channel %s failed to deliver %s event for %s: %v", r.name, event.Type, event.MAC, err)
+					}
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Test sends a sample warning to the named channel, or to all channels when
+// name is empty, without retrying
+func (d *Dispatcher) Test(ctx context.Context, name string) error {
+	event := &storage.Event{
+		Time:        time.Now(),
+		Type:        storage.EventWarning,
+		MAC:         "00:00:00:00:00:00",
+		Actor:       "system",
+		Reason:      "time_threshold",
+		Details:     "80% of time limit used",
+		UsedMinutes: 48,
+	}
+	limit := 60
+	event.LimitMinutes = &limit
+
+	found := false
+	var errs []error
+	for _, r := range d.routes {
+		if name != "" && r.name != name {
+			continue
+		}
+		found = true
+		n, err := r.templates.render(event, "Test device")
+		if err == nil {
+			err = r.channel.Send(ctx, n)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+		}
+	}
+	if !found {
+		return fmt.Errorf("no notify channel %q", name)
+	}
+	return errors.Join(errs...)
+}
+
+// deliver renders an event and sends it, retrying temporary failures with
+// exponential backoff
+func (d *Dispatcher) deliver(ctx context.Context, r *route, event *storage.Event) error {
+	n, err := r.templates.render(event, d.deviceName(event.MAC))
+	if err != nil {
+		return err
+	}
+
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		err = r.channel.Send(ctx, n)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || attempt >= r.attempts {
+			return err
+		}
+
+		log.Printf("Notify channel %s attempt %d/%d failed, retrying in %v: %v", r.name, attempt, r.attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// deviceName returns the configured name of a device, or its MAC address
+func (d *Dispatcher) deviceName(mac string) string {
+	config, err := d.store.GetDeviceConfig(mac)
+	if err != nil || config == nil || config.Name == "" {
+		return mac
+	}
+	return config.Name
+}
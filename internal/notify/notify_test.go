@@ -0,0 +1,431 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+const testMAC = "aa:bb:cc:dd:ee:ff"
+
+// testRetry keeps retries of failing test servers fast
+var testRetry = config.RetryConfig{Attempts: 3, Backoff: time.Millisecond}
+
+func blockEvent() *storage.Event {
+	limit := 60
+	return &storage.Event{
+		Time:         time.Now(),
+		Type:         storage.EventBlock,
+		MAC:          testMAC,
+		Actor:        "system",
+		Reason:       "time_limit",
+		UsedMinutes:  60,
+		LimitMinutes: &limit,
+	}
+}
+
+// newTestDispatcher creates a dispatcher for channels whose events come from
+// a device named Tablet
+func newTestDispatcher(t *testing.T, channels ...config.ChannelConfig) *Dispatcher {
+	t.Helper()
+	store := storage.NewMemory()
+	if err := store.SaveDeviceConfig(&storage.DeviceConfig{MAC: testMAC, Name: "Tablet", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(config.NotifyConfig{Channels: channels}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// request is an HTTP request received by a test server
+type request struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// testServer records requests and answers them with the next status code,
+// or 200 when none are left
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []request
+	statuses []int
+}
+
+func newTestServer(t *testing.T, statuses ...int) *testServer {
+	s := &testServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, request{path: r.URL.Path, header: r.Header, body: string(body)})
+		if len(s.statuses) > 0 {
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) received() []request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]request(nil), s.requests...)
+}
+
+func TestDispatcherRouting(t *testing.T) {
+	all := newTestServer(t)
+	other := newTestServer(t)
+	d := newTestDispatcher(t,
+		config.ChannelConfig{Name: "all", Type: "webhook", URL: all.URL, Headers: map[string]string{"X-Token": "secret"}},
+		config.ChannelConfig{Name: "other", Type: "webhook", URL: other.URL, Events: []string{storage.EventUnblock}, Devices: []string{"11:22:33:44:55:66"}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	d.Handle(blockEvent())
+	d.Handle(&storage.Event{Type: storage.EventUnblock, MAC: testMAC, Actor: "system"})
+	d.Handle(&storage.Event{Type: storage.EventConfigSaved, MAC: testMAC, Actor: "mum"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(all.received()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook received %d requests, want 2", len(all.received()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req := all.received()[0]
+	if req.header.Get("X-Token") != "secret" || req.header.Get("Content-Type") != "application/json" {
+		t.Errorf("webhook headers = %v", req.header)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal([]byte(req.body), &payload); err != nil {
+		t.Fatalf("webhook body %s: %v", req.body, err)
+	}
+	if payload.Title != "Tablet blocked" || payload.Message != "Tablet was blocked: time limit reached." || payload.Device != "Tablet" {
+		t.Errorf("webhook payload = %+v", payload)
+	}
+	if payload.Event == nil || payload.Event.Type != storage.EventBlock || payload.Event.MAC != testMAC {
+		t.Errorf("webhook event = %+v", payload.Event)
+	}
+
+	// Neither the config event nor the other device's channel are delivered
+	time.Sleep(50 * time.Millisecond)
+	if n := len(all.received()); n != 2 {
+		t.Errorf("webhook received %d requests, want 2", n)
+	}
+	if n := len(other.received()); n != 0 {
+		t.Errorf("filtered webhook received %d requests, want 0", n)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		wantErr  bool
+	}{
+		{name: "temporary failures", statuses: []int{503, 429}, attempts: 3},
+		{name: "attempts exhausted", statuses: []int{500, 502, 503}, attempts: 3, wantErr: true},
+		{name: "client error", statuses: []int{400}, attempts: 1, wantErr: true},
+		{name: "timeout", statuses: []int{408}, attempts: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.statuses...)
+			d := newTestDispatcher(t, config.ChannelConfig{Type: "webhook", URL: s.URL, Retry: testRetry})
+
+			err := d.deliver(context.Background(), d.routes[0], blockEvent())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deliver() error = %v, want error %v", err, tt.wantErr)
+			}
+			if n := len(s.received()); n != tt.attempts {
+				t.Errorf("deliver() made %d attempts, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestDeliverCancelled(t *testing.T) {
+	s := newTestServer(t, 500, 500, 500)
+	d := newTestDispatcher(t, config.ChannelConfig{Type: "webhook", URL: s.URL, Retry: config.RetryConfig{Attempts: 3, Backoff: time.Hour}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.deliver(ctx, d.routes[0], blockEvent()); err != context.DeadlineExceeded {
+		t.Errorf("deliver() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := len(s.received()); n != 1 {
+		t.Errorf("deliver() made %d attempts, want 1", n)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	s := newTestServer(t)
+	d := newTestDispatcher(t, config.ChannelConfig{Type: "ntfy", URL: s.URL + "/", Topic: "family", Token: "tk_test"})
+
+	if err := d.deliver(context.Background(), d.routes[0], blockEvent()); err != nil {
+		t.Fatal(err)
+	}
+	received := s.received()
+	if len(received) != 1 {
+		t.Fatalf("ntfy received %d requests, want 1", len(received))
+	}
+	req := received[0]
+	if req.path != "/family" {
+		t.Errorf("ntfy path = %s, want /family", req.path)
+	}
+	if req.body != "Tablet was blocked: time limit reached." {
+		t.Errorf("ntfy body = %q", req.body)
+	}
+	for key, want := range map[string]string{
+		"Title":         "Tablet blocked",
+		"Tags":          "no_entry",
+		"Priority":      "4",
+		"Authorization": "Bearer tk_test",
+	} {
+		if got := req.header.Get(key); got != want {
+			t.Errorf("ntfy %s header = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestGotify(t *testing.T) {
+	s := newTestServer(t)
+	d := newTestDispatcher(t, config.ChannelConfig{Type: "gotify", URL: s.URL, Token: "app-token"})
+
+	if err := d.deliver(context.Background(), d.routes[0], blockEvent()); err != nil {
+		t.Fatal(err)
+	}
+	received := s.received()
+	if len(received) != 1 {
+		t.Fatalf("gotify received %d requests, want 1", len(received))
+	}
+	req := received[0]
+	if req.path != "/message" || req.header.Get("X-Gotify-Key") != "app-token" {
+		t.Errorf("gotify request to %s with key %q", req.path, req.header.Get("X-Gotify-Key"))
+	}
+	var message struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal([]byte(req.body), &message); err != nil {
+		t.Fatalf("gotify body %s: %v", req.body, err)
+	}
+	if message.Title != "Tablet blocked" || message.Message != "Tablet was blocked: time limit reached." || message.Priority != 8 {
+		t.Errorf("gotify message = %+v", message)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	s := newTestServer(t)
+	d := newTestDispatcher(t, config.ChannelConfig{
+		Type: "webhook",
+		URL:  s.URL,
+		Templates: map[string]config.TemplateConfig{
+			storage.EventBlock: {Message: "{{.Device}} used {{.UsedMinutes}}/{{.LimitMinutes}} min ({{.MAC}})"},
+		},
+	})
+
+	if err := d.deliver(context.Background(), d.routes[0], blockEvent()); err != nil {
+		t.Fatal(err)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal([]byte(s.received()[0].body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	// The default title is kept when only the message is overridden
+	if payload.Title != "Tablet blocked" || payload.Message != "Tablet used 60/60 min (aa:bb:cc:dd:ee:ff)" {
+		t.Errorf("rendered %q / %q", payload.Title, payload.Message)
+	}
+
+	// Events of unconfigured devices are named by their MAC address
+	event := blockEvent()
+	event.MAC = "11:22:33:44:55:66"
+	n, err := d.routes[0].templates.render(event, d.deviceName(event.MAC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Title != "11:22:33:44:55:66 blocked" {
+		t.Errorf("title = %q", n.Title)
+	}
+
+	if _, err := newTemplates(map[string]config.TemplateConfig{"reboot": {Title: "x"}}); err == nil {
+		t.Error("template for an unknown event type was accepted")
+	}
+	if _, err := newTemplates(map[string]config.TemplateConfig{storage.EventBlock: {Title: "{{.Device"}}); err == nil {
+		t.Error("invalid template was accepted")
+	}
+}
+
+// smtpServer is a minimal SMTP server without STARTTLS or authentication.
+// The first sessions, up to failures, get a temporary error after
+// MAIL FROM.
+type smtpServer struct {
+	addr     string
+	failures int
+
+	mu       sync.Mutex
+	sessions int
+	from     string
+	to       []string
+	data     string
+}
+
+func newSMTPServer(t *testing.T, failures int) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &smtpServer{addr: l.Addr().String(), failures: failures}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	s.mu.Lock()
+	s.sessions++
+	fail := s.sessions <= s.failures
+	s.mu.Unlock()
+
+	reply("220 localhost ESMTP test")
+	var from string
+	var to []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			if fail {
+				reply("451 try again later")
+				continue
+			}
+			from = strings.Fields(line[len("MAIL FROM:"):])[0] // Without BODY=8BITMIME
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			to = append(to, line[len("RCPT TO:"):])
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.from, s.to, s.data = from, to, data.String()
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	s := newSMTPServer(t, 1)
+	host, port, _ := net.SplitHostPort(s.addr)
+	portNumber, _ := strconv.Atoi(port)
+	d := newTestDispatcher(t, config.ChannelConfig{
+		Type:  "smtp",
+		Retry: testRetry,
+		SMTP: config.SMTPConfig{
+			Host: host,
+			Port: portNumber,
+			From: "zeitpolizei@example.com",
+			To:   []string{"mum@example.com", "dad@example.com"},
+		},
+		Templates: map[string]config.TemplateConfig{
+			storage.EventBlock: {Title: "Schluss für {{.Device}}", Message: "{{.Device}} was blocked.\nGood night!"},
+		},
+	})
+
+	if err := d.deliver(context.Background(), d.routes[0], blockEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions != 2 {
+		t.Errorf("smtp sessions = %d, want 2", s.sessions)
+	}
+	if s.from != "<zeitpolizei@example.com>" || strings.Join(s.to, ",") != "<mum@example.com>,<dad@example.com>" {
+		t.Errorf("envelope from %s to %v", s.from, s.to)
+	}
+
+	header, body, ok := strings.Cut(s.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("mail without a body: %q", s.data)
+	}
+	for _, want := range []string{
+		"From: zeitpolizei@example.com",
+		"To: mum@example.com, dad@example.com",
+		"Subject: =?utf-8?q?Schluss_f=C3=BCr_Tablet?=",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header, want+"\r\n") {
+			t.Errorf("mail header lacks %q:\n%s", want, header)
+		}
+	}
+	if body != "Tablet was blocked.\r\nGood night!\r\n" {
+		t.Errorf("mail body = %q", body)
+	}
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Ntfy publishes notifications to a topic of an ntfy server
+type Ntfy struct {
+	url      string
+	token    string
+	priority int
+}
+
+func newNtfy(cfg config.ChannelConfig) (*Ntfy, error) {
+	if cfg.URL == "" || cfg.Topic == "" {
+		return nil, fmt.Errorf("ntfy channel needs a url and a topic")
+	}
+	return &Ntfy{
+		url:      strings.TrimSuffix(cfg.URL, "/") + "/" + cfg.Topic,
+		token:    cfg.Token,
+		priority: cfg.Priority,
+	}, nil
+}
+
+// Send publishes the notification. Blocks are sent with high priority unless
+// the channel sets one.
+func (c *Ntfy) Send(ctx context.Context, n *Notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(n.Message))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Title", n.Title)
+	req.Header.Set("Tags", ntfyTag(n.Event.Type))
+
+	priority := c.priority
+	if priority == 0 && n.Event.Type == storage.EventBlock {
+		priority = 4
+	}
+	if priority != 0 {
+		req.Header.Set("Priority", strconv.Itoa(priority))
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return doHTTP(req)
+}
+
+// ntfyTag picks the emoji tag shown next to a notification
+func ntfyTag(eventType string) string {
+	switch eventType {
+	case storage.EventBlock:
+		return "no_entry"
+	case storage.EventUnblock:
+		return "white_check_mark"
+	case storage.EventWarning:
+		return "hourglass_flowing_sand"
+	case storage.EventBonusTime, storage.EventBonusData:
+		return "gift"
+	default:
+		return "information_source"
+	}
+}
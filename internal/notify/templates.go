@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Data is what title and message templates are executed with
+type Data struct {
+	Device  string
+	MAC     string
+	Type    string
+	Reason  string
+	Actor   string
+	Details string
+	Time    time.Time
+	// Usage of the active time block when the event happened. Limits include
+	// bonus and are 0 when the block has no such limit.
+	UsedMinutes      int
+	UsedBytes        int64
+	LimitMinutes     int
+	LimitBytes       int64
+	RemainingMinutes int
+}
+
+// defaultTemplates are used for event types a channel does not override
+var defaultTemplates = map[string]config.TemplateConfig{
+	storage.EventBlock: {
+		Title:   "{{.Device}} blocked",
+		Message: "{{.Device}} was blocked: {{reason .Reason}}.",
+	},
+	storage.EventUnblock: {
+		Title:   "{{.Device}} unblocked",
+		Message: "{{.Device}} has internet access again.",
+	},
+	storage.EventWarning: {
+		Title: "{{.Device}}: {{.Details}}",
+		Message: "{{.Device}}: {{.Details}}" +
+			"{{if .LimitMinutes}}, {{.UsedMinutes}} of {{.LimitMinutes}} minutes used{{end}}" +
+			"{{if .LimitBytes}}, {{bytes .UsedBytes}} of {{bytes .LimitBytes}} used{{end}}.",
+	},
+	storage.EventOverride: {
+		Title:   "{{.Device}} {{.Details}}",
+		Message: "{{.Device}} {{.Details}}{{if ne .Actor \"system\"}} by {{.Actor}}{{end}}.",
+	},
+	storage.EventBonusTime: {
+		Title:   "Bonus time for {{.Device}}",
+		Message: "{{.Actor}} added {{.Details}} to {{.Device}}{{if .LimitMinutes}}, {{.RemainingMinutes}} minutes left{{end}}.",
+	},
+	storage.EventBonusData: {
+		Title:   "Bonus data for {{.Device}}",
+		Message: "{{.Actor}} added bonus data to {{.Device}}{{if .LimitBytes}}, the limit is now {{bytes .LimitBytes}}{{end}}.",
+	},
+}
+
+// fallbackTemplate is used for event types without a default template
+var fallbackTemplate = config.TemplateConfig{
+	Title:   "{{.Device}}: {{.Type}}",
+	Message: "{{.Type}} by {{.Actor}}{{if .Details}}: {{.Details}}{{end}}",
+}
+
+// reasons describes block reasons in plain words
+var reasons = map[string]string{
+	"time_limit":    "time limit reached",
+	"data_limit":    "data limit reached",
+	"outside_hours": "outside allowed hours",
+	"manual":        "blocked by a parent",
+	"override":      "blocked by a parent for a while",
+	"exception":     "blocked for the day",
+}
+
+var templateFuncs = template.FuncMap{
+	"bytes": storage.FormatBytes,
+	"reason": func(reason string) string {
+		if text, ok := reasons[reason]; ok {
+			return text
+		}
+		return reason
+	},
+}
+
+// templates holds the parsed title and message templates of a channel
+type templates struct {
+	title   map[string]*template.Template
+	message map[string]*template.Template
+}
+
+// newTemplates parses the default templates with the channel's overrides
+func newTemplates(overrides map[string]config.TemplateConfig) (*templates, error) {
+	t := &templates{
+		title:   make(map[string]*template.Template),
+		message: make(map[string]*template.Template),
+	}
+
+	for eventType := range eventTypes {
+		tc, ok := defaultTemplates[eventType]
+		if !ok {
+			tc = fallbackTemplate
+		}
+		if override, ok := overrides[eventType]; ok {
+			if override.Title != "" {
+				tc.Title = override.Title
+			}
+			if override.Message != "" {
+				tc.Message = override.Message
+			}
+		}
+
+		var err error
+		if t.title[eventType], err = parseTemplate(eventType+" title", tc.Title); err != nil {
+			return nil, err
+		}
+		if t.message[eventType], err = parseTemplate(eventType+" message", tc.Message); err != nil {
+			return nil, err
+		}
+	}
+
+	for eventType := range overrides {
+		if !eventTypes[eventType] {
+			return nil, fmt.Errorf("template for unknown event type %q", eventType)
+		}
+	}
+	return t, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// render executes the templates of the event's type
+func (t *templates) render(event *storage.Event, device string) (*Notification, error) {
+	data := Data{
+		Device:      device,
+		MAC:         event.MAC,
+		Type:        event.Type,
+		Reason:      event.Reason,
+		Actor:       event.Actor,
+		Details:     event.Details,
+		Time:        event.Time,
+		UsedMinutes: event.UsedMinutes,
+		UsedBytes:   event.UsedBytes,
+	}
+	if event.LimitMinutes != nil {
+		data.LimitMinutes = *event.LimitMinutes
+		data.RemainingMinutes = max(data.LimitMinutes-data.UsedMinutes, 0)
+	}
+	if event.LimitBytes != nil {
+		data.LimitBytes = *event.LimitBytes
+	}
+
+	title, err := execute(t.title[event.Type], data)
+	if err != nil {
+		return nil, err
+	}
+	message, err := execute(t.message[event.Type], data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notification{Title: title, Message: message, Device: device, Event: event}, nil
+}
+
+func execute(tmpl *template.Template, data Data) (string, error) {
+	if tmpl == nil {
+		return "", fmt.Errorf("no template for %s events", data.Type)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
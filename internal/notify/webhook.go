@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/config"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// httpClient is shared by the HTTP based channels
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhook posts notifications as JSON to a URL
+type Webhook struct {
+	url     string
+	headers map[string]string
+}
+
+// WebhookPayload is the JSON body of webhook notifications
+type WebhookPayload struct {
+	Title   string         `json:"title"`
+	Message string         `json:"message"`
+	Device  string         `json:"device"`
+	Event   *storage.Event `json:"event"`
+}
+
+func newWebhook(cfg config.ChannelConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook channel needs a url")
+	}
+	return &Webhook{url: cfg.URL, headers: cfg.Headers}, nil
+}
+
+// Send posts the notification
+func (w *Webhook) Send(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(WebhookPayload{
+		Title:   n.Title,
+		Message: n.Message,
+		Device:  n.Device,
+		Event:   n.Event,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+	return doHTTP(req)
+}
+
+// doHTTP sends a request and turns unsuccessful responses into errors.
+// Client errors other than timeouts and rate limits are not retried.
+func doHTTP(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// ExportFilter selects the usage records of an export
+type ExportFilter struct {
+	MAC  string // Empty exports all devices
+	From string // First date, YYYY-MM-DD
+	To   string // Last date, YYYY-MM-DD
+}
+
+// ExportRow is the usage of a device in one time block on one day
+type ExportRow struct {
+	Date          string `json:"date"`
+	MAC           string `json:"mac"`
+	Name          string `json:"name"`
+	BlockIndex    int    `json:"block_index"`
+	StartTime     string `json:"start_time"`
+	EndTime       string `json:"end_time"`
+	UsedMinutes   int    `json:"used_minutes"`
+	UsedSeconds   int    `json:"used_seconds"`
+	UsedBytes     int64  `json:"used_bytes"`
+	LimitMinutes  *int   `json:"limit_minutes"`
+	LimitBytes    *int64 `json:"limit_bytes"`
+	BonusMinutes  int    `json:"bonus_minutes"`
+	BonusBytes    int64  `json:"bonus_bytes"`
+	IsBlocked     bool   `json:"is_blocked"`
+	BlockedReason string `json:"blocked_reason"`
+}
+
+// exportHeader names the CSV columns, in the order of ExportRow
+var exportHeader = []string{
+	"date", "mac", "name", "block_index", "start_time", "end_time",
+	"used_minutes", "used_seconds", "used_bytes", "limit_minutes", "limit_bytes",
+	"bonus_minutes", "bonus_bytes", "is_blocked", "blocked_reason",
+}
+
+// ValidateExport checks the format and dates of an export
+func ValidateExport(format string, filter ExportFilter) error {
+	if format != FormatCSV && format != FormatJSON {
+		return fmt.Errorf("format must be %s or %s", FormatCSV, FormatJSON)
+	}
+	from, err := time.Parse("2006-01-02", filter.From)
+	if err != nil {
+		return fmt.Errorf("from must be YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", filter.To)
+	if err != nil {
+		return fmt.Errorf("to must be YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return fmt.Errorf("to is before from")
+	}
+	return nil
+}
+
+// Export writes the block usage records matching filter to w in format,
+// oldest first. Records are read and written one day at a time, so exports
+// of any length use little memory.
+func Export(w io.Writer, store storage.Store, format string, filter ExportFilter) error {
+	if err := ValidateExport(format, filter); err != nil {
+		return err
+	}
+
+	configs, err := store.GetAllDeviceConfigs()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	for _, config := range configs {
+		names[config.MAC] = config.Name
+	}
+
+	writer := newRowWriter(w, format)
+	if err := writer.begin(); err != nil {
+		return err
+	}
+
+	day, _ := time.Parse("2006-01-02", filter.From)
+	last, _ := time.Parse("2006-01-02", filter.To)
+	for ; !day.After(last); day = day.AddDate(0, 0, 1) {
+		usages, err := usageForDate(store, filter.MAC, day.Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+		for _, usage := range usages {
+			if err := writer.write(exportRow(usage, names[usage.MAC])); err != nil {
+				return err
+			}
+		}
+		if err := writer.flush(); err != nil {
+			return err
+		}
+	}
+
+	return writer.end()
+}
+
+// usageForDate returns the usage records of a device, or of all devices
+// ordered by MAC if mac is empty, on a date
+func usageForDate(store storage.Store, mac, date string) ([]*storage.BlockUsage, error) {
+	if mac != "" {
+		return store.GetBlockUsageForDate(mac, date)
+	}
+
+	all, err := store.GetAllUsageForDate(date)
+	if err != nil {
+		return nil, err
+	}
+	macs := make([]string, 0, len(all))
+	for mac := range all {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+
+	var usages []*storage.BlockUsage
+	for _, mac := range macs {
+		usages = append(usages, all[mac]...)
+	}
+	return usages, nil
+}
+
+// exportRow converts a usage record
+func exportRow(usage *storage.BlockUsage, name string) *ExportRow {
+	return &ExportRow{
+		Date:          usage.Date,
+		MAC:           usage.MAC,
+		Name:          name,
+		BlockIndex:    usage.BlockIndex,
+		StartTime:     usage.StartTime,
+		EndTime:       usage.EndTime,
+		UsedMinutes:   usage.UsedMinutes(),
+		UsedSeconds:   usage.UsedSeconds,
+		UsedBytes:     usage.UsedBytes,
+		LimitMinutes:  usage.LimitMinutes,
+		LimitBytes:    usage.LimitBytes,
+		BonusMinutes:  usage.BonusMinutes,
+		BonusBytes:    usage.BonusBytes,
+		IsBlocked:     usage.IsBlocked,
+		BlockedReason: usage.BlockedReason,
+	}
+}
+
+// rowWriter writes export rows in one format
+type rowWriter struct {
+	w     io.Writer
+	csv   *csv.Writer // Nil for JSON
+	first bool
+}
+
+// newRowWriter creates a writer of rows in format to w
+func newRowWriter(w io.Writer, format string) *rowWriter {
+	writer := &rowWriter{w: w, first: true}
+	if format == FormatCSV {
+		writer.csv = csv.NewWriter(w)
+	}
+	return writer
+}
+
+// begin writes the CSV header or opens the JSON array
+func (r *rowWriter) begin() error {
+	if r.csv != nil {
+		return r.csv.Write(exportHeader)
+	}
+	_, err := io.WriteString(r.w, "[")
+	return err
+}
+
+// write writes a row, as one line of CSV or one JSON object per line
+func (r *rowWriter) write(row *ExportRow) error {
+	if r.csv != nil {
+		return r.csv.Write([]string{
+			csvText(row.Date), csvText(row.MAC), csvText(row.Name), strconv.Itoa(row.BlockIndex), csvText(row.StartTime), csvText(row.EndTime),
+			strconv.Itoa(row.UsedMinutes), strconv.Itoa(row.UsedSeconds), strconv.FormatInt(row.UsedBytes, 10),
+			optionalInt(row.LimitMinutes), optionalInt64(row.LimitBytes),
+			strconv.Itoa(row.BonusMinutes), strconv.FormatInt(row.BonusBytes, 10),
+			strconv.FormatBool(row.IsBlocked), csvText(row.BlockedReason),
+		})
+	}
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if r.first {
+		separator = "\n"
+		r.first = false
+	}
+	_, err = io.WriteString(r.w, separator+string(data))
+	return err
+}
+
+// flush passes buffered CSV rows on to the underlying writer
+func (r *rowWriter) flush() error {
+	if r.csv == nil {
+		return nil
+	}
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+// end flushes the CSV rows or closes the JSON array
+func (r *rowWriter) end() error {
+	if r.csv != nil {
+		return r.flush()
+	}
+	_, err := io.WriteString(r.w, "\n]\n")
+	return err
+}
+
+// csvText escapes a text cell that a spreadsheet would run as a formula, such
+// as a device named "=HYPERLINK(...)", by prefixing it with a quote
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// optionalInt formats a limit, empty without one
+func optionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+// optionalInt64 formats a limit, empty without one
+func optionalInt64(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
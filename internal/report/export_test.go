@@ -0,0 +1,70 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// TestExportFormulas exports device names that spreadsheets would run as
+// formulas; CSV cells are quoted, JSON keeps the names as they are
+func TestExportFormulas(t *testing.T) {
+	store := storage.NewMemory()
+	names := map[string]string{
+		"aa:bb:cc:dd:ee:01": `=HYPERLINK("http://evil.example","click")`,
+		"aa:bb:cc:dd:ee:02": "+1+1",
+		"aa:bb:cc:dd:ee:03": "-2+3",
+		"aa:bb:cc:dd:ee:04": "@SUM(A1)",
+		"aa:bb:cc:dd:ee:05": "Tablet",
+	}
+	want := map[string]string{
+		"aa:bb:cc:dd:ee:01": `'=HYPERLINK("http://evil.example","click")`,
+		"aa:bb:cc:dd:ee:02": "'+1+1",
+		"aa:bb:cc:dd:ee:03": "'-2+3",
+		"aa:bb:cc:dd:ee:04": "'@SUM(A1)",
+		"aa:bb:cc:dd:ee:05": "Tablet",
+	}
+	for mac, name := range names {
+		if err := store.SaveDeviceConfig(&storage.DeviceConfig{MAC: mac, Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetOrCreateBlockUsage(mac, "2024-03-01", 0, "00:00", "24:00", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filter := ExportFilter{From: "2024-03-01", To: "2024-03-01"}
+
+	var out bytes.Buffer
+	if err := Export(&out, store, FormatCSV, filter); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(names)+1 {
+		t.Fatalf("got %d CSV records, want a header and %d rows", len(records), len(names))
+	}
+	for _, record := range records[1:] {
+		if mac, name := record[1], record[2]; name != want[mac] {
+			t.Errorf("CSV name of %s = %q, want %q", mac, name, want[mac])
+		}
+	}
+
+	out.Reset()
+	if err := Export(&out, store, FormatJSON, filter); err != nil {
+		t.Fatal(err)
+	}
+	var rows []ExportRow
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if row.Name != names[row.MAC] {
+			t.Errorf("JSON name of %s = %q, want %q", row.MAC, row.Name, names[row.MAC])
+		}
+	}
+}
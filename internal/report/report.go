@@ -0,0 +1,200 @@
+// Package report aggregates stored usage into reports: when a device is used
+// during the week and how a week compares with the one before.
+package report
+
+import (
+	"math"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Weekdays lists the days of a week, starting on Monday
+var Weekdays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// Heatmap is the active time of a device per weekday and hour of the day
+type Heatmap struct {
+	MAC  string    `json:"mac"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Days []string  `json:"days"`
+	// ActiveMinutes holds the minutes per day of Days and hour of the day
+	ActiveMinutes [7][24]int `json:"active_minutes"`
+}
+
+// NewHeatmap adds up the active time of samples per weekday and hour in loc.
+// Daily samples cannot be placed in an hour and are skipped. So are hourly
+// samples that do not start on a full hour of loc: they were rolled up in
+// the hours of another zone, such as one whose offset differs by half an
+// hour, and span two hours of loc.
+func NewHeatmap(mac string, from, to time.Time, samples []*storage.TrafficSample, loc *time.Location) *Heatmap {
+	var seconds [7][24]int
+	for _, sample := range samples {
+		if sample.Resolution == storage.ResolutionDay {
+			continue
+		}
+		start := sample.Start.In(loc)
+		if sample.Resolution == storage.ResolutionHour && (start.Minute() != 0 || start.Second() != 0) {
+			continue
+		}
+		seconds[weekday(start)][start.Hour()] += sample.ActiveSeconds
+	}
+
+	heatmap := &Heatmap{MAC: mac, From: from.In(loc), To: to.In(loc), Days: Weekdays}
+	for day := range seconds {
+		for hour := range seconds[day] {
+			heatmap.ActiveMinutes[day][hour] = seconds[day][hour] / 60
+		}
+	}
+	return heatmap
+}
+
+// weekday returns the index of the day of t in Weekdays
+func weekday(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+// WeekStart returns the Monday of the week of t, at midnight in its location
+func WeekStart(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day-weekday(t), 0, 0, 0, 0, t.Location())
+}
+
+// Totals is the usage of a device over a period
+type Totals struct {
+	UsedMinutes int   `json:"used_minutes"`
+	UsedSeconds int   `json:"used_seconds"`
+	UsedBytes   int64 `json:"used_bytes"`
+}
+
+// add adds the usage of a time block
+func (t *Totals) add(usage *storage.BlockUsage) {
+	t.UsedSeconds += usage.UsedSeconds
+	t.UsedMinutes = t.UsedSeconds / 60
+	t.UsedBytes += usage.UsedBytes
+}
+
+// DayTotals is the usage of a device on a day
+type DayTotals struct {
+	Date string `json:"date"`
+	Totals
+}
+
+// BlockUtilization is how much of the limits of a time block a device used
+// over a week. Blocks are told apart by their start and end time.
+type BlockUtilization struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Days      int    `json:"days"` // Days the block was in use
+	Totals
+	// LimitMinutes and LimitBytes add up the limits, including bonus time
+	// and data, of the days the block had them
+	LimitMinutes int   `json:"limit_minutes,omitempty"`
+	LimitBytes   int64 `json:"limit_bytes,omitempty"`
+	// TimePercent and DataPercent are the usage on the days with a limit as
+	// a percentage of it
+	TimePercent  *float64 `json:"time_percent,omitempty"`
+	DataPercent  *float64 `json:"data_percent,omitempty"`
+	LimitReached int      `json:"limit_reached"` // Days the time or data limit was reached
+
+	timeUsed int   // Seconds used on days with a time limit
+	dataUsed int64 // Bytes used on days with a data limit
+}
+
+// Weekly compares the usage of a device in a week with the week before
+type Weekly struct {
+	MAC          string      `json:"mac"`
+	Week         string      `json:"week"` // Monday, YYYY-MM-DD
+	Days         []DayTotals `json:"days"`
+	Total        Totals      `json:"total"`
+	PreviousWeek Totals      `json:"previous_week"`
+	// TimeChangePercent and DataChangePercent compare the total with the
+	// previous week; they are omitted when nothing was used then
+	TimeChangePercent *float64           `json:"time_change_percent,omitempty"`
+	DataChangePercent *float64           `json:"data_change_percent,omitempty"`
+	Blocks            []BlockUtilization `json:"blocks"`
+}
+
+// NewWeekly builds the report of the week starting on monday from usage
+// records of that week and the one before
+func NewWeekly(mac string, monday time.Time, usages []*storage.BlockUsage) *Weekly {
+	report := &Weekly{MAC: mac, Week: monday.Format("2006-01-02"), Blocks: []BlockUtilization{}}
+	previous := monday.AddDate(0, 0, -7).Format("2006-01-02")
+
+	report.Days = make([]DayTotals, len(Weekdays))
+	days := make(map[string]*DayTotals)
+	for i := range report.Days {
+		report.Days[i].Date = monday.AddDate(0, 0, i).Format("2006-01-02")
+		days[report.Days[i].Date] = &report.Days[i]
+	}
+
+	blocks := make(map[string]int) // "start-end" -> index in report.Blocks
+	for _, usage := range usages {
+		day, ok := days[usage.Date]
+		if !ok {
+			if usage.Date >= previous && usage.Date < report.Week {
+				report.PreviousWeek.add(usage)
+			}
+			continue
+		}
+		day.add(usage)
+		report.Total.add(usage)
+
+		key := usage.StartTime + "-" + usage.EndTime
+		i, ok := blocks[key]
+		if !ok {
+			i = len(report.Blocks)
+			blocks[key] = i
+			report.Blocks = append(report.Blocks, BlockUtilization{StartTime: usage.StartTime, EndTime: usage.EndTime})
+		}
+		report.Blocks[i].add(usage)
+	}
+
+	for i := range report.Blocks {
+		block := &report.Blocks[i]
+		if block.LimitMinutes > 0 {
+			block.TimePercent = percent(float64(block.timeUsed), float64(block.LimitMinutes*60))
+		}
+		if block.LimitBytes > 0 {
+			block.DataPercent = percent(float64(block.dataUsed), float64(block.LimitBytes))
+		}
+	}
+
+	if report.PreviousWeek.UsedSeconds > 0 {
+		report.TimeChangePercent = percent(float64(report.Total.UsedSeconds-report.PreviousWeek.UsedSeconds), float64(report.PreviousWeek.UsedSeconds))
+	}
+	if report.PreviousWeek.UsedBytes > 0 {
+		report.DataChangePercent = percent(float64(report.Total.UsedBytes-report.PreviousWeek.UsedBytes), float64(report.PreviousWeek.UsedBytes))
+	}
+
+	return report
+}
+
+// add adds the usage of the block on one day
+func (b *BlockUtilization) add(usage *storage.BlockUsage) {
+	b.Days++
+	b.Totals.add(usage)
+
+	reached := false
+	if usage.LimitMinutes != nil {
+		limit := *usage.LimitMinutes + usage.BonusMinutes
+		b.LimitMinutes += limit
+		b.timeUsed += usage.UsedSeconds
+		reached = usage.UsedSeconds >= limit*60
+	}
+	if usage.LimitBytes != nil {
+		limit := *usage.LimitBytes + usage.BonusBytes
+		b.LimitBytes += limit
+		b.dataUsed += usage.UsedBytes
+		reached = reached || usage.UsedBytes >= limit
+	}
+	if reached {
+		b.LimitReached++
+	}
+}
+
+// percent returns part as a percentage of whole, rounded to one decimal
+func percent(part, whole float64) *float64 {
+	p := math.Round(part/whole*1000) / 10
+	return &p
+}
@@ -0,0 +1,33 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+func TestNewHeatmap(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata") // UTC+05:30
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+
+	// Monday, 2024-03-04
+	samples := []*storage.TrafficSample{
+		// Rolled up in Kolkata: 09:00-10:00 local
+		{Resolution: storage.ResolutionHour, Start: time.Date(2024, 3, 4, 9, 0, 0, 0, kolkata), ActiveSeconds: 1800},
+		// Rolled up in UTC: 10:30-11:30 local, spanning two hours
+		{Resolution: storage.ResolutionHour, Start: time.Date(2024, 3, 4, 5, 0, 0, 0, time.UTC), ActiveSeconds: 3600},
+		{Resolution: storage.ResolutionMinute, Start: time.Date(2024, 3, 4, 10, 59, 0, 0, kolkata), ActiveSeconds: 60},
+		{Resolution: storage.ResolutionDay, Start: time.Date(2024, 3, 4, 0, 0, 0, 0, kolkata), ActiveSeconds: 7200},
+	}
+
+	heatmap := NewHeatmap("aa:bb:cc:dd:ee:ff", time.Time{}, time.Time{}, samples, kolkata)
+	var want [7][24]int
+	want[0][9] = 30
+	want[0][10] = 1
+	if heatmap.ActiveMinutes != want {
+		t.Errorf("monday = %v, want %v", heatmap.ActiveMinutes[0], want[0])
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// eventColumns lists the events table columns in scan order
+const eventColumns = `id, created_at, type, mac, actor, reason, details, used_minutes, used_bytes, limit_minutes, limit_bytes`
+
+// insertEventQuery returns the INSERT statement for an event, without the id
+func insertEventQuery(bind func(n int) string) string {
+	placeholders := make([]string, 10)
+	for i := range placeholders {
+		placeholders[i] = bind(i + 1)
+	}
+	return fmt.Sprintf(`INSERT INTO events (created_at, type, mac, actor, reason, details, used_minutes, used_bytes, limit_minutes, limit_bytes)
+		VALUES (%s)`, strings.Join(placeholders, ", "))
+}
+
+// insertEventArgs returns the arguments for insertEventQuery. Times are
+// stored in UTC so they compare correctly as text in SQLite.
+func insertEventArgs(event *Event) []interface{} {
+	return []interface{}{
+		event.Time.UTC(), event.Type, event.MAC, event.Actor, event.Reason, event.Details,
+		event.UsedMinutes, event.UsedBytes, event.LimitMinutes, event.LimitBytes,
+	}
+}
+
+// listEventsQuery builds the SELECT statement and arguments for a filter
+func listEventsQuery(filter EventFilter, bind func(n int) string) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, bind(len(args))))
+	}
+
+	if filter.MAC != "" {
+		add("mac = %s", filter.MAC)
+	}
+	if filter.Type != "" {
+		add("type = %s", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= %s", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		add("created_at < %s", filter.To.UTC())
+	}
+
+	query := "SELECT " + eventColumns + " FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT " + bind(len(args))
+	}
+
+	return query, args
+}
+
+// scanEvents reads events selected with eventColumns
+func scanEvents(rows *sql.Rows) ([]*Event, error) {
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		var event Event
+		var limitMinutes, limitBytes sql.NullInt64
+		if err := rows.Scan(
+			&event.ID, &event.Time, &event.Type, &event.MAC, &event.Actor, &event.Reason, &event.Details,
+			&event.UsedMinutes, &event.UsedBytes, &limitMinutes, &limitBytes,
+		); err != nil {
+			return nil, err
+		}
+		if limitMinutes.Valid {
+			v := int(limitMinutes.Int64)
+			event.LimitMinutes = &v
+		}
+		if limitBytes.Valid {
+			event.LimitBytes = &limitBytes.Int64
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// exceptionColumns lists the schedule_exceptions table columns in scan order
+const exceptionColumns = `id, mac, name, start_date, end_date, mode, schedules, source, created_by, created_at, updated_at`
+
+// insertExceptionQuery returns the INSERT statement for an exception, without the id
+func insertExceptionQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`INSERT INTO schedule_exceptions (mac, name, start_date, end_date, mode, schedules, source, created_by, created_at, updated_at)
+		VALUES (%s)`, bindList(bind, 1, 10))
+}
+
+// insertExceptionArgs returns the arguments for insertExceptionQuery
+func insertExceptionArgs(exception *ScheduleException) ([]interface{}, error) {
+	schedules, err := marshalExceptionSchedules(exception)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		exception.MAC, exception.Name, exception.StartDate, exception.EndDate, exception.Mode, schedules,
+		exception.Source, exception.CreatedBy, exception.CreatedAt.UTC(), exception.UpdatedAt.UTC(),
+	}, nil
+}
+
+// updateExceptionQuery returns the UPDATE statement for updateExceptionArgs,
+// which leaves the source alone
+func updateExceptionQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`UPDATE schedule_exceptions SET mac = %s, name = %s, start_date = %s, end_date = %s, mode = %s,
+		schedules = %s, updated_at = %s WHERE id = %s`,
+		bind(1), bind(2), bind(3), bind(4), bind(5), bind(6), bind(7), bind(8))
+}
+
+func updateExceptionArgs(exception *ScheduleException) ([]interface{}, error) {
+	schedules, err := marshalExceptionSchedules(exception)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		exception.MAC, exception.Name, exception.StartDate, exception.EndDate, exception.Mode, schedules,
+		exception.UpdatedAt.UTC(), exception.ID,
+	}, nil
+}
+
+// marshalExceptionSchedules encodes the alternate schedules of an exception,
+// storing an empty list rather than null
+func marshalExceptionSchedules(exception *ScheduleException) (string, error) {
+	if exception.DailySchedules == nil {
+		return "[]", nil
+	}
+	return MarshalSchedules(exception.DailySchedules)
+}
+
+// listExceptionsQuery selects the exceptions matching filter ordered by start date
+func listExceptionsQuery(filter ExceptionFilter, bind func(n int) string) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if filter.MAC != "" {
+		args = append(args, filter.MAC)
+		where = append(where, fmt.Sprintf("(mac = %s OR mac = '')", bind(len(args))))
+	}
+	if filter.Date != "" {
+		args = append(args, filter.Date, filter.Date)
+		where = append(where, fmt.Sprintf("start_date <= %s AND end_date >= %s", bind(len(args)-1), bind(len(args))))
+	}
+
+	query := "SELECT " + exceptionColumns + " FROM schedule_exceptions"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	return query + " ORDER BY start_date, id", args
+}
+
+// scanExceptions reads exceptions selected with exceptionColumns
+func scanExceptions(rows *sql.Rows) ([]*ScheduleException, error) {
+	defer rows.Close()
+
+	var exceptions []*ScheduleException
+	for rows.Next() {
+		var exception ScheduleException
+		var schedules string
+		if err := rows.Scan(
+			&exception.ID, &exception.MAC, &exception.Name, &exception.StartDate, &exception.EndDate,
+			&exception.Mode, &schedules, &exception.Source, &exception.CreatedBy, &exception.CreatedAt, &exception.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		var err error
+		if exception.DailySchedules, err = UnmarshalSchedules(schedules); err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, &exception)
+	}
+
+	return exceptions, rows.Err()
+}
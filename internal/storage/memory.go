@@ -0,0 +1,996 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory implements the storage interface in process memory. Nothing is
+// persisted, which makes it suitable for tests and ephemeral runs.
+type Memory struct {
+	mu          sync.RWMutex
+	configs     map[string]*DeviceConfig
+	usage       map[usageKey]*BlockUsage
+	states      map[string]*DeviceState
+	overrides   map[string]*Override
+	exceptions  map[int64]*ScheduleException
+	driftEvents []*DriftEvent
+	sessions    map[string]*Session
+	users       map[string]*User
+	events      []*Event
+	traffic     map[trafficKey]*TrafficSample
+	webhooks    map[int64]*Webhook
+	deliveries  []*WebhookDelivery
+	nextID      int64
+}
+
+var _ Store = (*Memory)(nil)
+
+// usageKey identifies a block usage record
+type usageKey struct {
+	mac        string
+	date       string
+	blockIndex int
+}
+
+// trafficKey identifies a traffic sample
+type trafficKey struct {
+	mac        string
+	resolution string
+	start      int64 // Unix nanoseconds
+}
+
+// NewMemory creates a new, empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		configs:    make(map[string]*DeviceConfig),
+		usage:      make(map[usageKey]*BlockUsage),
+		states:     make(map[string]*DeviceState),
+		overrides:  make(map[string]*Override),
+		exceptions: make(map[int64]*ScheduleException),
+		sessions:   make(map[string]*Session),
+		users:      make(map[string]*User),
+		webhooks:   make(map[int64]*Webhook),
+		traffic:    make(map[trafficKey]*TrafficSample),
+	}
+}
+
+// Close is a no-op for the in-memory store
+func (m *Memory) Close() error {
+	return nil
+}
+
+// id returns the next record ID; callers must hold the write lock
+func (m *Memory) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+// SaveDeviceConfig saves or updates a device configuration
+func (m *Memory) SaveDeviceConfig(config *DeviceConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	stored := copyDeviceConfig(config)
+	stored.CreatedAt = now
+	if existing, ok := m.configs[config.MAC]; ok {
+		stored.CreatedAt = existing.CreatedAt
+	}
+	stored.UpdatedAt = now
+	m.configs[config.MAC] = stored
+	return nil
+}
+
+// GetDeviceConfig retrieves a device configuration by MAC
+func (m *Memory) GetDeviceConfig(mac string) (*DeviceConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	config, ok := m.configs[mac]
+	if !ok {
+		return nil, nil
+	}
+	return copyDeviceConfig(config), nil
+}
+
+// GetAllDeviceConfigs retrieves all device configurations
+func (m *Memory) GetAllDeviceConfigs() ([]*DeviceConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var configs []*DeviceConfig
+	for _, config := range m.configs {
+		configs = append(configs, copyDeviceConfig(config))
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].MAC < configs[j].MAC })
+	return configs, nil
+}
+
+// DeleteDeviceConfig removes a device configuration
+func (m *Memory) DeleteDeviceConfig(mac string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.configs, mac)
+	return nil
+}
+
+// GetOrCreateBlockUsage gets or creates a usage record for a time block
+func (m *Memory) GetOrCreateBlockUsage(mac, date string, blockIndex int, startTime, endTime string, limitMinutes *int, limitBytes *int64) (*BlockUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := usageKey{mac: mac, date: date, blockIndex: blockIndex}
+	if usage, ok := m.usage[key]; ok {
+		copied := *usage
+		return &copied, nil
+	}
+
+	usage := &BlockUsage{
+		ID:           m.id(),
+		MAC:          mac,
+		Date:         date,
+		BlockIndex:   blockIndex,
+		StartTime:    startTime,
+		EndTime:      endTime,
+		LimitMinutes: limitMinutes,
+		LimitBytes:   limitBytes,
+		LastUpdated:  time.Now(),
+	}
+	m.usage[key] = usage
+
+	copied := *usage
+	return &copied, nil
+}
+
+// UpdateBlockUsage updates the block state and sent warnings of a usage record
+func (m *Memory) UpdateBlockUsage(usage *BlockUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.usage {
+		if stored.ID != usage.ID {
+			continue
+		}
+		stored.IsBlocked = usage.IsBlocked
+		stored.BlockedReason = usage.BlockedReason
+		stored.WarningsSent = usage.WarningsSent
+		stored.LastUpdated = time.Now()
+		return nil
+	}
+	return nil
+}
+
+// AddBlockUsage adds active seconds and bytes to a usage record
+func (m *Memory) AddBlockUsage(id int64, seconds int, bytes, lastTxBytes, lastRxBytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.usage {
+		if stored.ID != id {
+			continue
+		}
+		stored.UsedSeconds += seconds
+		stored.UsedBytes += bytes
+		stored.LastTxBytes = lastTxBytes
+		stored.LastRxBytes = lastRxBytes
+		stored.LastUpdated = time.Now()
+		return nil
+	}
+	return nil
+}
+
+// GetBlockUsageForDate retrieves all usage records for a device on a date
+func (m *Memory) GetBlockUsageForDate(mac, date string) ([]*BlockUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var usages []*BlockUsage
+	for key, usage := range m.usage {
+		if key.mac == mac && key.date == date {
+			copied := *usage
+			usages = append(usages, &copied)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].BlockIndex < usages[j].BlockIndex })
+	return usages, nil
+}
+
+// GetBlockUsageRange retrieves the usage records of a device from one date to
+// another, inclusive, ordered by date and block
+func (m *Memory) GetBlockUsageRange(mac, from, to string) ([]*BlockUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var usages []*BlockUsage
+	for key, usage := range m.usage {
+		if key.mac == mac && key.date >= from && key.date <= to {
+			copied := *usage
+			usages = append(usages, &copied)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Date != usages[j].Date {
+			return usages[i].Date < usages[j].Date
+		}
+		return usages[i].BlockIndex < usages[j].BlockIndex
+	})
+	return usages, nil
+}
+
+// GetAllUsageForDate retrieves usage for all managed devices on a date
+func (m *Memory) GetAllUsageForDate(date string) (map[string][]*BlockUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usages := make(map[string][]*BlockUsage)
+	for key, usage := range m.usage {
+		if key.date == date {
+			copied := *usage
+			usages[key.mac] = append(usages[key.mac], &copied)
+		}
+	}
+	for _, list := range usages {
+		sort.Slice(list, func(i, j int) bool { return list[i].BlockIndex < list[j].BlockIndex })
+	}
+	return usages, nil
+}
+
+// GetUsageHistory retrieves historical usage for a device
+func (m *Memory) GetUsageHistory(mac string, since string) ([]*HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make(map[string]*HistoryEntry)
+	var usages []*BlockUsage
+	for key, usage := range m.usage {
+		if key.mac == mac && key.date >= since {
+			usages = append(usages, usage)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].BlockIndex < usages[j].BlockIndex })
+
+	for _, usage := range usages {
+		entry, ok := entries[usage.Date]
+		if !ok {
+			entry = &HistoryEntry{Date: usage.Date}
+			entries[usage.Date] = entry
+		}
+		entry.TotalSeconds += usage.UsedSeconds
+		entry.TotalMinutes = entry.TotalSeconds / 60
+		entry.TotalBytes += usage.UsedBytes
+		entry.Blocks = append(entry.Blocks, usage.Summary())
+	}
+
+	var history []*HistoryEntry
+	for _, entry := range entries {
+		history = append(history, entry)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Date > history[j].Date })
+	return history, nil
+}
+
+// SaveDeviceState saves the current blocking state of a device
+func (m *Memory) SaveDeviceState(state *DeviceState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *state
+	m.states[state.MAC] = &copied
+	return nil
+}
+
+// GetDeviceState retrieves the current blocking state of a device
+func (m *Memory) GetDeviceState(mac string) (*DeviceState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state, ok := m.states[mac]
+	if !ok {
+		return &DeviceState{MAC: mac}, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+
+// SaveOverride sets the override of a device, replacing any previous one
+func (m *Memory) SaveOverride(override *Override) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+	copied := *override
+	m.overrides[override.MAC] = &copied
+	return nil
+}
+
+// GetOverride retrieves the override of a device, nil if there is none
+func (m *Memory) GetOverride(mac string) (*Override, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	override, ok := m.overrides[mac]
+	if !ok {
+		return nil, nil
+	}
+	copied := *override
+	return &copied, nil
+}
+
+// ListOverrides retrieves all overrides ordered by expiry
+func (m *Memory) ListOverrides() ([]*Override, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var overrides []*Override
+	for _, override := range m.overrides {
+		copied := *override
+		overrides = append(overrides, &copied)
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		if !overrides[i].ExpiresAt.Equal(overrides[j].ExpiresAt) {
+			return overrides[i].ExpiresAt.Before(overrides[j].ExpiresAt)
+		}
+		return overrides[i].MAC < overrides[j].MAC
+	})
+	return overrides, nil
+}
+
+// DeleteOverride removes the override of a device
+func (m *Memory) DeleteOverride(mac string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.overrides, mac)
+	return nil
+}
+
+// copyException returns a copy of exception that shares no slices with it
+func copyException(exception *ScheduleException) *ScheduleException {
+	copied := *exception
+	copied.DailySchedules = copySchedules(exception.DailySchedules)
+	return &copied
+}
+
+// CreateScheduleException adds a schedule exception
+func (m *Memory) CreateScheduleException(exception *ScheduleException) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	exception.ID = m.id()
+	exception.CreatedAt = now
+	exception.UpdatedAt = now
+	m.exceptions[exception.ID] = copyException(exception)
+	return nil
+}
+
+// GetScheduleException retrieves a schedule exception, nil if unknown
+func (m *Memory) GetScheduleException(id int64) (*ScheduleException, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exception, ok := m.exceptions[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyException(exception), nil
+}
+
+// ListScheduleExceptions retrieves the exceptions matching filter ordered by start date
+func (m *Memory) ListScheduleExceptions(filter ExceptionFilter) ([]*ScheduleException, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var exceptions []*ScheduleException
+	for _, exception := range m.exceptions {
+		if filter.MAC != "" && exception.MAC != filter.MAC && exception.MAC != "" {
+			continue
+		}
+		if filter.Date != "" && (exception.StartDate > filter.Date || exception.EndDate < filter.Date) {
+			continue
+		}
+		exceptions = append(exceptions, copyException(exception))
+	}
+	sort.Slice(exceptions, func(i, j int) bool {
+		if exceptions[i].StartDate != exceptions[j].StartDate {
+			return exceptions[i].StartDate < exceptions[j].StartDate
+		}
+		return exceptions[i].ID < exceptions[j].ID
+	})
+	return exceptions, nil
+}
+
+// UpdateScheduleException updates all fields of a schedule exception but its creation
+func (m *Memory) UpdateScheduleException(exception *ScheduleException) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.exceptions[exception.ID]
+	if !ok {
+		return nil
+	}
+	exception.UpdatedAt = time.Now()
+	updated := copyException(exception)
+	updated.Source = stored.Source
+	updated.CreatedBy = stored.CreatedBy
+	updated.CreatedAt = stored.CreatedAt
+	m.exceptions[exception.ID] = updated
+	return nil
+}
+
+// DeleteScheduleException removes a schedule exception
+func (m *Memory) DeleteScheduleException(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.exceptions, id)
+	return nil
+}
+
+// ReplaceScheduleExceptions atomically replaces the exceptions imported from
+// source with exceptions, setting their source
+func (m *Memory) ReplaceScheduleExceptions(source string, exceptions []*ScheduleException) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, exception := range m.exceptions {
+		if exception.Source == source {
+			delete(m.exceptions, id)
+		}
+	}
+
+	now := time.Now()
+	for _, exception := range exceptions {
+		exception.ID = m.id()
+		exception.Source = source
+		exception.CreatedAt = now
+		exception.UpdatedAt = now
+		m.exceptions[exception.ID] = copyException(exception)
+	}
+	return nil
+}
+
+// AddBonusTime adds bonus minutes to the current time block
+func (m *Memory) AddBonusTime(mac string, date string, blockIndex int, minutes int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if usage, ok := m.usage[usageKey{mac: mac, date: date, blockIndex: blockIndex}]; ok {
+		usage.BonusMinutes += minutes
+		usage.LastUpdated = time.Now()
+	}
+	return nil
+}
+
+// AddBonusData adds bonus bytes to the current time block
+func (m *Memory) AddBonusData(mac string, date string, blockIndex int, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if usage, ok := m.usage[usageKey{mac: mac, date: date, blockIndex: blockIndex}]; ok {
+		usage.BonusBytes += bytes
+		usage.LastUpdated = time.Now()
+	}
+	return nil
+}
+
+// SaveDriftEvent records a reconciliation drift event
+func (m *Memory) SaveDriftEvent(event *DriftEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = m.id()
+	copied := *event
+	m.driftEvents = append(m.driftEvents, &copied)
+	return nil
+}
+
+// GetDriftEvents retrieves the most recent drift events, optionally filtered by MAC
+func (m *Memory) GetDriftEvents(mac string, limit int) ([]*DriftEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*DriftEvent
+	for _, event := range m.driftEvents {
+		if mac != "" && event.MAC != mac {
+			continue
+		}
+		copied := *event
+		events = append(events, &copied)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].DetectedAt.Equal(events[j].DetectedAt) {
+			return events[i].ID > events[j].ID
+		}
+		return events[i].DetectedAt.After(events[j].DetectedAt)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// CreateSession stores a new login session
+func (m *Memory) CreateSession(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *session
+	m.sessions[session.ID] = &copied
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (m *Memory) GetSession(id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// UpdateSession updates the refresh ID and expiry of a session
+func (m *Memory) UpdateSession(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.sessions[session.ID]; ok {
+		stored.RefreshID = session.RefreshID
+		stored.ExpiresAt = session.ExpiresAt
+	}
+	return nil
+}
+
+// RevokeSession marks a session as revoked
+func (m *Memory) RevokeSession(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.sessions[id]; ok && stored.RevokedAt.IsZero() {
+		stored.RevokedAt = at
+	}
+	return nil
+}
+
+// RevokeUserSessions marks every session of a user as revoked
+func (m *Memory) RevokeUserSessions(username string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.sessions {
+		if stored.Username == username && stored.RevokedAt.IsZero() {
+			stored.RevokedAt = at
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredSessions removes sessions that expired before a point in time
+func (m *Memory) DeleteExpiredSessions(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// CreateUser stores a new user, returning ErrUserExists if the username is taken
+func (m *Memory) CreateUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.Username]; ok {
+		return ErrUserExists
+	}
+
+	now := time.Now()
+	user.ID = m.id()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	copied := *user
+	m.users[user.Username] = &copied
+	return nil
+}
+
+// GetUser retrieves a user by username
+func (m *Memory) GetUser(username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// GetUserByOIDC retrieves the user bound to an OpenID Connect identity
+func (m *Memory) GetUserByOIDC(issuer, subject string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if subject == "" {
+		return nil, nil
+	}
+	for _, user := range m.users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListUsers retrieves all users ordered by username
+func (m *Memory) ListUsers() ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []*User
+	for _, user := range m.users {
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// UpdateUser updates the password hash, role and OIDC identity of a user
+func (m *Memory) UpdateUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.users[user.Username]; ok {
+		stored.PasswordHash = user.PasswordHash
+		stored.Role = user.Role
+		stored.OIDCIssuer = user.OIDCIssuer
+		stored.OIDCSubject = user.OIDCSubject
+		stored.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// DeleteUser removes a user
+func (m *Memory) DeleteUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.users, username)
+	return nil
+}
+
+// SaveEvent appends an event to the audit log
+func (m *Memory) SaveEvent(event *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = m.id()
+	copied := *event
+	m.events = append(m.events, &copied)
+	return nil
+}
+
+// ListEvents retrieves events matching filter, newest first
+func (m *Memory) ListEvents(filter EventFilter) ([]*Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*Event
+	for _, event := range m.events {
+		if filter.MAC != "" && event.MAC != filter.MAC {
+			continue
+		}
+		if filter.Type != "" && event.Type != filter.Type {
+			continue
+		}
+		if !filter.From.IsZero() && event.Time.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !event.Time.Before(filter.To) {
+			continue
+		}
+		copied := *event
+		events = append(events, &copied)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Time.Equal(events[j].Time) {
+			return events[i].ID > events[j].ID
+		}
+		return events[i].Time.After(events[j].Time)
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// copyDeviceConfig returns a copy of a config that shares no schedules with the original
+func copyDeviceConfig(config *DeviceConfig) *DeviceConfig {
+	copied := *config
+	copied.DailySchedules = copySchedules(config.DailySchedules)
+	return &copied
+}
+
+// copySchedules deep-copies a list of day schedules
+func copySchedules(schedules []DaySchedule) []DaySchedule {
+	if schedules == nil {
+		return nil
+	}
+	copied := make([]DaySchedule, len(schedules))
+	for i, schedule := range schedules {
+		copied[i] = DaySchedule{
+			Days:       append([]string(nil), schedule.Days...),
+			TimeBlocks: append([]TimeBlock(nil), schedule.TimeBlocks...),
+		}
+	}
+	return copied
+}
+
+// copyWebhook returns a copy of webhook that shares no slices with it
+func copyWebhook(webhook *Webhook) *Webhook {
+	copied := *webhook
+	copied.Events = append([]string{}, webhook.Events...)
+	return &copied
+}
+
+// AddTrafficSample adds a sample to the one of its device, resolution and
+// start, creating it if there is none
+func (m *Memory) AddTrafficSample(sample *TrafficSample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addTraffic(sample)
+	return nil
+}
+
+// addTraffic adds a sample; callers must hold the write lock
+func (m *Memory) addTraffic(sample *TrafficSample) {
+	key := trafficKey{sample.MAC, sample.Resolution, sample.Start.UnixNano()}
+	if stored, ok := m.traffic[key]; ok {
+		stored.Add(sample)
+		return
+	}
+	copied := *sample
+	copied.Start = sample.Start.UTC()
+	m.traffic[key] = &copied
+}
+
+// ListTrafficSamples retrieves the samples matching filter ordered by start
+func (m *Memory) ListTrafficSamples(filter TrafficFilter) ([]*TrafficSample, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var samples []*TrafficSample
+	for _, sample := range m.traffic {
+		if filter.MAC != "" && sample.MAC != filter.MAC {
+			continue
+		}
+		if filter.Resolution != "" && sample.Resolution != filter.Resolution {
+			continue
+		}
+		if !filter.From.IsZero() && sample.Start.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !sample.Start.Before(filter.To) {
+			continue
+		}
+		copied := *sample
+		samples = append(samples, &copied)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		switch {
+		case !a.Start.Equal(b.Start):
+			return a.Start.Before(b.Start)
+		case a.MAC != b.MAC:
+			return a.MAC < b.MAC
+		}
+		return a.Resolution < b.Resolution
+	})
+	return samples, nil
+}
+
+// RollupTrafficSamples atomically replaces the samples of a device and
+// resolution that start before a point in time with rollups
+func (m *Memory) RollupTrafficSamples(mac, resolution string, before time.Time, rollups []*TrafficSample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteTraffic(mac, resolution, before)
+	for _, rollup := range rollups {
+		m.addTraffic(rollup)
+	}
+	return nil
+}
+
+// DeleteTrafficSamples removes the samples of a resolution that start before
+// a point in time
+func (m *Memory) DeleteTrafficSamples(resolution string, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteTraffic("", resolution, before)
+	return nil
+}
+
+// deleteTraffic removes the samples of a resolution, and device unless mac
+// is empty, that start before a point in time; callers must hold the write lock
+func (m *Memory) deleteTraffic(mac, resolution string, before time.Time) {
+	for key, sample := range m.traffic {
+		if key.resolution == resolution && (mac == "" || key.mac == mac) && sample.Start.Before(before) {
+			delete(m.traffic, key)
+		}
+	}
+}
+
+// CreateWebhook adds a webhook subscription
+func (m *Memory) CreateWebhook(webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	webhook.ID = m.id()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+	m.webhooks[webhook.ID] = copyWebhook(webhook)
+	return nil
+}
+
+// GetWebhook retrieves a webhook, nil if unknown
+func (m *Memory) GetWebhook(id int64) (*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyWebhook(webhook), nil
+}
+
+// ListWebhooks retrieves all webhooks ordered by ID
+func (m *Memory) ListWebhooks() ([]*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var webhooks []*Webhook
+	for _, webhook := range m.webhooks {
+		webhooks = append(webhooks, copyWebhook(webhook))
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+// UpdateWebhook updates the URL, secret, events, description and enabled flag of a webhook
+func (m *Memory) UpdateWebhook(webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.webhooks[webhook.ID]
+	if !ok {
+		return nil
+	}
+	webhook.UpdatedAt = time.Now()
+	stored.URL = webhook.URL
+	stored.Secret = webhook.Secret
+	stored.Events = append([]string{}, webhook.Events...)
+	stored.Description = webhook.Description
+	stored.Enabled = webhook.Enabled
+	stored.UpdatedAt = webhook.UpdatedAt
+	return nil
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func (m *Memory) DeleteWebhook(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.webhooks, id)
+	kept := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookID != id {
+			kept = append(kept, delivery)
+		}
+	}
+	m.deliveries = kept
+	return nil
+}
+
+// CreateWebhookDelivery queues a delivery
+func (m *Memory) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	delivery.ID = m.id()
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+	copied := *delivery
+	m.deliveries = append(m.deliveries, &copied)
+	return nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (m *Memory) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.deliveries {
+		if stored.ID != delivery.ID {
+			continue
+		}
+		delivery.UpdatedAt = time.Now()
+		stored.Status = delivery.Status
+		stored.Attempts = delivery.Attempts
+		stored.ResponseCode = delivery.ResponseCode
+		stored.Error = delivery.Error
+		stored.NextAttemptAt = delivery.NextAttemptAt
+		stored.UpdatedAt = delivery.UpdatedAt
+		return nil
+	}
+	return nil
+}
+
+// ListWebhookDeliveries retrieves the deliveries of a webhook, newest first
+func (m *Memory) ListWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deliveries []*WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			copied := *m.deliveries[i]
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries, nil
+}
+
+// ListDueWebhookDeliveries retrieves pending deliveries due at now, oldest first
+func (m *Memory) ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deliveries []*WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			copied := *delivery
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// DeleteWebhookDeliveries removes succeeded and failed deliveries last
+// attempted before a point in time
+func (m *Memory) DeleteWebhookDeliveries(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.Status == DeliveryPending || !delivery.UpdatedAt.Before(before) {
+			kept = append(kept, delivery)
+		}
+	}
+	m.deliveries = kept
+	return nil
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// version of Zeitpolizei than the one running
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of zeitpolizei supports")
+
+// ErrSchemaOutdated is returned when a database opened without migrating
+// has migrations pending
+var ErrSchemaOutdated = errors.New(`database schema is outdated, run "zeitpolizei migrate up"`)
+
+// Migration is a numbered schema change with its upgrade and rollback steps
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// MigrationStatus describes whether a migration has been applied
+type MigrationStatus struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator applies and rolls back versioned migrations, tracking them in the
+// schema_migrations table
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// bind returns the placeholder for the nth query parameter
+	bind func(n int) string
+}
+
+// newMigrator creates a migrator for a database. Migrations must be ordered by version.
+func newMigrator(db *sql.DB, migrations []Migration, bind func(n int) string) *Migrator {
+	return &Migrator{db: db, migrations: migrations, bind: bind}
+}
+
+// Close closes the underlying database connection
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// Latest returns the highest migration version known to this build
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// ensureTable creates the schema_migrations table if needed
+func (m *Migrator) ensureTable() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	return err
+}
+
+// applied returns the applied migrations keyed by version
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// Version returns the highest applied migration version, 0 for an empty database
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Check returns ErrSchemaTooNew if the database has migrations applied that
+// this build does not know about
+func (m *Migrator) Check() error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w (database at version %d, latest known %d)", ErrSchemaTooNew, version, m.Latest())
+	}
+	return nil
+}
+
+// CheckCurrent returns ErrSchemaTooNew or ErrSchemaOutdated unless the
+// database is at the latest version this build knows about
+func (m *Migrator) CheckCurrent() error {
+	if err := m.Check(); err != nil {
+		return err
+	}
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version < m.Latest() {
+		return fmt.Errorf("%w (database at version %d, latest known %d)", ErrSchemaOutdated, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies all pending migrations up to and including target. A target of
+// 0 or less applies every known migration.
+func (m *Migrator) Up(target int) error {
+	if err := m.Check(); err != nil {
+		return err
+	}
+	if target <= 0 {
+		target = m.Latest()
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.run(migration, migration.Up, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Down rolls back applied migrations newer than target, newest first
+func (m *Migrator) Down(target int) error {
+	if err := m.Check(); err != nil {
+		return err
+	}
+	if target < 0 {
+		target = 0
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.run(migration, migration.Down, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		status = append(status, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return status, nil
+}
+
+// run executes the statements of a migration step in a transaction and
+// records or removes its schema_migrations row
+func (m *Migrator) run(migration Migration, statements []string, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(
+			fmt.Sprintf(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)`, m.bind(1), m.bind(2), m.bind(3)),
+			migration.Version, migration.Name, time.Now().UTC(),
+		)
+	} else {
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM schema_migrations WHERE version = %s`, m.bind(1)), migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+
+	return tx.Commit()
+}
+
+// sqliteBind returns SQLite query placeholders
+func sqliteBind(int) string {
+	return "?"
+}
+
+// postgresBind returns PostgreSQL query placeholders
+func postgresBind(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
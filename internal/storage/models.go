@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -12,23 +13,80 @@ type DeviceConfig struct {
 	Enabled        bool           `json:"enabled"`
 	BlockOutside   bool           `json:"block_outside_time_blocks"`
 	DailySchedules []DaySchedule  `json:"daily_schedules"`
+	Timezone       string         `json:"timezone,omitempty"` // IANA zone of the schedules, empty = configured default
+	Activity       *ActivityRules `json:"activity,omitempty"` // nil = configured default
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
+	UpdatedBy      string         `json:"updated_by,omitempty"` // User who last saved the config
+}
+
+// ActivityRules decide whether traffic in a poll interval counts as active
+// time. Rates are bytes per minute and a zero rate disables its rule.
+type ActivityRules struct {
+	MinBytes   int64 `json:"min_bytes" yaml:"activity_min_bytes"`       // Received and sent
+	MinRxBytes int64 `json:"min_rx_bytes" yaml:"activity_min_rx_bytes"` // Received
+	MinTxBytes int64 `json:"min_tx_bytes" yaml:"activity_min_tx_bytes"` // Sent
+	// Traffic at or below BackgroundMaxBytes only counts within
+	// BackgroundWindowMinutes of traffic above it, so the steady trickle of
+	// push notifications and cloud sync of an unused phone is ignored
+	BackgroundMaxBytes      int64 `json:"background_max_bytes" yaml:"background_max_bytes"`
+	BackgroundWindowMinutes int   `json:"background_window_minutes" yaml:"background_window_minutes"`
 }
 
 // DaySchedule defines time blocks for specific days
 type DaySchedule struct {
-	Days       []string    `json:"days"`        // ["monday","tuesday",...] or ["weekdays","weekends"]
-	TimeBlocks []TimeBlock `json:"time_blocks"`
+	Days       []string    `json:"days" yaml:"days"` // ["monday","tuesday",...] or ["weekdays","weekends"]
+	TimeBlocks []TimeBlock `json:"time_blocks" yaml:"time_blocks"`
 }
 
-// TimeBlock represents a time window with limits
+// TimeBlock represents a time window with limits. Times are zero-padded
+// "HH:MM" from 00:00 to 24:00. A block whose end time is not after its start
+// time runs past midnight into the next day, like 20:00-01:00; its usage
+// belongs to the day it starts on.
 type TimeBlock struct {
-	StartTime               string `json:"start_time"`                        // "HH:MM" format
-	EndTime                 string `json:"end_time"`                          // "HH:MM" format
-	LimitMinutes            *int   `json:"limit_minutes,omitempty"`           // nil = no time limit
-	LimitBytes              *int64 `json:"limit_bytes,omitempty"`             // nil = no data limit
-	WarningThresholdPercent int    `json:"warning_threshold_percent"`         // default 80
+	StartTime               string `json:"start_time" yaml:"start_time"`                               // "HH:MM" format
+	EndTime                 string `json:"end_time" yaml:"end_time"`                                   // "HH:MM" format
+	LimitMinutes            *int   `json:"limit_minutes,omitempty" yaml:"limit_minutes"`               // nil = no time limit
+	LimitBytes              *int64 `json:"limit_bytes,omitempty" yaml:"limit_bytes"`                   // nil = no data limit
+	WarningThresholdPercent int    `json:"warning_threshold_percent" yaml:"warning_threshold_percent"` // default 80
+	WarningMinutesLeft      int    `json:"warning_minutes_left,omitempty" yaml:"warning_minutes_left"` // 0 = no "minutes left" warning
+}
+
+// Schedule exception modes
+const (
+	// ExceptionSchedule swaps in alternate daily schedules
+	ExceptionSchedule = "schedule"
+	// ExceptionUnlimited lifts all limits, as if one time block without
+	// limits spanned every day
+	ExceptionUnlimited = "unlimited"
+	// ExceptionBlocked blocks the device for the whole day
+	ExceptionBlocked = "blocked"
+)
+
+// ExceptionModes lists all schedule exception modes
+var ExceptionModes = []string{ExceptionSchedule, ExceptionUnlimited, ExceptionBlocked}
+
+// ScheduleException replaces the weekly schedule on a range of dates, such as
+// school holidays, sick days or a vacation. It applies to one device, or to
+// all devices if MAC is empty.
+type ScheduleException struct {
+	ID             int64         `json:"id"`
+	MAC            string        `json:"mac"` // empty = all devices
+	Name           string        `json:"name"`
+	StartDate      string        `json:"start_date"`                // YYYY-MM-DD, inclusive
+	EndDate        string        `json:"end_date"`                  // YYYY-MM-DD, inclusive
+	Mode           string        `json:"mode"`                      // "schedule", "unlimited" or "blocked"
+	DailySchedules []DaySchedule `json:"daily_schedules,omitempty"` // Used in "schedule" mode
+	Source         string        `json:"source,omitempty"`          // Calendar it was imported from, empty if created through the API
+	CreatedBy      string        `json:"created_by"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// ExceptionFilter selects schedule exceptions. Zero fields match everything.
+type ExceptionFilter struct {
+	MAC  string // Exceptions of this device and global ones
+	Date string // Exceptions covering this date (YYYY-MM-DD)
 }
 
 // BlockUsage tracks usage for a specific time block on a specific day
@@ -40,7 +98,7 @@ type BlockUsage struct {
 	StartTime     string    `json:"start_time"`
 	EndTime       string    `json:"end_time"`
 	UsedBytes     int64     `json:"used_bytes"`
-	UsedMinutes   int       `json:"used_minutes"`
+	UsedSeconds   int       `json:"used_seconds"`   // Active time
 	LimitBytes    *int64    `json:"limit_bytes"`
 	LimitMinutes  *int      `json:"limit_minutes"`
 	IsBlocked     bool      `json:"is_blocked"`
@@ -49,9 +107,28 @@ type BlockUsage struct {
 	BonusBytes    int64     `json:"bonus_bytes"`
 	LastTxBytes   int64     `json:"last_tx_bytes"`  // For delta calculation
 	LastRxBytes   int64     `json:"last_rx_bytes"`
+	WarningsSent  int       `json:"warnings_sent"`  // Bitmask of warnings already emitted for this block
 	LastUpdated   time.Time `json:"last_updated"`
 }
 
+// UsedMinutes returns the active time of the block in whole minutes
+func (u *BlockUsage) UsedMinutes() int {
+	return u.UsedSeconds / 60
+}
+
+// Summary returns the usage of the block for reports
+func (u *BlockUsage) Summary() BlockSummary {
+	return BlockSummary{
+		StartTime:    u.StartTime,
+		EndTime:      u.EndTime,
+		UsedMinutes:  u.UsedMinutes(),
+		UsedSeconds:  u.UsedSeconds,
+		UsedBytes:    u.UsedBytes,
+		LimitMinutes: u.LimitMinutes,
+		LimitBytes:   u.LimitBytes,
+	}
+}
+
 // DeviceState tracks the current blocking state of a device
 type DeviceState struct {
 	MAC           string    `json:"mac"`
@@ -59,12 +136,195 @@ type DeviceState struct {
 	BlockedReason string    `json:"blocked_reason"`
 	BlockedAt     time.Time `json:"blocked_at,omitempty"`
 	UnblockedAt   time.Time `json:"unblocked_at,omitempty"`
+	ChangedBy     string    `json:"changed_by,omitempty"` // User who made the last transition, "system" for the enforcer
+}
+
+// DriftEvent records a mismatch between the desired blocking state of a
+// device and what the UniFi controller actually had blocked
+type DriftEvent struct {
+	ID            int64     `json:"id"`
+	MAC           string    `json:"mac"`
+	WantBlocked   bool      `json:"want_blocked"`
+	WasBlocked    bool      `json:"was_blocked"`
+	BlockedReason string    `json:"blocked_reason,omitempty"`
+	Corrected     bool      `json:"corrected"`
+	Error         string    `json:"error,omitempty"`
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+// Session is a server-side login session backing the web UI's tokens
+type Session struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	RefreshID string    `json:"-"` // ID of the only refresh token still valid
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+}
+
+// User is a web UI account. Role is one of "admin", "parent" or "viewer".
+// Accounts created by an OpenID Connect login are bound to the issuer and
+// subject of the identity that created them.
+type User struct {
+	ID           int64     `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	OIDCIssuer   string    `json:"oidc_issuer,omitempty"`
+	OIDCSubject  string    `json:"oidc_subject,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Override actions
+const (
+	OverrideBlock   = "block"
+	OverrideUnblock = "unblock"
+)
+
+// Override blocks or unblocks a device regardless of its schedule until it
+// expires. A device has at most one override.
+type Override struct {
+	MAC       string    `json:"mac"`
+	Action    string    `json:"action"` // "block" or "unblock"
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Active reports whether the override is still in effect at now
+func (o *Override) Active(now time.Time) bool {
+	return now.Before(o.ExpiresAt)
+}
+
+// Webhook is a subscription of an external URL to events
+type Webhook struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+	// Secret signs the payloads; it is only returned when the webhook is created
+	Secret      string    `json:"-"`
+	Events      []string  `json:"events"` // empty = all event types
+	Description string    `json:"description"`
+	Enabled     bool      `json:"enabled"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one payload sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID            int64     `json:"id"`
+	WebhookID     int64     `json:"webhook_id"`
+	EventType     string    `json:"event_type"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status"` // "pending", "succeeded" or "failed"
+	Attempts      int       `json:"attempts"`
+	ResponseCode  int       `json:"response_code,omitempty"` // HTTP status of the last attempt
+	Error         string    `json:"error,omitempty"`         // Error of the last attempt
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Event types recorded in the audit log
+const (
+	EventBlock         = "block"
+	EventUnblock       = "unblock"
+	EventBonusTime     = "bonus_time"
+	EventBonusData     = "bonus_data"
+	EventConfigSaved   = "config_saved"
+	EventConfigDeleted = "config_deleted"
+	EventWarning       = "warning"
+	EventOverride      = "override"
+
+	EventExceptionSaved   = "exception_saved"
+	EventExceptionDeleted = "exception_deleted"
+)
+
+// EventTypes lists all event types
+var EventTypes = []string{
+	EventBlock, EventUnblock, EventBonusTime, EventBonusData,
+	EventConfigSaved, EventConfigDeleted, EventWarning, EventOverride,
+	EventExceptionSaved, EventExceptionDeleted,
+}
+
+// Event is an audit log entry for an enforcement or admin action, with the
+// device's usage and effective limits in the active time block at that moment
+type Event struct {
+	ID           int64     `json:"id"`
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	MAC          string    `json:"mac"`
+	Actor        string    `json:"actor"`            // Username, or "system" for the enforcer
+	Reason       string    `json:"reason,omitempty"` // Block reason; the previous one for unblocks
+	Details      string    `json:"details,omitempty"`
+	UsedMinutes  int       `json:"used_minutes"`
+	UsedBytes    int64     `json:"used_bytes"`
+	LimitMinutes *int      `json:"limit_minutes,omitempty"`
+	LimitBytes   *int64    `json:"limit_bytes,omitempty"`
+}
+
+// EventFilter selects events. Zero fields match everything.
+type EventFilter struct {
+	MAC   string
+	Type  string
+	From  time.Time // Inclusive
+	To    time.Time // Exclusive
+	Limit int
+}
+
+// Traffic sample resolutions, finest first
+const (
+	// ResolutionMinute is a sample of the polls within a minute
+	ResolutionMinute = "minute"
+	// ResolutionHour is an hourly rollup of minute samples
+	ResolutionHour = "hour"
+	// ResolutionDay is a daily rollup of hourly samples
+	ResolutionDay = "day"
+)
+
+// Resolutions lists all traffic sample resolutions, finest first
+var Resolutions = []string{ResolutionMinute, ResolutionHour, ResolutionDay}
+
+// TrafficSample is the traffic of a device in a minute, hour or day
+type TrafficSample struct {
+	MAC           string    `json:"mac"`
+	Resolution    string    `json:"resolution"`
+	Start         time.Time `json:"start"`
+	Seconds       int       `json:"seconds"`        // Time covered by polls
+	ActiveSeconds int       `json:"active_seconds"` // Time counted as active
+	RxBytes       int64     `json:"rx_bytes"`
+	TxBytes       int64     `json:"tx_bytes"`
+}
+
+// Add adds the traffic of other to the sample
+func (s *TrafficSample) Add(other *TrafficSample) {
+	s.Seconds += other.Seconds
+	s.ActiveSeconds += other.ActiveSeconds
+	s.RxBytes += other.RxBytes
+	s.TxBytes += other.TxBytes
+}
+
+// TrafficFilter selects traffic samples. Zero fields match everything.
+type TrafficFilter struct {
+	MAC        string
+	Resolution string
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
 }
 
 // UsageSummary provides a summary of usage for a device
 type UsageSummary struct {
 	MAC              string          `json:"mac"`
 	Name             string          `json:"name"`
+	Date             string          `json:"date"` // Schedule day, the start date of an overnight block
 	CurrentBlock     *CurrentBlock   `json:"current_time_block,omitempty"`
 	TodayTotal       TodayTotal      `json:"today_total"`
 	AllBlocksToday   []BlockSummary  `json:"all_blocks_today"`
@@ -77,8 +337,10 @@ type CurrentBlock struct {
 	LimitMinutes     *int   `json:"limit_minutes,omitempty"`
 	LimitBytes       *int64 `json:"limit_bytes,omitempty"`
 	UsedMinutes      int    `json:"used_minutes"`
+	UsedSeconds      int    `json:"used_seconds"`
 	UsedBytes        int64  `json:"used_bytes"`
 	RemainingMinutes *int   `json:"remaining_minutes,omitempty"`
+	RemainingSeconds *int   `json:"remaining_seconds,omitempty"`
 	RemainingBytes   *int64 `json:"remaining_bytes,omitempty"`
 	IsBlocked        bool   `json:"is_blocked"`
 	BlockedReason    string `json:"blocked_reason,omitempty"`
@@ -89,6 +351,7 @@ type CurrentBlock struct {
 // TodayTotal summarizes total usage for the day
 type TodayTotal struct {
 	UsedMinutes int   `json:"used_minutes"`
+	UsedSeconds int   `json:"used_seconds"`
 	UsedBytes   int64 `json:"used_bytes"`
 }
 
@@ -97,6 +360,7 @@ type BlockSummary struct {
 	StartTime    string `json:"start"`
 	EndTime      string `json:"end"`
 	UsedMinutes  int    `json:"used_minutes"`
+	UsedSeconds  int    `json:"used_seconds"`
 	UsedBytes    int64  `json:"used_bytes"`
 	LimitMinutes *int   `json:"limit_minutes,omitempty"`
 	LimitBytes   *int64 `json:"limit_bytes,omitempty"`
@@ -108,6 +372,7 @@ type BlockSummary struct {
 type HistoryEntry struct {
 	Date         string         `json:"date"`
 	TotalMinutes int            `json:"total_minutes"`
+	TotalSeconds int            `json:"total_seconds"`
 	TotalBytes   int64          `json:"total_bytes"`
 	Blocks       []BlockSummary `json:"blocks"`
 }
@@ -124,6 +389,46 @@ type UniFiClient struct {
 	IsOnline  bool   `json:"is_online"`
 }
 
+// ParseClock parses a zero-padded "HH:MM" clock time from 00:00 to 24:00
+// into minutes after midnight
+func ParseClock(clock string) (int, error) {
+	if len(clock) != 5 || clock[2] != ':' {
+		return 0, fmt.Errorf("invalid time %q: want HH:MM", clock)
+	}
+	digits := clock[:2] + clock[3:]
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid time %q: want HH:MM", clock)
+		}
+	}
+
+	hour := int(digits[0]-'0')*10 + int(digits[1]-'0')
+	minute := int(digits[2]-'0')*10 + int(digits[3]-'0')
+	if minute > 59 || hour > 24 || hour == 24 && minute != 0 {
+		return 0, fmt.Errorf("invalid time %q: want 00:00 to 24:00", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+// ValidateSchedules checks the start and end times of every time block
+func ValidateSchedules(schedules []DaySchedule) error {
+	for _, schedule := range schedules {
+		for _, block := range schedule.TimeBlocks {
+			start, err := ParseClock(block.StartTime)
+			if err != nil {
+				return fmt.Errorf("start_time: %w", err)
+			}
+			if start == 24*60 {
+				return fmt.Errorf("start_time: invalid time %q: a block cannot start at 24:00", block.StartTime)
+			}
+			if _, err := ParseClock(block.EndTime); err != nil {
+				return fmt.Errorf("end_time: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 // MarshalSchedules converts schedules to JSON for storage
 func MarshalSchedules(schedules []DaySchedule) (string, error) {
 	data, err := json.Marshal(schedules)
@@ -142,6 +447,31 @@ func UnmarshalSchedules(data string) ([]DaySchedule, error) {
 	return schedules, nil
 }
 
+// MarshalActivity converts activity rules to JSON for storage, an empty
+// string for nil
+func MarshalActivity(rules *ActivityRules) (string, error) {
+	if rules == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// UnmarshalActivity parses activity rules from JSON storage
+func UnmarshalActivity(data string) (*ActivityRules, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var rules ActivityRules
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
 // ByteLimit helper for human-readable byte limits
 type ByteLimit struct {
 	Value int64  `json:"value"`
@@ -166,7 +496,7 @@ func (b ByteLimit) ToBytes() int64 {
 func FormatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
-		return string(rune(bytes)) + " B"
+		return fmt.Sprintf("%d B", bytes)
 	}
 	div, exp := int64(unit), 0
 	for n := bytes / unit; n >= unit; n /= unit {
@@ -174,5 +504,5 @@ func FormatBytes(bytes int64) string {
 		exp++
 	}
 	units := []string{"KB", "MB", "GB", "TB"}
-	return string(rune(bytes/div)) + " " + units[exp]
+	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
 }
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// overrideColumns lists the overrides table columns in scan order
+const overrideColumns = `mac, action, expires_at, created_by, created_at`
+
+// saveOverrideQuery returns the upsert statement for saveOverrideArgs
+func saveOverrideQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`INSERT INTO overrides (%s)
+		VALUES (%s)
+		ON CONFLICT(mac) DO UPDATE SET
+			action = excluded.action,
+			expires_at = excluded.expires_at,
+			created_by = excluded.created_by,
+			created_at = excluded.created_at`, overrideColumns, bindList(bind, 1, 5))
+}
+
+func saveOverrideArgs(override *Override) []interface{} {
+	return []interface{}{
+		override.MAC, override.Action, override.ExpiresAt.UTC(), override.CreatedBy, override.CreatedAt.UTC(),
+	}
+}
+
+// scanOverrides reads overrides selected with overrideColumns
+func scanOverrides(rows *sql.Rows) ([]*Override, error) {
+	defer rows.Close()
+
+	var overrides []*Override
+	for rows.Next() {
+		var override Override
+		if err := rows.Scan(
+			&override.MAC, &override.Action, &override.ExpiresAt, &override.CreatedBy, &override.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, &override)
+	}
+
+	return overrides, rows.Err()
+}
@@ -0,0 +1,844 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Postgres implements the storage interface using PostgreSQL
+type Postgres struct {
+	db *sql.DB
+}
+
+var _ Store = (*Postgres)(nil)
+
+// NewPostgres creates a new PostgreSQL storage instance and applies pending migrations
+func NewPostgres(dsn string) (*Postgres, error) {
+	db, err := openPostgresDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Postgres{db: db}
+	if err := s.migrator().Up(0); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return s, nil
+}
+
+// openPostgresDB connects to a PostgreSQL database
+func openPostgresDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return db, nil
+}
+
+// Close closes the database connection
+func (s *Postgres) Close() error {
+	return s.db.Close()
+}
+
+// migrator returns the schema migrator for this database
+func (s *Postgres) migrator() *Migrator {
+	return newMigrator(s.db, postgresMigrations, postgresBind)
+}
+
+// SaveDeviceConfig saves or updates a device configuration
+func (s *Postgres) SaveDeviceConfig(config *DeviceConfig) error {
+	schedules, err := MarshalSchedules(config.DailySchedules)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedules: %w", err)
+	}
+	activity, err := MarshalActivity(config.Activity)
+	if err != nil {
+		return fmt.Errorf("failed to marshal activity rules: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO device_configs (mac, name, enabled, block_outside, schedules, timezone, activity, updated_at, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8)
+		ON CONFLICT(mac) DO UPDATE SET
+			name = excluded.name,
+			enabled = excluded.enabled,
+			block_outside = excluded.block_outside,
+			schedules = excluded.schedules,
+			timezone = excluded.timezone,
+			activity = excluded.activity,
+			updated_at = NOW(),
+			updated_by = excluded.updated_by
+	`, config.MAC, config.Name, config.Enabled, config.BlockOutside, schedules, config.Timezone, activity, config.UpdatedBy)
+
+	return err
+}
+
+// GetDeviceConfig retrieves a device configuration by MAC
+func (s *Postgres) GetDeviceConfig(mac string) (*DeviceConfig, error) {
+	var config DeviceConfig
+	var schedules, activity string
+
+	err := s.db.QueryRow(`
+		SELECT mac, name, enabled, block_outside, schedules, timezone, activity, created_at, updated_at, updated_by
+		FROM device_configs WHERE mac = $1
+	`, mac).Scan(&config.MAC, &config.Name, &config.Enabled, &config.BlockOutside, &schedules, &config.Timezone, &activity, &config.CreatedAt, &config.UpdatedAt, &config.UpdatedBy)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.DailySchedules, err = UnmarshalSchedules(schedules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedules: %w", err)
+	}
+	config.Activity, err = UnmarshalActivity(activity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal activity rules: %w", err)
+	}
+
+	return &config, nil
+}
+
+// GetAllDeviceConfigs retrieves all device configurations
+func (s *Postgres) GetAllDeviceConfigs() ([]*DeviceConfig, error) {
+	rows, err := s.db.Query(`
+		SELECT mac, name, enabled, block_outside, schedules, timezone, activity, created_at, updated_at, updated_by
+		FROM device_configs ORDER BY mac
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []*DeviceConfig
+	for rows.Next() {
+		var config DeviceConfig
+		var schedules, activity string
+
+		if err := rows.Scan(&config.MAC, &config.Name, &config.Enabled, &config.BlockOutside, &schedules, &config.Timezone, &activity, &config.CreatedAt, &config.UpdatedAt, &config.UpdatedBy); err != nil {
+			return nil, err
+		}
+
+		config.DailySchedules, err = UnmarshalSchedules(schedules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedules: %w", err)
+		}
+		config.Activity, err = UnmarshalActivity(activity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal activity rules: %w", err)
+		}
+
+		configs = append(configs, &config)
+	}
+
+	return configs, rows.Err()
+}
+
+// DeleteDeviceConfig removes a device configuration
+func (s *Postgres) DeleteDeviceConfig(mac string) error {
+	_, err := s.db.Exec("DELETE FROM device_configs WHERE mac = $1", mac)
+	return err
+}
+
+// GetOrCreateBlockUsage gets or creates a usage record for a time block
+func (s *Postgres) GetOrCreateBlockUsage(mac, date string, blockIndex int, startTime, endTime string, limitMinutes *int, limitBytes *int64) (*BlockUsage, error) {
+	var usage BlockUsage
+
+	err := s.db.QueryRow(`
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
+			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
+		FROM block_usage WHERE mac = $1 AND date = $2 AND block_index = $3
+	`, mac, date, blockIndex).Scan(
+		&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
+		&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
+		&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
+		&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
+	)
+
+	if err == sql.ErrNoRows {
+		// Create new record
+		var id int64
+		err := s.db.QueryRow(`
+			INSERT INTO block_usage (mac, date, block_index, start_time, end_time, limit_minutes, limit_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, mac, date, blockIndex, startTime, endTime, limitMinutes, limitBytes).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		usage = BlockUsage{
+			ID:           id,
+			MAC:          mac,
+			Date:         date,
+			BlockIndex:   blockIndex,
+			StartTime:    startTime,
+			EndTime:      endTime,
+			LimitMinutes: limitMinutes,
+			LimitBytes:   limitBytes,
+			LastUpdated:  time.Now(),
+		}
+		return &usage, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// UpdateBlockUsage updates the block state and sent warnings of a usage record
+func (s *Postgres) UpdateBlockUsage(usage *BlockUsage) error {
+	_, err := s.db.Exec(`
+		UPDATE block_usage SET
+			is_blocked = $1, blocked_reason = $2, warnings_sent = $3, last_updated = NOW()
+		WHERE id = $4
+	`, usage.IsBlocked, usage.BlockedReason, usage.WarningsSent, usage.ID)
+	return err
+}
+
+// AddBlockUsage adds active seconds and bytes to a usage record
+func (s *Postgres) AddBlockUsage(id int64, seconds int, bytes, lastTxBytes, lastRxBytes int64) error {
+	_, err := s.db.Exec(`
+		UPDATE block_usage SET
+			used_seconds = used_seconds + $1, used_bytes = used_bytes + $2,
+			last_tx_bytes = $3, last_rx_bytes = $4, last_updated = NOW()
+		WHERE id = $5
+	`, seconds, bytes, lastTxBytes, lastRxBytes, id)
+	return err
+}
+
+// GetBlockUsageForDate retrieves all usage records for a device on a date
+func (s *Postgres) GetBlockUsageForDate(mac, date string) ([]*BlockUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
+			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
+		FROM block_usage WHERE mac = $1 AND date = $2 ORDER BY block_index
+	`, mac, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []*BlockUsage
+	for rows.Next() {
+		var usage BlockUsage
+		if err := rows.Scan(
+			&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
+			&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
+			&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
+			&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
+		); err != nil {
+			return nil, err
+		}
+		usages = append(usages, &usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// GetBlockUsageRange retrieves the usage records of a device from one date to
+// another, inclusive, ordered by date and block
+func (s *Postgres) GetBlockUsageRange(mac, from, to string) ([]*BlockUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
+			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
+		FROM block_usage WHERE mac = $1 AND date >= $2 AND date <= $3 ORDER BY date, block_index
+	`, mac, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []*BlockUsage
+	for rows.Next() {
+		var usage BlockUsage
+		if err := rows.Scan(
+			&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
+			&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
+			&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
+			&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
+		); err != nil {
+			return nil, err
+		}
+		usages = append(usages, &usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// GetUsageHistory retrieves historical usage for a device
+func (s *Postgres) GetUsageHistory(mac string, since string) ([]*HistoryEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT date, SUM(used_seconds) as total_seconds, SUM(used_bytes) as total_bytes
+		FROM block_usage
+		WHERE mac = $1 AND date >= $2
+		GROUP BY date
+		ORDER BY date DESC
+	`, mac, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(&entry.Date, &entry.TotalSeconds, &entry.TotalBytes); err != nil {
+			return nil, err
+		}
+		entry.TotalMinutes = entry.TotalSeconds / 60
+		history = append(history, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Get block details for each date
+	for _, entry := range history {
+		blocks, err := s.GetBlockUsageForDate(mac, entry.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range blocks {
+			entry.Blocks = append(entry.Blocks, b.Summary())
+		}
+	}
+
+	return history, nil
+}
+
+// SaveDeviceState saves the current blocking state of a device
+func (s *Postgres) SaveDeviceState(state *DeviceState) error {
+	_, err := s.db.Exec(`
+		INSERT INTO device_states (mac, is_blocked, blocked_reason, blocked_at, unblocked_at, changed_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT(mac) DO UPDATE SET
+			is_blocked = excluded.is_blocked,
+			blocked_reason = excluded.blocked_reason,
+			blocked_at = excluded.blocked_at,
+			unblocked_at = excluded.unblocked_at,
+			changed_by = excluded.changed_by
+	`, state.MAC, state.IsBlocked, state.BlockedReason, nullTime(state.BlockedAt), nullTime(state.UnblockedAt), state.ChangedBy)
+	return err
+}
+
+// GetDeviceState retrieves the current blocking state of a device
+func (s *Postgres) GetDeviceState(mac string) (*DeviceState, error) {
+	var state DeviceState
+	var blockedAt, unblockedAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT mac, is_blocked, blocked_reason, blocked_at, unblocked_at, changed_by
+		FROM device_states WHERE mac = $1
+	`, mac).Scan(&state.MAC, &state.IsBlocked, &state.BlockedReason, &blockedAt, &unblockedAt, &state.ChangedBy)
+
+	if err == sql.ErrNoRows {
+		return &DeviceState{MAC: mac}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if blockedAt.Valid {
+		state.BlockedAt = blockedAt.Time
+	}
+	if unblockedAt.Valid {
+		state.UnblockedAt = unblockedAt.Time
+	}
+
+	return &state, nil
+}
+
+// SaveOverride sets the override of a device, replacing any previous one
+func (s *Postgres) SaveOverride(override *Override) error {
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+	_, err := s.db.Exec(saveOverrideQuery(postgresBind), saveOverrideArgs(override)...)
+	return err
+}
+
+// GetOverride retrieves the override of a device, nil if there is none
+func (s *Postgres) GetOverride(mac string) (*Override, error) {
+	rows, err := s.db.Query("SELECT "+overrideColumns+" FROM overrides WHERE mac = $1", mac)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := scanOverrides(rows)
+	if err != nil || len(overrides) == 0 {
+		return nil, err
+	}
+	return overrides[0], nil
+}
+
+// ListOverrides retrieves all overrides ordered by expiry
+func (s *Postgres) ListOverrides() ([]*Override, error) {
+	rows, err := s.db.Query("SELECT " + overrideColumns + " FROM overrides ORDER BY expires_at, mac")
+	if err != nil {
+		return nil, err
+	}
+	return scanOverrides(rows)
+}
+
+// DeleteOverride removes the override of a device
+func (s *Postgres) DeleteOverride(mac string) error {
+	_, err := s.db.Exec("DELETE FROM overrides WHERE mac = $1", mac)
+	return err
+}
+
+// CreateScheduleException adds a schedule exception
+func (s *Postgres) CreateScheduleException(exception *ScheduleException) error {
+	now := time.Now()
+	exception.CreatedAt = now
+	exception.UpdatedAt = now
+
+	args, err := insertExceptionArgs(exception)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(insertExceptionQuery(postgresBind)+" RETURNING id", args...).Scan(&exception.ID)
+}
+
+// GetScheduleException retrieves a schedule exception, nil if unknown
+func (s *Postgres) GetScheduleException(id int64) (*ScheduleException, error) {
+	rows, err := s.db.Query("SELECT "+exceptionColumns+" FROM schedule_exceptions WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := scanExceptions(rows)
+	if err != nil || len(exceptions) == 0 {
+		return nil, err
+	}
+	return exceptions[0], nil
+}
+
+// ListScheduleExceptions retrieves the exceptions matching filter ordered by start date
+func (s *Postgres) ListScheduleExceptions(filter ExceptionFilter) ([]*ScheduleException, error) {
+	query, args := listExceptionsQuery(filter, postgresBind)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanExceptions(rows)
+}
+
+// UpdateScheduleException updates all fields of a schedule exception but its creation
+func (s *Postgres) UpdateScheduleException(exception *ScheduleException) error {
+	exception.UpdatedAt = time.Now()
+	args, err := updateExceptionArgs(exception)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(updateExceptionQuery(postgresBind), args...)
+	return err
+}
+
+// DeleteScheduleException removes a schedule exception
+func (s *Postgres) DeleteScheduleException(id int64) error {
+	_, err := s.db.Exec("DELETE FROM schedule_exceptions WHERE id = $1", id)
+	return err
+}
+
+// ReplaceScheduleExceptions atomically replaces the exceptions imported from
+// source with exceptions, setting their source
+func (s *Postgres) ReplaceScheduleExceptions(source string, exceptions []*ScheduleException) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM schedule_exceptions WHERE source = $1", source); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, exception := range exceptions {
+		exception.Source = source
+		exception.CreatedAt = now
+		exception.UpdatedAt = now
+		args, err := insertExceptionArgs(exception)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(insertExceptionQuery(postgresBind)+" RETURNING id", args...).Scan(&exception.ID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAllUsageForDate retrieves usage for all managed devices on a date
+func (s *Postgres) GetAllUsageForDate(date string) (map[string][]*BlockUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
+			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
+		FROM block_usage WHERE date = $1 ORDER BY mac, block_index
+	`, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := make(map[string][]*BlockUsage)
+	for rows.Next() {
+		var usage BlockUsage
+		if err := rows.Scan(
+			&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
+			&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
+			&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
+			&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
+		); err != nil {
+			return nil, err
+		}
+		usages[usage.MAC] = append(usages[usage.MAC], &usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// AddBonusTime adds bonus minutes to the current time block
+func (s *Postgres) AddBonusTime(mac string, date string, blockIndex int, minutes int) error {
+	_, err := s.db.Exec(`
+		UPDATE block_usage SET bonus_minutes = bonus_minutes + $1, last_updated = NOW()
+		WHERE mac = $2 AND date = $3 AND block_index = $4
+	`, minutes, mac, date, blockIndex)
+	return err
+}
+
+// AddBonusData adds bonus bytes to the current time block
+func (s *Postgres) AddBonusData(mac string, date string, blockIndex int, bytes int64) error {
+	_, err := s.db.Exec(`
+		UPDATE block_usage SET bonus_bytes = bonus_bytes + $1, last_updated = NOW()
+		WHERE mac = $2 AND date = $3 AND block_index = $4
+	`, bytes, mac, date, blockIndex)
+	return err
+}
+
+// SaveDriftEvent records a reconciliation drift event
+func (s *Postgres) SaveDriftEvent(event *DriftEvent) error {
+	return s.db.QueryRow(`
+		INSERT INTO drift_events (mac, want_blocked, was_blocked, blocked_reason, corrected, error, detected_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, event.MAC, event.WantBlocked, event.WasBlocked, event.BlockedReason, event.Corrected, event.Error, event.DetectedAt).Scan(&event.ID)
+}
+
+// GetDriftEvents retrieves the most recent drift events, optionally filtered by MAC
+func (s *Postgres) GetDriftEvents(mac string, limit int) ([]*DriftEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mac, want_blocked, was_blocked, blocked_reason, corrected, error, detected_at
+		FROM drift_events
+		WHERE $1 = '' OR mac = $1
+		ORDER BY detected_at DESC, id DESC
+		LIMIT $2
+	`, mac, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*DriftEvent
+	for rows.Next() {
+		var event DriftEvent
+		if err := rows.Scan(
+			&event.ID, &event.MAC, &event.WantBlocked, &event.WasBlocked, &event.BlockedReason,
+			&event.Corrected, &event.Error, &event.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// CreateSession stores a new login session
+func (s *Postgres) CreateSession(session *Session) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (id, username, refresh_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, session.ID, session.Username, session.RefreshID, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+// GetSession retrieves a session by ID
+func (s *Postgres) GetSession(id string) (*Session, error) {
+	var session Session
+	var revokedAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT id, username, refresh_id, created_at, expires_at, revoked_at
+		FROM sessions WHERE id = $1
+	`, id).Scan(&session.ID, &session.Username, &session.RefreshID, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = revokedAt.Time
+	}
+
+	return &session, nil
+}
+
+// UpdateSession updates the refresh ID and expiry of a session
+func (s *Postgres) UpdateSession(session *Session) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET refresh_id = $1, expires_at = $2 WHERE id = $3
+	`, session.RefreshID, session.ExpiresAt, session.ID)
+	return err
+}
+
+// RevokeSession marks a session as revoked
+func (s *Postgres) RevokeSession(id string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL
+	`, at, id)
+	return err
+}
+
+// RevokeUserSessions marks every session of a user as revoked
+func (s *Postgres) RevokeUserSessions(username string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = $1 WHERE username = $2 AND revoked_at IS NULL
+	`, at, username)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions that expired before a point in time
+func (s *Postgres) DeleteExpiredSessions(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < $1", before)
+	return err
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// CreateUser stores a new user, returning ErrUserExists if the username is taken
+func (s *Postgres) CreateUser(user *User) error {
+	now := time.Now()
+	err := s.db.QueryRow(`
+		INSERT INTO users (username, password_hash, role, oidc_issuer, oidc_subject, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT(username) DO NOTHING
+		RETURNING id
+	`, user.Username, user.PasswordHash, user.Role, user.OIDCIssuer, user.OIDCSubject, now, now).Scan(&user.ID)
+
+	if err == sql.ErrNoRows {
+		return ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return nil
+}
+
+// GetUser retrieves a user by username
+func (s *Postgres) GetUser(username string) (*User, error) {
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE username = $1", username)
+	if err != nil {
+		return nil, err
+	}
+	return firstUser(scanUsers(rows))
+}
+
+// GetUserByOIDC retrieves the user bound to an OpenID Connect identity
+func (s *Postgres) GetUserByOIDC(issuer, subject string) (*User, error) {
+	if subject == "" {
+		return nil, nil
+	}
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2", issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	return firstUser(scanUsers(rows))
+}
+
+// ListUsers retrieves all users ordered by username
+func (s *Postgres) ListUsers() ([]*User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+// UpdateUser updates the password hash, role and OIDC identity of a user
+func (s *Postgres) UpdateUser(user *User) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET password_hash = $1, role = $2, oidc_issuer = $3, oidc_subject = $4, updated_at = NOW()
+		WHERE username = $5
+	`, user.PasswordHash, user.Role, user.OIDCIssuer, user.OIDCSubject, user.Username)
+	return err
+}
+
+// DeleteUser removes a user
+func (s *Postgres) DeleteUser(username string) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE username = $1", username)
+	return err
+}
+
+// SaveEvent appends an event to the audit log
+func (s *Postgres) SaveEvent(event *Event) error {
+	return s.db.QueryRow(insertEventQuery(postgresBind)+" RETURNING id", insertEventArgs(event)...).Scan(&event.ID)
+}
+
+// ListEvents retrieves events matching filter, newest first
+func (s *Postgres) ListEvents(filter EventFilter) ([]*Event, error) {
+	query, args := listEventsQuery(filter, postgresBind)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// AddTrafficSample adds a sample to the one of its device, resolution and
+// start, creating it if there is none
+func (s *Postgres) AddTrafficSample(sample *TrafficSample) error {
+	_, err := s.db.Exec(addTrafficQuery(postgresBind), addTrafficArgs(sample)...)
+	return err
+}
+
+// ListTrafficSamples retrieves the samples matching filter ordered by start
+func (s *Postgres) ListTrafficSamples(filter TrafficFilter) ([]*TrafficSample, error) {
+	query, args := listTrafficQuery(filter, postgresBind)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTraffic(rows)
+}
+
+// RollupTrafficSamples atomically replaces the samples of a device and
+// resolution that start before a point in time with rollups
+func (s *Postgres) RollupTrafficSamples(mac, resolution string, before time.Time, rollups []*TrafficSample) error {
+	return rollupTraffic(s.db, postgresBind, mac, resolution, before, rollups)
+}
+
+// DeleteTrafficSamples removes the samples of a resolution that start before
+// a point in time
+func (s *Postgres) DeleteTrafficSamples(resolution string, before time.Time) error {
+	_, err := s.db.Exec(deleteTrafficQuery("", postgresBind), deleteTrafficArgs("", resolution, before)...)
+	return err
+}
+
+// CreateWebhook adds a webhook subscription
+func (s *Postgres) CreateWebhook(webhook *Webhook) error {
+	now := time.Now()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+
+	return s.db.QueryRow(insertWebhookQuery(postgresBind)+" RETURNING id", insertWebhookArgs(webhook)...).Scan(&webhook.ID)
+}
+
+// GetWebhook retrieves a webhook, nil if unknown
+func (s *Postgres) GetWebhook(id int64) (*Webhook, error) {
+	rows, err := s.db.Query("SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := scanWebhooks(rows)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	return webhooks[0], nil
+}
+
+// ListWebhooks retrieves all webhooks ordered by ID
+func (s *Postgres) ListWebhooks() ([]*Webhook, error) {
+	rows, err := s.db.Query("SELECT " + webhookColumns + " FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+// UpdateWebhook updates the URL, secret, events, description and enabled flag of a webhook
+func (s *Postgres) UpdateWebhook(webhook *Webhook) error {
+	webhook.UpdatedAt = time.Now()
+	_, err := s.db.Exec(updateWebhookQuery(postgresBind), updateWebhookArgs(webhook)...)
+	return err
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func (s *Postgres) DeleteWebhook(id int64) error {
+	if _, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = $1", id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM webhooks WHERE id = $1", id)
+	return err
+}
+
+// CreateWebhookDelivery queues a delivery
+func (s *Postgres) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	now := time.Now()
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+
+	return s.db.QueryRow(insertDeliveryQuery(postgresBind)+" RETURNING id", insertDeliveryArgs(delivery)...).Scan(&delivery.ID)
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (s *Postgres) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	delivery.UpdatedAt = time.Now()
+	_, err := s.db.Exec(updateDeliveryQuery(postgresBind), updateDeliveryArgs(delivery)...)
+	return err
+}
+
+// ListWebhookDeliveries retrieves the deliveries of a webhook, newest first
+func (s *Postgres) ListWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(listDeliveriesQuery(postgresBind), webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// ListDueWebhookDeliveries retrieves pending deliveries due at now, oldest first
+func (s *Postgres) ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(dueDeliveriesQuery(postgresBind), DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// DeleteWebhookDeliveries removes succeeded and failed deliveries last
+// attempted before a point in time
+func (s *Postgres) DeleteWebhookDeliveries(before time.Time) error {
+	_, err := s.db.Exec(deleteDeliveriesQuery(postgresBind), DeliveryPending, before.UTC())
+	return err
+}
@@ -13,76 +13,41 @@ type SQLite struct {
 	db *sql.DB
 }
 
-// NewSQLite creates a new SQLite storage instance
+var _ Store = (*SQLite)(nil)
+
+// NewSQLite creates a new SQLite storage instance and applies pending migrations
 func NewSQLite(path string) (*SQLite, error) {
-	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	db, err := openSQLiteDB(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
 	s := &SQLite{db: db}
-	if err := s.migrate(); err != nil {
+	if err := s.migrator().Up(0); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	return s, nil
 }
 
+// openSQLiteDB opens a SQLite database file
+func openSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
 // Close closes the database connection
 func (s *SQLite) Close() error {
 	return s.db.Close()
 }
 
-// migrate runs database migrations
-func (s *SQLite) migrate() error {
-	migrations := []string{
-		`CREATE TABLE IF NOT EXISTS device_configs (
-			mac TEXT PRIMARY KEY,
-			name TEXT NOT NULL DEFAULT '',
-			enabled BOOLEAN NOT NULL DEFAULT 1,
-			block_outside BOOLEAN NOT NULL DEFAULT 0,
-			schedules TEXT NOT NULL DEFAULT '[]',
-			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS block_usage (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			mac TEXT NOT NULL,
-			date TEXT NOT NULL,
-			block_index INTEGER NOT NULL,
-			start_time TEXT NOT NULL,
-			end_time TEXT NOT NULL,
-			used_bytes INTEGER NOT NULL DEFAULT 0,
-			used_minutes INTEGER NOT NULL DEFAULT 0,
-			limit_bytes INTEGER,
-			limit_minutes INTEGER,
-			is_blocked BOOLEAN NOT NULL DEFAULT 0,
-			blocked_reason TEXT NOT NULL DEFAULT '',
-			bonus_minutes INTEGER NOT NULL DEFAULT 0,
-			bonus_bytes INTEGER NOT NULL DEFAULT 0,
-			last_tx_bytes INTEGER NOT NULL DEFAULT 0,
-			last_rx_bytes INTEGER NOT NULL DEFAULT 0,
-			last_updated DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(mac, date, block_index)
-		)`,
-		`CREATE TABLE IF NOT EXISTS device_states (
-			mac TEXT PRIMARY KEY,
-			is_blocked BOOLEAN NOT NULL DEFAULT 0,
-			blocked_reason TEXT NOT NULL DEFAULT '',
-			blocked_at DATETIME,
-			unblocked_at DATETIME
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_block_usage_mac_date ON block_usage(mac, date)`,
-		`CREATE INDEX IF NOT EXISTS idx_block_usage_date ON block_usage(date)`,
-	}
-
-	for _, migration := range migrations {
-		if _, err := s.db.Exec(migration); err != nil {
-			return fmt.Errorf("migration failed: %w", err)
-		}
-	}
-
-	return nil
+// migrator returns the schema migrator for this database
+func (s *SQLite) migrator() *Migrator {
+	return newMigrator(s.db, sqliteMigrations, sqliteBind)
 }
 
 // SaveDeviceConfig saves or updates a device configuration
@@ -91,17 +56,24 @@ func (s *SQLite) SaveDeviceConfig(config *DeviceConfig) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal schedules: %w", err)
 	}
+	activity, err := MarshalActivity(config.Activity)
+	if err != nil {
+		return fmt.Errorf("failed to marshal activity rules: %w", err)
+	}
 
 	_, err = s.db.Exec(`
-		INSERT INTO device_configs (mac, name, enabled, block_outside, schedules, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO device_configs (mac, name, enabled, block_outside, schedules, timezone, activity, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
 		ON CONFLICT(mac) DO UPDATE SET
 			name = excluded.name,
 			enabled = excluded.enabled,
 			block_outside = excluded.block_outside,
 			schedules = excluded.schedules,
-			updated_at = CURRENT_TIMESTAMP
-	`, config.MAC, config.Name, config.Enabled, config.BlockOutside, schedules)
+			timezone = excluded.timezone,
+			activity = excluded.activity,
+			updated_at = CURRENT_TIMESTAMP,
+			updated_by = excluded.updated_by
+	`, config.MAC, config.Name, config.Enabled, config.BlockOutside, schedules, config.Timezone, activity, config.UpdatedBy)
 
 	return err
 }
@@ -109,12 +81,12 @@ func (s *SQLite) SaveDeviceConfig(config *DeviceConfig) error {
 // GetDeviceConfig retrieves a device configuration by MAC
 func (s *SQLite) GetDeviceConfig(mac string) (*DeviceConfig, error) {
 	var config DeviceConfig
-	var schedules string
+	var schedules, activity string
 
 	err := s.db.QueryRow(`
-		SELECT mac, name, enabled, block_outside, schedules, created_at, updated_at
+		SELECT mac, name, enabled, block_outside, schedules, timezone, activity, created_at, updated_at, updated_by
 		FROM device_configs WHERE mac = ?
-	`, mac).Scan(&config.MAC, &config.Name, &config.Enabled, &config.BlockOutside, &schedules, &config.CreatedAt, &config.UpdatedAt)
+	`, mac).Scan(&config.MAC, &config.Name, &config.Enabled, &config.BlockOutside, &schedules, &config.Timezone, &activity, &config.CreatedAt, &config.UpdatedAt, &config.UpdatedBy)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -127,6 +99,10 @@ func (s *SQLite) GetDeviceConfig(mac string) (*DeviceConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal schedules: %w", err)
 	}
+	config.Activity, err = UnmarshalActivity(activity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal activity rules: %w", err)
+	}
 
 	return &config, nil
 }
@@ -134,7 +110,7 @@ func (s *SQLite) GetDeviceConfig(mac string) (*DeviceConfig, error) {
 // GetAllDeviceConfigs retrieves all device configurations
 func (s *SQLite) GetAllDeviceConfigs() ([]*DeviceConfig, error) {
 	rows, err := s.db.Query(`
-		SELECT mac, name, enabled, block_outside, schedules, created_at, updated_at
+		SELECT mac, name, enabled, block_outside, schedules, timezone, activity, created_at, updated_at, updated_by
 		FROM device_configs
 	`)
 	if err != nil {
@@ -145,9 +121,9 @@ func (s *SQLite) GetAllDeviceConfigs() ([]*DeviceConfig, error) {
 	var configs []*DeviceConfig
 	for rows.Next() {
 		var config DeviceConfig
-		var schedules string
+		var schedules, activity string
 
-		if err := rows.Scan(&config.MAC, &config.Name, &config.Enabled, &config.BlockOutside, &schedules, &config.CreatedAt, &config.UpdatedAt); err != nil {
+		if err := rows.Scan(&config.MAC, &config.Name, &config.Enabled, &config.BlockOutside, &schedules, &config.Timezone, &activity, &config.CreatedAt, &config.UpdatedAt, &config.UpdatedBy); err != nil {
 			return nil, err
 		}
 
@@ -155,6 +131,10 @@ func (s *SQLite) GetAllDeviceConfigs() ([]*DeviceConfig, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal schedules: %w", err)
 		}
+		config.Activity, err = UnmarshalActivity(activity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal activity rules: %w", err)
+		}
 
 		configs = append(configs, &config)
 	}
@@ -173,15 +153,15 @@ func (s *SQLite) GetOrCreateBlockUsage(mac, date string, blockIndex int, startTi
 	var usage BlockUsage
 
 	err := s.db.QueryRow(`
-		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_minutes,
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
 			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
-			   last_tx_bytes, last_rx_bytes, last_updated
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
 		FROM block_usage WHERE mac = ? AND date = ? AND block_index = ?
 	`, mac, date, blockIndex).Scan(
 		&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
-		&usage.UsedBytes, &usage.UsedMinutes, &usage.LimitBytes, &usage.LimitMinutes,
+		&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
 		&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
-		&usage.LastTxBytes, &usage.LastRxBytes, &usage.LastUpdated,
+		&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
 	)
 
 	if err == sql.ErrNoRows {
@@ -216,25 +196,33 @@ func (s *SQLite) GetOrCreateBlockUsage(mac, date string, blockIndex int, startTi
 	return &usage, nil
 }
 
-// UpdateBlockUsage updates a usage record
+// UpdateBlockUsage updates the block state and sent warnings of a usage record
 func (s *SQLite) UpdateBlockUsage(usage *BlockUsage) error {
 	_, err := s.db.Exec(`
 		UPDATE block_usage SET
-			used_bytes = ?, used_minutes = ?, is_blocked = ?, blocked_reason = ?,
-			bonus_minutes = ?, bonus_bytes = ?, last_tx_bytes = ?, last_rx_bytes = ?,
-			last_updated = CURRENT_TIMESTAMP
+			is_blocked = ?, blocked_reason = ?, warnings_sent = ?, last_updated = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, usage.IsBlocked, usage.BlockedReason, usage.WarningsSent, usage.ID)
+	return err
+}
+
+// AddBlockUsage adds active seconds and bytes to a usage record
+func (s *SQLite) AddBlockUsage(id int64, seconds int, bytes, lastTxBytes, lastRxBytes int64) error {
+	_, err := s.db.Exec(`
+		UPDATE block_usage SET
+			used_seconds = used_seconds + ?, used_bytes = used_bytes + ?,
+			last_tx_bytes = ?, last_rx_bytes = ?, last_updated = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, usage.UsedBytes, usage.UsedMinutes, usage.IsBlocked, usage.BlockedReason,
-		usage.BonusMinutes, usage.BonusBytes, usage.LastTxBytes, usage.LastRxBytes, usage.ID)
+	`, seconds, bytes, lastTxBytes, lastRxBytes, id)
 	return err
 }
 
 // GetBlockUsageForDate retrieves all usage records for a device on a date
 func (s *SQLite) GetBlockUsageForDate(mac, date string) ([]*BlockUsage, error) {
 	rows, err := s.db.Query(`
-		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_minutes,
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
 			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
-			   last_tx_bytes, last_rx_bytes, last_updated
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
 		FROM block_usage WHERE mac = ? AND date = ? ORDER BY block_index
 	`, mac, date)
 	if err != nil {
@@ -247,9 +235,40 @@ func (s *SQLite) GetBlockUsageForDate(mac, date string) ([]*BlockUsage, error) {
 		var usage BlockUsage
 		if err := rows.Scan(
 			&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
-			&usage.UsedBytes, &usage.UsedMinutes, &usage.LimitBytes, &usage.LimitMinutes,
+			&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
 			&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
-			&usage.LastTxBytes, &usage.LastRxBytes, &usage.LastUpdated,
+			&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
+		); err != nil {
+			return nil, err
+		}
+		usages = append(usages, &usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// GetBlockUsageRange retrieves the usage records of a device from one date to
+// another, inclusive, ordered by date and block
+func (s *SQLite) GetBlockUsageRange(mac, from, to string) ([]*BlockUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
+			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
+		FROM block_usage WHERE mac = ? AND date >= ? AND date <= ? ORDER BY date, block_index
+	`, mac, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []*BlockUsage
+	for rows.Next() {
+		var usage BlockUsage
+		if err := rows.Scan(
+			&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
+			&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
+			&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
+			&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
 		); err != nil {
 			return nil, err
 		}
@@ -260,14 +279,14 @@ func (s *SQLite) GetBlockUsageForDate(mac, date string) ([]*BlockUsage, error) {
 }
 
 // GetUsageHistory retrieves historical usage for a device
-func (s *SQLite) GetUsageHistory(mac string, days int) ([]*HistoryEntry, error) {
+func (s *SQLite) GetUsageHistory(mac string, since string) ([]*HistoryEntry, error) {
 	rows, err := s.db.Query(`
-		SELECT date, SUM(used_minutes) as total_minutes, SUM(used_bytes) as total_bytes
+		SELECT date, SUM(used_seconds) as total_seconds, SUM(used_bytes) as total_bytes
 		FROM block_usage
-		WHERE mac = ? AND date >= date('now', '-' || ? || ' days')
+		WHERE mac = ? AND date >= ?
 		GROUP BY date
 		ORDER BY date DESC
-	`, mac, days)
+	`, mac, since)
 	if err != nil {
 		return nil, err
 	}
@@ -276,9 +295,10 @@ func (s *SQLite) GetUsageHistory(mac string, days int) ([]*HistoryEntry, error)
 	var history []*HistoryEntry
 	for rows.Next() {
 		var entry HistoryEntry
-		if err := rows.Scan(&entry.Date, &entry.TotalMinutes, &entry.TotalBytes); err != nil {
+		if err := rows.Scan(&entry.Date, &entry.TotalSeconds, &entry.TotalBytes); err != nil {
 			return nil, err
 		}
+		entry.TotalMinutes = entry.TotalSeconds / 60
 
 		// Get block details for this date
 		blocks, err := s.GetBlockUsageForDate(mac, entry.Date)
@@ -287,14 +307,7 @@ func (s *SQLite) GetUsageHistory(mac string, days int) ([]*HistoryEntry, error)
 		}
 
 		for _, b := range blocks {
-			entry.Blocks = append(entry.Blocks, BlockSummary{
-				StartTime:    b.StartTime,
-				EndTime:      b.EndTime,
-				UsedMinutes:  b.UsedMinutes,
-				UsedBytes:    b.UsedBytes,
-				LimitMinutes: b.LimitMinutes,
-				LimitBytes:   b.LimitBytes,
-			})
+			entry.Blocks = append(entry.Blocks, b.Summary())
 		}
 
 		history = append(history, &entry)
@@ -306,14 +319,15 @@ func (s *SQLite) GetUsageHistory(mac string, days int) ([]*HistoryEntry, error)
 // SaveDeviceState saves the current blocking state of a device
 func (s *SQLite) SaveDeviceState(state *DeviceState) error {
 	_, err := s.db.Exec(`
-		INSERT INTO device_states (mac, is_blocked, blocked_reason, blocked_at, unblocked_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO device_states (mac, is_blocked, blocked_reason, blocked_at, unblocked_at, changed_by)
+		VALUES (?, ?, ?, ?, ?, ?)
 		ON CONFLICT(mac) DO UPDATE SET
 			is_blocked = excluded.is_blocked,
 			blocked_reason = excluded.blocked_reason,
 			blocked_at = excluded.blocked_at,
-			unblocked_at = excluded.unblocked_at
-	`, state.MAC, state.IsBlocked, state.BlockedReason, state.BlockedAt, state.UnblockedAt)
+			unblocked_at = excluded.unblocked_at,
+			changed_by = excluded.changed_by
+	`, state.MAC, state.IsBlocked, state.BlockedReason, state.BlockedAt, state.UnblockedAt, state.ChangedBy)
 	return err
 }
 
@@ -323,9 +337,9 @@ func (s *SQLite) GetDeviceState(mac string) (*DeviceState, error) {
 	var blockedAt, unblockedAt sql.NullTime
 
 	err := s.db.QueryRow(`
-		SELECT mac, is_blocked, blocked_reason, blocked_at, unblocked_at
+		SELECT mac, is_blocked, blocked_reason, blocked_at, unblocked_at, changed_by
 		FROM device_states WHERE mac = ?
-	`, mac).Scan(&state.MAC, &state.IsBlocked, &state.BlockedReason, &blockedAt, &unblockedAt)
+	`, mac).Scan(&state.MAC, &state.IsBlocked, &state.BlockedReason, &blockedAt, &unblockedAt, &state.ChangedBy)
 
 	if err == sql.ErrNoRows {
 		return &DeviceState{MAC: mac}, nil
@@ -344,12 +358,141 @@ func (s *SQLite) GetDeviceState(mac string) (*DeviceState, error) {
 	return &state, nil
 }
 
+// SaveOverride sets the override of a device, replacing any previous one
+func (s *SQLite) SaveOverride(override *Override) error {
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+	_, err := s.db.Exec(saveOverrideQuery(sqliteBind), saveOverrideArgs(override)...)
+	return err
+}
+
+// GetOverride retrieves the override of a device, nil if there is none
+func (s *SQLite) GetOverride(mac string) (*Override, error) {
+	rows, err := s.db.Query("SELECT "+overrideColumns+" FROM overrides WHERE mac = ?", mac)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := scanOverrides(rows)
+	if err != nil || len(overrides) == 0 {
+		return nil, err
+	}
+	return overrides[0], nil
+}
+
+// ListOverrides retrieves all overrides ordered by expiry
+func (s *SQLite) ListOverrides() ([]*Override, error) {
+	rows, err := s.db.Query("SELECT " + overrideColumns + " FROM overrides ORDER BY expires_at, mac")
+	if err != nil {
+		return nil, err
+	}
+	return scanOverrides(rows)
+}
+
+// DeleteOverride removes the override of a device
+func (s *SQLite) DeleteOverride(mac string) error {
+	_, err := s.db.Exec("DELETE FROM overrides WHERE mac = ?", mac)
+	return err
+}
+
+// CreateScheduleException adds a schedule exception
+func (s *SQLite) CreateScheduleException(exception *ScheduleException) error {
+	now := time.Now()
+	exception.CreatedAt = now
+	exception.UpdatedAt = now
+
+	args, err := insertExceptionArgs(exception)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Exec(insertExceptionQuery(sqliteBind), args...)
+	if err != nil {
+		return err
+	}
+	exception.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetScheduleException retrieves a schedule exception, nil if unknown
+func (s *SQLite) GetScheduleException(id int64) (*ScheduleException, error) {
+	rows, err := s.db.Query("SELECT "+exceptionColumns+" FROM schedule_exceptions WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := scanExceptions(rows)
+	if err != nil || len(exceptions) == 0 {
+		return nil, err
+	}
+	return exceptions[0], nil
+}
+
+// ListScheduleExceptions retrieves the exceptions matching filter ordered by start date
+func (s *SQLite) ListScheduleExceptions(filter ExceptionFilter) ([]*ScheduleException, error) {
+	query, args := listExceptionsQuery(filter, sqliteBind)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanExceptions(rows)
+}
+
+// UpdateScheduleException updates all fields of a schedule exception but its creation
+func (s *SQLite) UpdateScheduleException(exception *ScheduleException) error {
+	exception.UpdatedAt = time.Now()
+	args, err := updateExceptionArgs(exception)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(updateExceptionQuery(sqliteBind), args...)
+	return err
+}
+
+// DeleteScheduleException removes a schedule exception
+func (s *SQLite) DeleteScheduleException(id int64) error {
+	_, err := s.db.Exec("DELETE FROM schedule_exceptions WHERE id = ?", id)
+	return err
+}
+
+// ReplaceScheduleExceptions atomically replaces the exceptions imported from
+// source with exceptions, setting their source
+func (s *SQLite) ReplaceScheduleExceptions(source string, exceptions []*ScheduleException) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM schedule_exceptions WHERE source = ?", source); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, exception := range exceptions {
+		exception.Source = source
+		exception.CreatedAt = now
+		exception.UpdatedAt = now
+		args, err := insertExceptionArgs(exception)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(insertExceptionQuery(sqliteBind), args...)
+		if err != nil {
+			return err
+		}
+		if exception.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // GetAllUsageForDate retrieves usage for all managed devices on a date
 func (s *SQLite) GetAllUsageForDate(date string) (map[string][]*BlockUsage, error) {
 	rows, err := s.db.Query(`
-		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_minutes,
+		SELECT id, mac, date, block_index, start_time, end_time, used_bytes, used_seconds,
 			   limit_bytes, limit_minutes, is_blocked, blocked_reason, bonus_minutes, bonus_bytes,
-			   last_tx_bytes, last_rx_bytes, last_updated
+			   last_tx_bytes, last_rx_bytes, warnings_sent, last_updated
 		FROM block_usage WHERE date = ? ORDER BY mac, block_index
 	`, date)
 	if err != nil {
@@ -362,9 +505,9 @@ func (s *SQLite) GetAllUsageForDate(date string) (map[string][]*BlockUsage, erro
 		var usage BlockUsage
 		if err := rows.Scan(
 			&usage.ID, &usage.MAC, &usage.Date, &usage.BlockIndex, &usage.StartTime, &usage.EndTime,
-			&usage.UsedBytes, &usage.UsedMinutes, &usage.LimitBytes, &usage.LimitMinutes,
+			&usage.UsedBytes, &usage.UsedSeconds, &usage.LimitBytes, &usage.LimitMinutes,
 			&usage.IsBlocked, &usage.BlockedReason, &usage.BonusMinutes, &usage.BonusBytes,
-			&usage.LastTxBytes, &usage.LastRxBytes, &usage.LastUpdated,
+			&usage.LastTxBytes, &usage.LastRxBytes, &usage.WarningsSent, &usage.LastUpdated,
 		); err != nil {
 			return nil, err
 		}
@@ -391,3 +534,326 @@ func (s *SQLite) AddBonusData(mac string, date string, blockIndex int, bytes int
 	`, bytes, mac, date, blockIndex)
 	return err
 }
+
+// SaveDriftEvent records a reconciliation drift event
+func (s *SQLite) SaveDriftEvent(event *DriftEvent) error {
+	result, err := s.db.Exec(`
+		INSERT INTO drift_events (mac, want_blocked, was_blocked, blocked_reason, corrected, error, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, event.MAC, event.WantBlocked, event.WasBlocked, event.BlockedReason, event.Corrected, event.Error, event.DetectedAt)
+	if err != nil {
+		return err
+	}
+
+	event.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetDriftEvents retrieves the most recent drift events, optionally filtered by MAC
+func (s *SQLite) GetDriftEvents(mac string, limit int) ([]*DriftEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mac, want_blocked, was_blocked, blocked_reason, corrected, error, detected_at
+		FROM drift_events
+		WHERE ? = '' OR mac = ?
+		ORDER BY detected_at DESC, id DESC
+		LIMIT ?
+	`, mac, mac, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*DriftEvent
+	for rows.Next() {
+		var event DriftEvent
+		if err := rows.Scan(
+			&event.ID, &event.MAC, &event.WantBlocked, &event.WasBlocked, &event.BlockedReason,
+			&event.Corrected, &event.Error, &event.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// CreateSession stores a new login session
+func (s *SQLite) CreateSession(session *Session) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (id, username, refresh_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, session.ID, session.Username, session.RefreshID, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+// GetSession retrieves a session by ID
+func (s *SQLite) GetSession(id string) (*Session, error) {
+	var session Session
+	var revokedAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT id, username, refresh_id, created_at, expires_at, revoked_at
+		FROM sessions WHERE id = ?
+	`, id).Scan(&session.ID, &session.Username, &session.RefreshID, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = revokedAt.Time
+	}
+
+	return &session, nil
+}
+
+// UpdateSession updates the refresh ID and expiry of a session
+func (s *SQLite) UpdateSession(session *Session) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET refresh_id = ?, expires_at = ? WHERE id = ?
+	`, session.RefreshID, session.ExpiresAt, session.ID)
+	return err
+}
+
+// RevokeSession marks a session as revoked
+func (s *SQLite) RevokeSession(id string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL
+	`, at, id)
+	return err
+}
+
+// RevokeUserSessions marks every session of a user as revoked
+func (s *SQLite) RevokeUserSessions(username string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE username = ? AND revoked_at IS NULL
+	`, at, username)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions that expired before a point in time
+func (s *SQLite) DeleteExpiredSessions(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ?", before)
+	return err
+}
+
+// CreateUser stores a new user, returning ErrUserExists if the username is taken
+func (s *SQLite) CreateUser(user *User) error {
+	now := time.Now()
+	result, err := s.db.Exec(`
+		INSERT INTO users (username, password_hash, role, oidc_issuer, oidc_subject, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(username) DO NOTHING
+	`, user.Username, user.PasswordHash, user.Role, user.OIDCIssuer, user.OIDCSubject, now, now)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserExists
+	}
+
+	user.ID, err = result.LastInsertId()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return err
+}
+
+// GetUser retrieves a user by username
+func (s *SQLite) GetUser(username string) (*User, error) {
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE username = ?", username)
+	if err != nil {
+		return nil, err
+	}
+	return firstUser(scanUsers(rows))
+}
+
+// GetUserByOIDC retrieves the user bound to an OpenID Connect identity
+func (s *SQLite) GetUserByOIDC(issuer, subject string) (*User, error) {
+	if subject == "" {
+		return nil, nil
+	}
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM users WHERE oidc_issuer = ? AND oidc_subject = ?", issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	return firstUser(scanUsers(rows))
+}
+
+// ListUsers retrieves all users ordered by username
+func (s *SQLite) ListUsers() ([]*User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+// UpdateUser updates the password hash, role and OIDC identity of a user
+func (s *SQLite) UpdateUser(user *User) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET password_hash = ?, role = ?, oidc_issuer = ?, oidc_subject = ?, updated_at = ?
+		WHERE username = ?
+	`, user.PasswordHash, user.Role, user.OIDCIssuer, user.OIDCSubject, time.Now(), user.Username)
+	return err
+}
+
+// DeleteUser removes a user
+func (s *SQLite) DeleteUser(username string) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE username = ?", username)
+	return err
+}
+
+// SaveEvent appends an event to the audit log
+func (s *SQLite) SaveEvent(event *Event) error {
+	result, err := s.db.Exec(insertEventQuery(sqliteBind), insertEventArgs(event)...)
+	if err != nil {
+		return err
+	}
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+// ListEvents retrieves events matching filter, newest first
+func (s *SQLite) ListEvents(filter EventFilter) ([]*Event, error) {
+	query, args := listEventsQuery(filter, sqliteBind)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// AddTrafficSample adds a sample to the one of its device, resolution and
+// start, creating it if there is none
+func (s *SQLite) AddTrafficSample(sample *TrafficSample) error {
+	_, err := s.db.Exec(addTrafficQuery(sqliteBind), addTrafficArgs(sample)...)
+	return err
+}
+
+// ListTrafficSamples retrieves the samples matching filter ordered by start
+func (s *SQLite) ListTrafficSamples(filter TrafficFilter) ([]*TrafficSample, error) {
+	query, args := listTrafficQuery(filter, sqliteBind)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTraffic(rows)
+}
+
+// RollupTrafficSamples atomically replaces the samples of a device and
+// resolution that start before a point in time with rollups
+func (s *SQLite) RollupTrafficSamples(mac, resolution string, before time.Time, rollups []*TrafficSample) error {
+	return rollupTraffic(s.db, sqliteBind, mac, resolution, before, rollups)
+}
+
+// DeleteTrafficSamples removes the samples of a resolution that start before
+// a point in time
+func (s *SQLite) DeleteTrafficSamples(resolution string, before time.Time) error {
+	_, err := s.db.Exec(deleteTrafficQuery("", sqliteBind), deleteTrafficArgs("", resolution, before)...)
+	return err
+}
+
+// CreateWebhook adds a webhook subscription
+func (s *SQLite) CreateWebhook(webhook *Webhook) error {
+	now := time.Now()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+
+	result, err := s.db.Exec(insertWebhookQuery(sqliteBind), insertWebhookArgs(webhook)...)
+	if err != nil {
+		return err
+	}
+	webhook.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetWebhook retrieves a webhook, nil if unknown
+func (s *SQLite) GetWebhook(id int64) (*Webhook, error) {
+	rows, err := s.db.Query("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := scanWebhooks(rows)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	return webhooks[0], nil
+}
+
+// ListWebhooks retrieves all webhooks ordered by ID
+func (s *SQLite) ListWebhooks() ([]*Webhook, error) {
+	rows, err := s.db.Query("SELECT " + webhookColumns + " FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+// UpdateWebhook updates the URL, secret, events, description and enabled flag of a webhook
+func (s *SQLite) UpdateWebhook(webhook *Webhook) error {
+	webhook.UpdatedAt = time.Now()
+	_, err := s.db.Exec(updateWebhookQuery(sqliteBind), updateWebhookArgs(webhook)...)
+	return err
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func (s *SQLite) DeleteWebhook(id int64) error {
+	if _, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	return err
+}
+
+// CreateWebhookDelivery queues a delivery
+func (s *SQLite) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	now := time.Now()
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+
+	result, err := s.db.Exec(insertDeliveryQuery(sqliteBind), insertDeliveryArgs(delivery)...)
+	if err != nil {
+		return err
+	}
+	delivery.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (s *SQLite) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	delivery.UpdatedAt = time.Now()
+	_, err := s.db.Exec(updateDeliveryQuery(sqliteBind), updateDeliveryArgs(delivery)...)
+	return err
+}
+
+// ListWebhookDeliveries retrieves the deliveries of a webhook, newest first
+func (s *SQLite) ListWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(listDeliveriesQuery(sqliteBind), webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// ListDueWebhookDeliveries retrieves pending deliveries due at now, oldest first
+func (s *SQLite) ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(dueDeliveriesQuery(sqliteBind), DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// DeleteWebhookDeliveries removes succeeded and failed deliveries last
+// attempted before a point in time
+func (s *SQLite) DeleteWebhookDeliveries(before time.Time) error {
+	_, err := s.db.Exec(deleteDeliveriesQuery(sqliteBind), DeliveryPending, before.UTC())
+	return err
+}
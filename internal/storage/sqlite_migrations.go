@@ -0,0 +1,300 @@
+package storage
+
+// sqliteMigrations are the versioned schema migrations for SQLite. Never edit
+// a released migration; add a new one instead.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS device_configs (
+				mac TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				enabled BOOLEAN NOT NULL DEFAULT 1,
+				block_outside BOOLEAN NOT NULL DEFAULT 0,
+				schedules TEXT NOT NULL DEFAULT '[]',
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS block_usage (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				mac TEXT NOT NULL,
+				date TEXT NOT NULL,
+				block_index INTEGER NOT NULL,
+				start_time TEXT NOT NULL,
+				end_time TEXT NOT NULL,
+				used_bytes INTEGER NOT NULL DEFAULT 0,
+				used_minutes INTEGER NOT NULL DEFAULT 0,
+				limit_bytes INTEGER,
+				limit_minutes INTEGER,
+				is_blocked BOOLEAN NOT NULL DEFAULT 0,
+				blocked_reason TEXT NOT NULL DEFAULT '',
+				bonus_minutes INTEGER NOT NULL DEFAULT 0,
+				bonus_bytes INTEGER NOT NULL DEFAULT 0,
+				last_tx_bytes INTEGER NOT NULL DEFAULT 0,
+				last_rx_bytes INTEGER NOT NULL DEFAULT 0,
+				last_updated DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(mac, date, block_index)
+			)`,
+			`CREATE TABLE IF NOT EXISTS device_states (
+				mac TEXT PRIMARY KEY,
+				is_blocked BOOLEAN NOT NULL DEFAULT 0,
+				blocked_reason TEXT NOT NULL DEFAULT '',
+				blocked_at DATETIME,
+				unblocked_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_block_usage_mac_date ON block_usage(mac, date)`,
+			`CREATE INDEX IF NOT EXISTS idx_block_usage_date ON block_usage(date)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS device_states`,
+			`DROP TABLE IF EXISTS block_usage`,
+			`DROP TABLE IF EXISTS device_configs`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "drift events",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS drift_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				mac TEXT NOT NULL,
+				want_blocked BOOLEAN NOT NULL,
+				was_blocked BOOLEAN NOT NULL,
+				blocked_reason TEXT NOT NULL DEFAULT '',
+				corrected BOOLEAN NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				detected_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_drift_events_detected_at ON drift_events(detected_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS drift_events`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "sessions",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				username TEXT NOT NULL,
+				refresh_id TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				revoked_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS sessions`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "users",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL DEFAULT '',
+				role TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`ALTER TABLE device_configs ADD COLUMN updated_by TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE device_states ADD COLUMN changed_by TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE device_states DROP COLUMN changed_by`,
+			`ALTER TABLE device_configs DROP COLUMN updated_by`,
+			`DROP TABLE IF EXISTS users`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "oidc identities",
+		Up: []string{
+			`ALTER TABLE users ADD COLUMN oidc_issuer TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN oidc_subject TEXT NOT NULL DEFAULT ''`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc ON users(oidc_issuer, oidc_subject) WHERE oidc_subject != ''`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS idx_users_oidc`,
+			`ALTER TABLE users DROP COLUMN oidc_subject`,
+			`ALTER TABLE users DROP COLUMN oidc_issuer`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "events",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				created_at DATETIME NOT NULL,
+				type TEXT NOT NULL,
+				mac TEXT NOT NULL DEFAULT '',
+				actor TEXT NOT NULL DEFAULT '',
+				reason TEXT NOT NULL DEFAULT '',
+				details TEXT NOT NULL DEFAULT '',
+				used_minutes INTEGER NOT NULL DEFAULT 0,
+				used_bytes INTEGER NOT NULL DEFAULT 0,
+				limit_minutes INTEGER,
+				limit_bytes INTEGER
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_events_mac_created_at ON events(mac, created_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS events`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "usage warnings",
+		Up: []string{
+			`ALTER TABLE block_usage ADD COLUMN warnings_sent INTEGER NOT NULL DEFAULT 0`,
+		},
+		Down: []string{
+			`ALTER TABLE block_usage DROP COLUMN warnings_sent`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "webhooks",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS webhooks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				events TEXT NOT NULL DEFAULT '',
+				description TEXT NOT NULL DEFAULT '',
+				enabled BOOLEAN NOT NULL DEFAULT 1,
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				webhook_id INTEGER NOT NULL,
+				event_type TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				response_code INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				next_attempt_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS webhook_deliveries`,
+			`DROP TABLE IF EXISTS webhooks`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "overrides",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS overrides (
+				mac TEXT PRIMARY KEY,
+				action TEXT NOT NULL,
+				expires_at DATETIME NOT NULL,
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS overrides`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "schedule exceptions",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS schedule_exceptions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				mac TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL DEFAULT '',
+				start_date TEXT NOT NULL,
+				end_date TEXT NOT NULL,
+				mode TEXT NOT NULL,
+				schedules TEXT NOT NULL DEFAULT '[]',
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_schedule_exceptions_dates ON schedule_exceptions(start_date, end_date)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS schedule_exceptions`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "calendar imports",
+		Up: []string{
+			`ALTER TABLE schedule_exceptions ADD COLUMN source TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE schedule_exceptions DROP COLUMN source`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "device timezones",
+		Up: []string{
+			`ALTER TABLE device_configs ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE device_configs DROP COLUMN timezone`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "device activity rules",
+		Up: []string{
+			`ALTER TABLE device_configs ADD COLUMN activity TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE device_configs DROP COLUMN activity`,
+		},
+	},
+	{
+		Version: 14,
+		Name:    "usage seconds",
+		Up: []string{
+			`ALTER TABLE block_usage ADD COLUMN used_seconds INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE block_usage SET used_seconds = used_minutes * 60`,
+			`ALTER TABLE block_usage DROP COLUMN used_minutes`,
+		},
+		Down: []string{
+			`ALTER TABLE block_usage ADD COLUMN used_minutes INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE block_usage SET used_minutes = (used_seconds + 59) / 60`,
+			`ALTER TABLE block_usage DROP COLUMN used_seconds`,
+		},
+	},
+	{
+		Version: 15,
+		Name:    "traffic samples",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS traffic_samples (
+				mac TEXT NOT NULL,
+				resolution TEXT NOT NULL,
+				start_time DATETIME NOT NULL,
+				seconds INTEGER NOT NULL DEFAULT 0,
+				active_seconds INTEGER NOT NULL DEFAULT 0,
+				rx_bytes INTEGER NOT NULL DEFAULT 0,
+				tx_bytes INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (mac, resolution, start_time)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_traffic_samples_mac_start ON traffic_samples(mac, start_time)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS traffic_samples`,
+		},
+	},
+}
@@ -0,0 +1,127 @@
+package storage_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+	"github.com/nadilas/zeitpolizei/internal/storage/storagetest"
+)
+
+// postgresDSNEnv names the environment variable with the DSN of a PostgreSQL
+// database for TestPostgres. Each test runs in a schema of its own that is
+// dropped afterwards.
+const postgresDSNEnv = "ZEITPOLIZEI_TEST_POSTGRES_DSN"
+
+func TestMemory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewMemory()
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		s, err := storage.NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	var n int64
+	prefix := fmt.Sprintf("zeitpolizei_test_%d", time.Now().UnixNano())
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		schema := fmt.Sprintf("%s_%d", prefix, atomic.AddInt64(&n, 1))
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Errorf("drop schema %s: %v", schema, err)
+			}
+		})
+
+		s, err := storage.NewPostgres(withSearchPath(dsn, schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// withSearchPath adds a search_path run-time parameter to a URL or key=value DSN
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			query := u.Query()
+			query.Set("search_path", schema)
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+// TestOpenExisting opens SQLite databases without migrating them
+func TestOpenExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	if _, err := storage.OpenExisting("sqlite", path); err == nil {
+		t.Error("OpenExisting of a missing database succeeded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("OpenExisting created the database: %v", err)
+	}
+
+	s, err := storage.NewSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	existing, err := storage.OpenExisting("sqlite", path)
+	if err != nil {
+		t.Fatalf("OpenExisting of a migrated database: %v", err)
+	}
+	if _, err := existing.GetAllDeviceConfigs(); err != nil {
+		t.Errorf("GetAllDeviceConfigs: %v", err)
+	}
+	existing.Close()
+
+	// A database with pending migrations is reported, not migrated
+	migrator, err := storage.OpenMigrator("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Down(migrator.Latest() - 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.OpenExisting("sqlite", path); !errors.Is(err, storage.ErrSchemaOutdated) {
+		t.Errorf("OpenExisting of an outdated database = %v, want %v", err, storage.ErrSchemaOutdated)
+	}
+	if version, err := migrator.Version(); err != nil || version != migrator.Latest()-1 {
+		t.Errorf("version after OpenExisting = %d, %v; want %d", version, err, migrator.Latest()-1)
+	}
+	migrator.Close()
+}
@@ -0,0 +1,997 @@
+// Package storagetest provides a conformance suite that every storage.Store
+// implementation must pass. Implementations call Run from their own tests:
+//
+//	func TestSQLite(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Store {
+//			s, err := storage.NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return s
+//		})
+//	}
+package storagetest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Factory returns a new, empty store. The suite closes it when the test ends.
+type Factory func(t *testing.T) storage.Store
+
+// Run runs the conformance suite against stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Store)
+	}{
+		{"DeviceConfigs", testDeviceConfigs},
+		{"BlockUsage", testBlockUsage},
+		{"Bonus", testBonus},
+		{"DeviceState", testDeviceState},
+		{"Overrides", testOverrides},
+		{"ScheduleExceptions", testScheduleExceptions},
+		{"ImportedExceptions", testImportedExceptions},
+		{"UsageHistory", testUsageHistory},
+		{"DriftEvents", testDriftEvents},
+		{"Sessions", testSessions},
+		{"Users", testUsers},
+		{"Events", testEvents},
+		{"TrafficSamples", testTrafficSamples},
+		{"Webhooks", testWebhooks},
+		{"WebhookDeliveries", testWebhookDeliveries},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(func() { s.Close() })
+			tt.fn(t, s)
+		})
+	}
+}
+
+func intPtr(v int) *int       { return &v }
+func int64Ptr(v int64) *int64 { return &v }
+
+func testDeviceConfigs(t *testing.T, s storage.Store) {
+	config, err := s.GetDeviceConfig("aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatalf("GetDeviceConfig: %v", err)
+	}
+	if config != nil {
+		t.Fatalf("GetDeviceConfig on empty store = %+v, want nil", config)
+	}
+
+	want := &storage.DeviceConfig{
+		MAC:          "aa:bb:cc:dd:ee:ff",
+		Name:         "Tablet",
+		Enabled:      true,
+		BlockOutside: true,
+		Timezone:     "Europe/Berlin",
+		Activity:     &storage.ActivityRules{MinBytes: 4096, BackgroundMaxBytes: 20000, BackgroundWindowMinutes: 10},
+		UpdatedBy:    "admin",
+		DailySchedules: []storage.DaySchedule{{
+			Days: []string{"weekdays"},
+			TimeBlocks: []storage.TimeBlock{{
+				StartTime:               "15:00",
+				EndTime:                 "18:00",
+				LimitMinutes:            intPtr(60),
+				LimitBytes:              int64Ptr(1 << 30),
+				WarningThresholdPercent: 80,
+			}},
+		}},
+	}
+	if err := s.SaveDeviceConfig(want); err != nil {
+		t.Fatalf("SaveDeviceConfig: %v", err)
+	}
+
+	got, err := s.GetDeviceConfig(want.MAC)
+	if err != nil {
+		t.Fatalf("GetDeviceConfig: %v", err)
+	}
+	if got == nil {
+		t.Fatal("GetDeviceConfig after save = nil")
+	}
+	if got.Name != want.Name || got.Enabled != want.Enabled || got.BlockOutside != want.BlockOutside ||
+		got.Timezone != want.Timezone || got.UpdatedBy != want.UpdatedBy {
+		t.Errorf("GetDeviceConfig = %+v, want %+v", got, want)
+	}
+	if len(got.DailySchedules) != 1 || len(got.DailySchedules[0].TimeBlocks) != 1 {
+		t.Fatalf("schedules = %+v, want one schedule with one block", got.DailySchedules)
+	}
+	if got.Activity == nil || *got.Activity != *want.Activity {
+		t.Errorf("activity rules = %+v, want %+v", got.Activity, want.Activity)
+	}
+	block := got.DailySchedules[0].TimeBlocks[0]
+	if block.StartTime != "15:00" || block.EndTime != "18:00" || block.LimitMinutes == nil || *block.LimitMinutes != 60 ||
+		block.LimitBytes == nil || *block.LimitBytes != 1<<30 || block.WarningThresholdPercent != 80 {
+		t.Errorf("time block = %+v, want 15:00-18:00 60min 1GiB 80%%", block)
+	}
+
+	// Updating keeps a single record
+	want.Name = "Kids Tablet"
+	if err := s.SaveDeviceConfig(want); err != nil {
+		t.Fatalf("SaveDeviceConfig (update): %v", err)
+	}
+	all, err := s.GetAllDeviceConfigs()
+	if err != nil {
+		t.Fatalf("GetAllDeviceConfigs: %v", err)
+	}
+	if len(all) != 1 || all[0].Name != "Kids Tablet" {
+		t.Fatalf("GetAllDeviceConfigs = %+v, want one config named Kids Tablet", all)
+	}
+
+	if err := s.DeleteDeviceConfig(want.MAC); err != nil {
+		t.Fatalf("DeleteDeviceConfig: %v", err)
+	}
+	if got, _ := s.GetDeviceConfig(want.MAC); got != nil {
+		t.Errorf("GetDeviceConfig after delete = %+v, want nil", got)
+	}
+}
+
+func testBlockUsage(t *testing.T, s storage.Store) {
+	const mac, date = "aa:bb:cc:dd:ee:ff", "2024-03-01"
+
+	usage, err := s.GetOrCreateBlockUsage(mac, date, 1, "15:00", "18:00", intPtr(60), nil)
+	if err != nil {
+		t.Fatalf("GetOrCreateBlockUsage: %v", err)
+	}
+	if usage.ID == 0 || usage.MAC != mac || usage.Date != date || usage.BlockIndex != 1 {
+		t.Fatalf("GetOrCreateBlockUsage = %+v, want new record for %s %s #1", usage, mac, date)
+	}
+	if usage.LimitMinutes == nil || *usage.LimitMinutes != 60 || usage.LimitBytes != nil {
+		t.Errorf("limits = %v/%v, want 60 minutes and no byte limit", usage.LimitMinutes, usage.LimitBytes)
+	}
+
+	if err := s.AddBlockUsage(usage.ID, 12*60, 4000, 50, 150); err != nil {
+		t.Fatalf("AddBlockUsage: %v", err)
+	}
+	if err := s.AddBlockUsage(usage.ID, 30, 96, 100, 200); err != nil {
+		t.Fatalf("AddBlockUsage: %v", err)
+	}
+
+	usage.IsBlocked = true
+	usage.BlockedReason = "time_limit"
+	usage.WarningsSent = 3
+	if err := s.UpdateBlockUsage(usage); err != nil {
+		t.Fatalf("UpdateBlockUsage: %v", err)
+	}
+
+	again, err := s.GetOrCreateBlockUsage(mac, date, 1, "15:00", "18:00", intPtr(60), nil)
+	if err != nil {
+		t.Fatalf("GetOrCreateBlockUsage (existing): %v", err)
+	}
+	if again.ID != usage.ID || again.UsedSeconds != 12*60+30 || again.UsedBytes != 4096 || !again.IsBlocked ||
+		again.BlockedReason != "time_limit" || again.LastTxBytes != 100 || again.LastRxBytes != 200 ||
+		again.WarningsSent != 3 {
+		t.Errorf("GetOrCreateBlockUsage (existing) = %+v, want updated record %+v", again, usage)
+	}
+
+	if _, err := s.GetOrCreateBlockUsage(mac, date, 0, "06:00", "07:30", nil, nil); err != nil {
+		t.Fatalf("GetOrCreateBlockUsage (block 0): %v", err)
+	}
+	if _, err := s.GetOrCreateBlockUsage("11:22:33:44:55:66", date, 0, "06:00", "07:30", nil, nil); err != nil {
+		t.Fatalf("GetOrCreateBlockUsage (other device): %v", err)
+	}
+
+	usages, err := s.GetBlockUsageForDate(mac, date)
+	if err != nil {
+		t.Fatalf("GetBlockUsageForDate: %v", err)
+	}
+	if len(usages) != 2 || usages[0].BlockIndex != 0 || usages[1].BlockIndex != 1 {
+		t.Errorf("GetBlockUsageForDate = %d records, want blocks 0 and 1 in order", len(usages))
+	}
+
+	all, err := s.GetAllUsageForDate(date)
+	if err != nil {
+		t.Fatalf("GetAllUsageForDate: %v", err)
+	}
+	if len(all) != 2 || len(all[mac]) != 2 || len(all["11:22:33:44:55:66"]) != 1 {
+		t.Errorf("GetAllUsageForDate = %v, want 2 devices with 2 and 1 records", all)
+	}
+
+	for _, d := range []string{"2024-02-29", "2024-03-02", "2024-03-03"} {
+		if _, err := s.GetOrCreateBlockUsage(mac, d, 0, "06:00", "07:30", nil, nil); err != nil {
+			t.Fatalf("GetOrCreateBlockUsage (%s): %v", d, err)
+		}
+	}
+	ranged, err := s.GetBlockUsageRange(mac, date, "2024-03-02")
+	if err != nil {
+		t.Fatalf("GetBlockUsageRange: %v", err)
+	}
+	if len(ranged) != 3 || ranged[0].Date != date || ranged[0].BlockIndex != 0 || ranged[1].BlockIndex != 1 ||
+		ranged[2].Date != "2024-03-02" {
+		t.Errorf("GetBlockUsageRange = %d records, want blocks 0 and 1 of %s and block 0 of the next day in order", len(ranged), date)
+	}
+}
+
+func testBonus(t *testing.T, s storage.Store) {
+	const mac, date = "aa:bb:cc:dd:ee:ff", "2024-03-01"
+
+	if _, err := s.GetOrCreateBlockUsage(mac, date, 0, "15:00", "18:00", intPtr(60), int64Ptr(1024)); err != nil {
+		t.Fatalf("GetOrCreateBlockUsage: %v", err)
+	}
+	if err := s.AddBonusTime(mac, date, 0, 15); err != nil {
+		t.Fatalf("AddBonusTime: %v", err)
+	}
+	if err := s.AddBonusTime(mac, date, 0, 15); err != nil {
+		t.Fatalf("AddBonusTime: %v", err)
+	}
+	if err := s.AddBonusData(mac, date, 0, 512); err != nil {
+		t.Fatalf("AddBonusData: %v", err)
+	}
+
+	usage, err := s.GetOrCreateBlockUsage(mac, date, 0, "15:00", "18:00", intPtr(60), int64Ptr(1024))
+	if err != nil {
+		t.Fatalf("GetOrCreateBlockUsage: %v", err)
+	}
+	if usage.BonusMinutes != 30 || usage.BonusBytes != 512 {
+		t.Errorf("bonus = %d minutes/%d bytes, want 30/512", usage.BonusMinutes, usage.BonusBytes)
+	}
+
+	// Writing back a record read before a bonus or more usage was added
+	// keeps both
+	if err := s.AddBonusTime(mac, date, 0, 10); err != nil {
+		t.Fatalf("AddBonusTime: %v", err)
+	}
+	if err := s.AddBlockUsage(usage.ID, 60, 2048, 10, 20); err != nil {
+		t.Fatalf("AddBlockUsage: %v", err)
+	}
+	usage.IsBlocked = true
+	usage.BlockedReason = "time_limit"
+	if err := s.UpdateBlockUsage(usage); err != nil {
+		t.Fatalf("UpdateBlockUsage: %v", err)
+	}
+
+	usage, err = s.GetOrCreateBlockUsage(mac, date, 0, "15:00", "18:00", intPtr(60), int64Ptr(1024))
+	if err != nil {
+		t.Fatalf("GetOrCreateBlockUsage: %v", err)
+	}
+	if usage.BonusMinutes != 40 || usage.BonusBytes != 512 || usage.UsedSeconds != 60 || usage.UsedBytes != 2048 || !usage.IsBlocked {
+		t.Errorf("after stale update = %+v, want 40 bonus minutes, 512 bonus bytes, 60 s and 2048 bytes used, blocked", usage)
+	}
+}
+
+func testDeviceState(t *testing.T, s storage.Store) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	state, err := s.GetDeviceState(mac)
+	if err != nil {
+		t.Fatalf("GetDeviceState: %v", err)
+	}
+	if state == nil || state.MAC != mac || state.IsBlocked {
+		t.Fatalf("GetDeviceState on unknown device = %+v, want unblocked state", state)
+	}
+
+	blockedAt := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	if err := s.SaveDeviceState(&storage.DeviceState{
+		MAC:           mac,
+		IsBlocked:     true,
+		BlockedReason: "manual",
+		BlockedAt:     blockedAt,
+		ChangedBy:     "parent",
+	}); err != nil {
+		t.Fatalf("SaveDeviceState: %v", err)
+	}
+
+	state, err = s.GetDeviceState(mac)
+	if err != nil {
+		t.Fatalf("GetDeviceState: %v", err)
+	}
+	if !state.IsBlocked || state.BlockedReason != "manual" || !state.BlockedAt.Equal(blockedAt) || state.ChangedBy != "parent" {
+		t.Errorf("GetDeviceState = %+v, want manual block at %v by parent", state, blockedAt)
+	}
+
+	state.IsBlocked = false
+	state.BlockedReason = ""
+	state.UnblockedAt = blockedAt.Add(time.Hour)
+	if err := s.SaveDeviceState(state); err != nil {
+		t.Fatalf("SaveDeviceState (unblock): %v", err)
+	}
+	state, _ = s.GetDeviceState(mac)
+	if state.IsBlocked || !state.UnblockedAt.Equal(blockedAt.Add(time.Hour)) {
+		t.Errorf("GetDeviceState after unblock = %+v", state)
+	}
+}
+
+func testOverrides(t *testing.T, s storage.Store) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	if got, err := s.GetOverride(mac); err != nil || got != nil {
+		t.Fatalf("GetOverride on unknown device = %+v, %v; want nil", got, err)
+	}
+
+	expires := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	if err := s.SaveOverride(&storage.Override{
+		MAC:       mac,
+		Action:    storage.OverrideBlock,
+		ExpiresAt: expires,
+		CreatedBy: "mum",
+	}); err != nil {
+		t.Fatalf("SaveOverride: %v", err)
+	}
+	if err := s.SaveOverride(&storage.Override{
+		MAC:       "11:22:33:44:55:66",
+		Action:    storage.OverrideUnblock,
+		ExpiresAt: expires.Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("SaveOverride (second device): %v", err)
+	}
+
+	got, err := s.GetOverride(mac)
+	if err != nil {
+		t.Fatalf("GetOverride: %v", err)
+	}
+	if got == nil || got.Action != storage.OverrideBlock || !got.ExpiresAt.Equal(expires) || got.CreatedBy != "mum" || got.CreatedAt.IsZero() {
+		t.Fatalf("GetOverride = %+v, want block until %v by mum", got, expires)
+	}
+	if !got.Active(expires.Add(-time.Minute)) || got.Active(expires) {
+		t.Errorf("Active around expiry of %+v is wrong", got)
+	}
+
+	// A new override replaces the previous one
+	if err := s.SaveOverride(&storage.Override{
+		MAC:       mac,
+		Action:    storage.OverrideUnblock,
+		ExpiresAt: expires.Add(time.Hour),
+		CreatedBy: "dad",
+	}); err != nil {
+		t.Fatalf("SaveOverride (replace): %v", err)
+	}
+
+	overrides, err := s.ListOverrides()
+	if err != nil {
+		t.Fatalf("ListOverrides: %v", err)
+	}
+	if len(overrides) != 2 || overrides[0].MAC != "11:22:33:44:55:66" {
+		t.Fatalf("ListOverrides = %+v, want 2 overrides ordered by expiry", overrides)
+	}
+	if o := overrides[1]; o.Action != storage.OverrideUnblock || !o.ExpiresAt.Equal(expires.Add(time.Hour)) || o.CreatedBy != "dad" {
+		t.Errorf("replaced override = %+v", o)
+	}
+
+	if err := s.DeleteOverride(mac); err != nil {
+		t.Fatalf("DeleteOverride: %v", err)
+	}
+	if got, err := s.GetOverride(mac); err != nil || got != nil {
+		t.Errorf("GetOverride after delete = %+v, %v; want nil", got, err)
+	}
+}
+
+func testScheduleExceptions(t *testing.T, s storage.Store) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	holidays := &storage.ScheduleException{
+		Name:      "Summer holidays",
+		StartDate: "2024-07-01",
+		EndDate:   "2024-08-15",
+		Mode:      storage.ExceptionSchedule,
+		DailySchedules: []storage.DaySchedule{{
+			Days:       []string{"weekdays", "weekends"},
+			TimeBlocks: []storage.TimeBlock{{StartTime: "10:00", EndTime: "20:00", LimitMinutes: intPtr(180)}},
+		}},
+		CreatedBy: "mum",
+	}
+	if err := s.CreateScheduleException(holidays); err != nil {
+		t.Fatalf("CreateScheduleException: %v", err)
+	}
+	if holidays.ID == 0 {
+		t.Fatalf("CreateScheduleException did not assign an ID")
+	}
+	sick := &storage.ScheduleException{MAC: mac, Name: "Sick", StartDate: "2024-07-03", EndDate: "2024-07-03", Mode: storage.ExceptionBlocked}
+	other := &storage.ScheduleException{MAC: "11:22:33:44:55:66", StartDate: "2024-06-01", EndDate: "2024-07-31", Mode: storage.ExceptionUnlimited}
+	for _, exception := range []*storage.ScheduleException{sick, other} {
+		if err := s.CreateScheduleException(exception); err != nil {
+			t.Fatalf("CreateScheduleException: %v", err)
+		}
+	}
+
+	got, err := s.GetScheduleException(holidays.ID)
+	if err != nil {
+		t.Fatalf("GetScheduleException: %v", err)
+	}
+	if got == nil || got.MAC != "" || got.Name != "Summer holidays" || got.StartDate != "2024-07-01" ||
+		got.EndDate != "2024-08-15" || got.Mode != storage.ExceptionSchedule || got.CreatedBy != "mum" ||
+		len(got.DailySchedules) != 1 || *got.DailySchedules[0].TimeBlocks[0].LimitMinutes != 180 {
+		t.Fatalf("GetScheduleException = %+v, want %+v", got, holidays)
+	}
+
+	all, err := s.ListScheduleExceptions(storage.ExceptionFilter{})
+	if err != nil {
+		t.Fatalf("ListScheduleExceptions: %v", err)
+	}
+	if len(all) != 3 || all[0].ID != other.ID || all[1].ID != holidays.ID {
+		t.Fatalf("ListScheduleExceptions = %+v, want 3 exceptions ordered by start date", all)
+	}
+
+	// A device sees its own exceptions and the global ones
+	onDate, err := s.ListScheduleExceptions(storage.ExceptionFilter{MAC: mac, Date: "2024-07-03"})
+	if err != nil {
+		t.Fatalf("ListScheduleExceptions (device, date): %v", err)
+	}
+	if len(onDate) != 2 || onDate[0].ID != holidays.ID || onDate[1].ID != sick.ID {
+		t.Errorf("ListScheduleExceptions(%s, 2024-07-03) = %+v, want holidays and sick day", mac, onDate)
+	}
+	if after, _ := s.ListScheduleExceptions(storage.ExceptionFilter{MAC: mac, Date: "2024-08-16"}); len(after) != 0 {
+		t.Errorf("ListScheduleExceptions after the end date = %+v, want none", after)
+	}
+
+	got.MAC = mac
+	got.Mode = storage.ExceptionUnlimited
+	got.DailySchedules = nil
+	got.EndDate = "2024-07-31"
+	if err := s.UpdateScheduleException(got); err != nil {
+		t.Fatalf("UpdateScheduleException: %v", err)
+	}
+	got, _ = s.GetScheduleException(holidays.ID)
+	if got.MAC != mac || got.Mode != storage.ExceptionUnlimited || len(got.DailySchedules) != 0 || got.EndDate != "2024-07-31" || got.CreatedBy != "mum" {
+		t.Errorf("updated exception = %+v", got)
+	}
+
+	if err := s.DeleteScheduleException(holidays.ID); err != nil {
+		t.Fatalf("DeleteScheduleException: %v", err)
+	}
+	if got, err := s.GetScheduleException(holidays.ID); err != nil || got != nil {
+		t.Errorf("GetScheduleException after delete = %+v, %v; want nil", got, err)
+	}
+}
+
+func testImportedExceptions(t *testing.T, s storage.Store) {
+	manual := &storage.ScheduleException{Name: "Vacation", StartDate: "2024-08-01", EndDate: "2024-08-14", Mode: storage.ExceptionUnlimited}
+	if err := s.CreateScheduleException(manual); err != nil {
+		t.Fatalf("CreateScheduleException: %v", err)
+	}
+
+	imported := []*storage.ScheduleException{
+		{Name: "Autumn holidays", StartDate: "2024-10-28", EndDate: "2024-10-31", Mode: storage.ExceptionBlocked},
+		{Name: "Christmas holidays", StartDate: "2024-12-23", EndDate: "2025-01-03", Mode: storage.ExceptionBlocked},
+	}
+	if err := s.ReplaceScheduleExceptions("school", imported); err != nil {
+		t.Fatalf("ReplaceScheduleExceptions: %v", err)
+	}
+	if imported[0].ID == 0 || imported[1].ID == 0 || imported[0].Source != "school" {
+		t.Fatalf("ReplaceScheduleExceptions did not assign IDs and sources: %+v", imported)
+	}
+	if err := s.ReplaceScheduleExceptions("family", []*storage.ScheduleException{
+		{Name: "Birthday", StartDate: "2024-11-05", EndDate: "2024-11-05", Mode: storage.ExceptionUnlimited},
+	}); err != nil {
+		t.Fatalf("ReplaceScheduleExceptions (second source): %v", err)
+	}
+
+	// A refresh replaces only the exceptions of its own source
+	if err := s.ReplaceScheduleExceptions("school", []*storage.ScheduleException{
+		{Name: "Winter holidays", StartDate: "2025-03-03", EndDate: "2025-03-07", Mode: storage.ExceptionBlocked},
+	}); err != nil {
+		t.Fatalf("ReplaceScheduleExceptions (refresh): %v", err)
+	}
+
+	all, err := s.ListScheduleExceptions(storage.ExceptionFilter{})
+	if err != nil {
+		t.Fatalf("ListScheduleExceptions: %v", err)
+	}
+	var names []string
+	for _, exception := range all {
+		names = append(names, exception.Source+"/"+exception.Name)
+	}
+	if got, want := strings.Join(names, ","), "/Vacation,family/Birthday,school/Winter holidays"; got != want {
+		t.Errorf("exceptions after refresh = %s, want %s", got, want)
+	}
+
+	// Updates keep the source
+	winter := all[2]
+	winter.Name = "Ski week"
+	if err := s.UpdateScheduleException(winter); err != nil {
+		t.Fatalf("UpdateScheduleException: %v", err)
+	}
+	if got, _ := s.GetScheduleException(winter.ID); got == nil || got.Source != "school" || got.Name != "Ski week" {
+		t.Errorf("updated imported exception = %+v, want source school", got)
+	}
+}
+
+func testUsageHistory(t *testing.T, s storage.Store) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	today := time.Now().UTC().Format("2006-01-02")
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	old := time.Now().UTC().AddDate(0, 0, -60).Format("2006-01-02")
+
+	for _, rec := range []struct {
+		date    string
+		block   int
+		seconds int
+		bytes   int64
+	}{
+		{today, 0, 630, 100}, // Half minutes add up in the total
+		{today, 1, 1170, 200},
+		{yesterday, 0, 300, 50},
+		{old, 0, 5940, 999},
+	} {
+		usage, err := s.GetOrCreateBlockUsage(mac, rec.date, rec.block, "00:00", "23:59", nil, nil)
+		if err != nil {
+			t.Fatalf("GetOrCreateBlockUsage: %v", err)
+		}
+		if err := s.AddBlockUsage(usage.ID, rec.seconds, rec.bytes, 0, 0); err != nil {
+			t.Fatalf("AddBlockUsage: %v", err)
+		}
+	}
+
+	history, err := s.GetUsageHistory(mac, time.Now().UTC().AddDate(0, 0, -30).Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("GetUsageHistory: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("GetUsageHistory = %d entries, want 2 within 30 days", len(history))
+	}
+	if history[0].Date != today || history[0].TotalMinutes != 30 || history[0].TotalSeconds != 1800 || history[0].TotalBytes != 300 || len(history[0].Blocks) != 2 {
+		t.Errorf("history[0] = %+v, want today with 30 minutes (1800 seconds), 300 bytes and 2 blocks", history[0])
+	}
+	if history[1].Date != yesterday || history[1].TotalMinutes != 5 {
+		t.Errorf("history[1] = %+v, want yesterday with 5 minutes", history[1])
+	}
+}
+
+func testDriftEvents(t *testing.T, s storage.Store) {
+	base := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	for i, mac := range []string{"aa:aa:aa:aa:aa:aa", "bb:bb:bb:bb:bb:bb", "aa:aa:aa:aa:aa:aa"} {
+		event := &storage.DriftEvent{
+			MAC:         mac,
+			WantBlocked: true,
+			Corrected:   true,
+			DetectedAt:  base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := s.SaveDriftEvent(event); err != nil {
+			t.Fatalf("SaveDriftEvent: %v", err)
+		}
+		if event.ID == 0 {
+			t.Errorf("SaveDriftEvent did not assign an ID")
+		}
+	}
+
+	events, err := s.GetDriftEvents("", 10)
+	if err != nil {
+		t.Fatalf("GetDriftEvents: %v", err)
+	}
+	if len(events) != 3 || !events[0].DetectedAt.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("GetDriftEvents = %d events, want 3 newest first", len(events))
+	}
+
+	events, err = s.GetDriftEvents("aa:aa:aa:aa:aa:aa", 1)
+	if err != nil {
+		t.Fatalf("GetDriftEvents (filtered): %v", err)
+	}
+	if len(events) != 1 || events[0].MAC != "aa:aa:aa:aa:aa:aa" {
+		t.Errorf("GetDriftEvents (filtered) = %+v, want 1 event for aa:aa:aa:aa:aa:aa", events)
+	}
+}
+
+func testSessions(t *testing.T, s storage.Store) {
+	now := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+
+	if session, err := s.GetSession("missing"); err != nil || session != nil {
+		t.Fatalf("GetSession(missing) = %+v, %v, want nil, nil", session, err)
+	}
+
+	for _, session := range []*storage.Session{
+		{ID: "active", Username: "admin", RefreshID: "r1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "expired", Username: "admin", RefreshID: "r2", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := s.CreateSession(session); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+	}
+
+	session, err := s.GetSession("active")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session == nil || session.Username != "admin" || session.RefreshID != "r1" || !session.ExpiresAt.Equal(now.Add(time.Hour)) || !session.RevokedAt.IsZero() {
+		t.Fatalf("GetSession = %+v, want active admin session", session)
+	}
+
+	session.RefreshID = "r3"
+	session.ExpiresAt = now.Add(2 * time.Hour)
+	if err := s.UpdateSession(session); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	session, _ = s.GetSession("active")
+	if session.RefreshID != "r3" || !session.ExpiresAt.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("GetSession after update = %+v, want refresh ID r3", session)
+	}
+
+	if err := s.RevokeSession("active", now); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	session, _ = s.GetSession("active")
+	if !session.RevokedAt.Equal(now) {
+		t.Errorf("RevokedAt = %v, want %v", session.RevokedAt, now)
+	}
+
+	// Revoking the sessions of a user leaves other users and revoked ones alone
+	for _, session := range []*storage.Session{
+		{ID: "mum-1", Username: "mum", RefreshID: "r4", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "mum-2", Username: "mum", RefreshID: "r5", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "dad", Username: "dad", RefreshID: "r6", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := s.CreateSession(session); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+	}
+	if err := s.RevokeUserSessions("mum", now.Add(time.Minute)); err != nil {
+		t.Fatalf("RevokeUserSessions: %v", err)
+	}
+	if err := s.RevokeUserSessions("admin", now.Add(time.Minute)); err != nil {
+		t.Fatalf("RevokeUserSessions: %v", err)
+	}
+	for id, want := range map[string]time.Time{"mum-1": now.Add(time.Minute), "mum-2": now.Add(time.Minute), "dad": {}, "active": now} {
+		if session, _ := s.GetSession(id); session == nil || !session.RevokedAt.Equal(want) {
+			t.Errorf("session %s after RevokeUserSessions = %+v, want revoked at %v", id, session, want)
+		}
+	}
+
+	if err := s.DeleteExpiredSessions(now); err != nil {
+		t.Fatalf("DeleteExpiredSessions: %v", err)
+	}
+	if session, _ := s.GetSession("expired"); session != nil {
+		t.Errorf("expired session still present after DeleteExpiredSessions")
+	}
+	if session, _ := s.GetSession("active"); session == nil {
+		t.Errorf("unexpired session removed by DeleteExpiredSessions")
+	}
+}
+
+func testUsers(t *testing.T, s storage.Store) {
+	if user, err := s.GetUser("missing"); err != nil || user != nil {
+		t.Fatalf("GetUser(missing) = %+v, %v, want nil, nil", user, err)
+	}
+
+	for _, user := range []*storage.User{
+		{Username: "mum", PasswordHash: "hash1", Role: "admin"},
+		{Username: "grandpa", PasswordHash: "hash2", Role: "viewer"},
+	} {
+		if err := s.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser(%s): %v", user.Username, err)
+		}
+		if user.ID == 0 {
+			t.Errorf("CreateUser(%s) did not assign an ID", user.Username)
+		}
+	}
+
+	if err := s.CreateUser(&storage.User{Username: "mum", PasswordHash: "x", Role: "viewer"}); !errors.Is(err, storage.ErrUserExists) {
+		t.Errorf("CreateUser(duplicate) = %v, want ErrUserExists", err)
+	}
+
+	user, err := s.GetUser("mum")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user == nil || user.PasswordHash != "hash1" || user.Role != "admin" {
+		t.Fatalf("GetUser = %+v, want admin mum", user)
+	}
+
+	user.Role = "parent"
+	user.PasswordHash = "hash3"
+	if err := s.UpdateUser(user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	user, _ = s.GetUser("mum")
+	if user.Role != "parent" || user.PasswordHash != "hash3" {
+		t.Errorf("GetUser after update = %+v, want parent with new hash", user)
+	}
+
+	users, err := s.ListUsers()
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(users) != 2 || users[0].Username != "grandpa" || users[1].Username != "mum" {
+		t.Fatalf("ListUsers = %+v, want grandpa, mum", users)
+	}
+
+	if err := s.DeleteUser("grandpa"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if user, _ := s.GetUser("grandpa"); user != nil {
+		t.Errorf("GetUser after delete = %+v, want nil", user)
+	}
+
+	sso := &storage.User{Username: "kid", Role: "viewer", OIDCIssuer: "https://idp.example", OIDCSubject: "1234"}
+	if err := s.CreateUser(sso); err != nil {
+		t.Fatalf("CreateUser(oidc): %v", err)
+	}
+	user, err = s.GetUserByOIDC("https://idp.example", "1234")
+	if err != nil {
+		t.Fatalf("GetUserByOIDC: %v", err)
+	}
+	if user == nil || user.Username != "kid" || user.Role != "viewer" {
+		t.Fatalf("GetUserByOIDC = %+v, want viewer kid", user)
+	}
+	for _, key := range [][2]string{{"https://other.example", "1234"}, {"https://idp.example", "5678"}, {"", ""}} {
+		if user, err := s.GetUserByOIDC(key[0], key[1]); err != nil || user != nil {
+			t.Errorf("GetUserByOIDC(%q, %q) = %+v, %v, want nil, nil", key[0], key[1], user, err)
+		}
+	}
+
+	user, _ = s.GetUser("mum")
+	user.OIDCIssuer, user.OIDCSubject = "https://idp.example", "5678"
+	if err := s.UpdateUser(user); err != nil {
+		t.Fatalf("UpdateUser(oidc): %v", err)
+	}
+	if user, _ := s.GetUserByOIDC("https://idp.example", "5678"); user == nil || user.Username != "mum" {
+		t.Errorf("GetUserByOIDC after linking = %+v, want mum", user)
+	}
+}
+
+func testEvents(t *testing.T, s storage.Store) {
+	base := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+
+	for _, event := range []*storage.Event{
+		{Time: base, Type: storage.EventBlock, MAC: "aa:aa:aa:aa:aa:aa", Actor: "system", Reason: "time_limit", UsedMinutes: 60, LimitMinutes: intPtr(60)},
+		{Time: base.Add(time.Minute), Type: storage.EventBonusTime, MAC: "aa:aa:aa:aa:aa:aa", Actor: "mum", Details: "+30 minutes", UsedBytes: 1 << 20, LimitBytes: int64Ptr(1 << 30)},
+		{Time: base.Add(2 * time.Minute), Type: storage.EventUnblock, MAC: "aa:aa:aa:aa:aa:aa", Actor: "system", Reason: "time_limit"},
+		{Time: base.Add(3 * time.Minute), Type: storage.EventBlock, MAC: "bb:bb:bb:bb:bb:bb", Actor: "dad", Reason: "manual"},
+	} {
+		if err := s.SaveEvent(event); err != nil {
+			t.Fatalf("SaveEvent: %v", err)
+		}
+		if event.ID == 0 {
+			t.Errorf("SaveEvent did not assign an ID")
+		}
+	}
+
+	all, err := s.ListEvents(storage.EventFilter{})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(all) != 4 || all[0].MAC != "bb:bb:bb:bb:bb:bb" || all[3].Type != storage.EventBlock {
+		t.Fatalf("ListEvents = %+v, want 4 events newest first", all)
+	}
+	first := all[3]
+	if first.Actor != "system" || first.Reason != "time_limit" || first.UsedMinutes != 60 ||
+		first.LimitMinutes == nil || *first.LimitMinutes != 60 || first.LimitBytes != nil || !first.Time.Equal(base) {
+		t.Errorf("oldest event = %+v, want system time_limit block at 60/60 minutes", first)
+	}
+	bonus := all[2]
+	if bonus.Details != "+30 minutes" || bonus.UsedBytes != 1<<20 || bonus.LimitBytes == nil || *bonus.LimitBytes != 1<<30 {
+		t.Errorf("bonus event = %+v", bonus)
+	}
+
+	tests := []struct {
+		name   string
+		filter storage.EventFilter
+		want   int
+	}{
+		{"mac", storage.EventFilter{MAC: "aa:aa:aa:aa:aa:aa"}, 3},
+		{"type", storage.EventFilter{Type: storage.EventBlock}, 2},
+		{"mac and type", storage.EventFilter{MAC: "aa:aa:aa:aa:aa:aa", Type: storage.EventBlock}, 1},
+		{"from", storage.EventFilter{From: base.Add(time.Minute)}, 3},
+		{"to", storage.EventFilter{To: base.Add(time.Minute)}, 1},
+		{"range", storage.EventFilter{From: base.Add(time.Minute), To: base.Add(3 * time.Minute)}, 2},
+		{"limit", storage.EventFilter{Limit: 2}, 2},
+	}
+	for _, tt := range tests {
+		events, err := s.ListEvents(tt.filter)
+		if err != nil {
+			t.Fatalf("ListEvents(%s): %v", tt.name, err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("ListEvents(%s) returned %d events, want %d", tt.name, len(events), tt.want)
+		}
+	}
+}
+
+func testTrafficSamples(t *testing.T, s storage.Store) {
+	const mac = "aa:aa:aa:aa:aa:aa"
+	base := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+
+	for _, sample := range []*storage.TrafficSample{
+		{MAC: mac, Resolution: storage.ResolutionMinute, Start: base, Seconds: 30, ActiveSeconds: 30, RxBytes: 1000, TxBytes: 100},
+		{MAC: mac, Resolution: storage.ResolutionMinute, Start: base, Seconds: 30, RxBytes: 10, TxBytes: 1},
+		{MAC: mac, Resolution: storage.ResolutionMinute, Start: base.Add(time.Minute), Seconds: 60, ActiveSeconds: 60, RxBytes: 2000},
+		{MAC: mac, Resolution: storage.ResolutionMinute, Start: base.Add(time.Hour), Seconds: 60, RxBytes: 5},
+		{MAC: "bb:bb:bb:bb:bb:bb", Resolution: storage.ResolutionMinute, Start: base, Seconds: 60, TxBytes: 7},
+	} {
+		if err := s.AddTrafficSample(sample); err != nil {
+			t.Fatalf("AddTrafficSample: %v", err)
+		}
+	}
+
+	samples, err := s.ListTrafficSamples(storage.TrafficFilter{MAC: mac})
+	if err != nil {
+		t.Fatalf("ListTrafficSamples: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("ListTrafficSamples = %d samples, want 3 with the first minute added up", len(samples))
+	}
+	first := samples[0]
+	if !first.Start.Equal(base) || first.Seconds != 60 || first.ActiveSeconds != 30 || first.RxBytes != 1010 || first.TxBytes != 101 {
+		t.Errorf("first sample = %+v, want both polls of the first minute added up", first)
+	}
+	if !samples[2].Start.Equal(base.Add(time.Hour)) {
+		t.Errorf("samples are not ordered by start: %+v", samples)
+	}
+
+	ranged, err := s.ListTrafficSamples(storage.TrafficFilter{MAC: mac, From: base.Add(time.Minute), To: base.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("ListTrafficSamples (range): %v", err)
+	}
+	if len(ranged) != 1 || !ranged[0].Start.Equal(base.Add(time.Minute)) {
+		t.Errorf("ListTrafficSamples (range) = %+v, want the second minute", ranged)
+	}
+
+	// Roll the first hour up
+	rollup := &storage.TrafficSample{MAC: mac, Resolution: storage.ResolutionHour, Start: base, Seconds: 120, ActiveSeconds: 90, RxBytes: 3010, TxBytes: 101}
+	if err := s.RollupTrafficSamples(mac, storage.ResolutionMinute, base.Add(time.Hour), []*storage.TrafficSample{rollup}); err != nil {
+		t.Fatalf("RollupTrafficSamples: %v", err)
+	}
+	minutes, err := s.ListTrafficSamples(storage.TrafficFilter{Resolution: storage.ResolutionMinute})
+	if err != nil {
+		t.Fatalf("ListTrafficSamples (minutes): %v", err)
+	}
+	if len(minutes) != 2 || minutes[0].MAC != "bb:bb:bb:bb:bb:bb" || !minutes[1].Start.Equal(base.Add(time.Hour)) {
+		t.Errorf("minute samples after rollup = %+v, want the other device's and the second hour's", minutes)
+	}
+	hours, err := s.ListTrafficSamples(storage.TrafficFilter{MAC: mac, Resolution: storage.ResolutionHour})
+	if err != nil {
+		t.Fatalf("ListTrafficSamples (hours): %v", err)
+	}
+	if len(hours) != 1 || hours[0].Seconds != 120 || hours[0].ActiveSeconds != 90 || hours[0].RxBytes != 3010 {
+		t.Errorf("hourly samples = %+v, want the rollup", hours)
+	}
+
+	if err := s.DeleteTrafficSamples(storage.ResolutionMinute, base.Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteTrafficSamples: %v", err)
+	}
+	all, err := s.ListTrafficSamples(storage.TrafficFilter{})
+	if err != nil {
+		t.Fatalf("ListTrafficSamples (all): %v", err)
+	}
+	if len(all) != 2 || all[0].Resolution != storage.ResolutionHour || !all[1].Start.Equal(base.Add(time.Hour)) {
+		t.Errorf("samples after delete = %+v, want the rollup and the second hour's minute", all)
+	}
+}
+
+func testWebhooks(t *testing.T, s storage.Store) {
+	webhook := &storage.Webhook{
+		URL:       "http://automation.local/hook",
+		Secret:    "s3cret",
+		Events:    []string{storage.EventBlock, storage.EventUnblock},
+		Enabled:   true,
+		CreatedBy: "mum",
+	}
+	if err := s.CreateWebhook(webhook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if webhook.ID == 0 {
+		t.Fatalf("CreateWebhook did not assign an ID")
+	}
+	if err := s.CreateWebhook(&storage.Webhook{URL: "http://other.local", Secret: "x", Events: []string{}}); err != nil {
+		t.Fatalf("CreateWebhook (all events): %v", err)
+	}
+
+	got, err := s.GetWebhook(webhook.ID)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if got == nil || got.URL != webhook.URL || got.Secret != "s3cret" || len(got.Events) != 2 ||
+		got.Events[1] != storage.EventUnblock || !got.Enabled || got.CreatedBy != "mum" {
+		t.Fatalf("GetWebhook = %+v, want %+v", got, webhook)
+	}
+
+	got.URL = "http://automation.local/v2"
+	got.Events = nil
+	got.Enabled = false
+	got.Description = "garage lights"
+	if err := s.UpdateWebhook(got); err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+
+	webhooks, err := s.ListWebhooks()
+	if err != nil {
+		t.Fatalf("ListWebhooks: %v", err)
+	}
+	if len(webhooks) != 2 || webhooks[0].ID != webhook.ID {
+		t.Fatalf("ListWebhooks = %+v, want 2 webhooks ordered by ID", webhooks)
+	}
+	if w := webhooks[0]; w.URL != "http://automation.local/v2" || len(w.Events) != 0 || w.Enabled || w.Description != "garage lights" {
+		t.Errorf("updated webhook = %+v", w)
+	}
+
+	if err := s.DeleteWebhook(webhook.ID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if got, err := s.GetWebhook(webhook.ID); err != nil || got != nil {
+		t.Errorf("GetWebhook after delete = %+v, %v; want nil", got, err)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, s storage.Store) {
+	webhook := &storage.Webhook{URL: "http://automation.local/hook", Secret: "s3cret", Enabled: true}
+	if err := s.CreateWebhook(webhook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	base := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	var deliveries []*storage.WebhookDelivery
+	for i := 0; i < 3; i++ {
+		delivery := &storage.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventType:     storage.EventBlock,
+			Payload:       `{"version":1}`,
+			Status:        storage.DeliveryPending,
+			NextAttemptAt: base.Add(time.Duration(2-i) * time.Minute),
+		}
+		if err := s.CreateWebhookDelivery(delivery); err != nil {
+			t.Fatalf("CreateWebhookDelivery: %v", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	due, err := s.ListDueWebhookDeliveries(base.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("ListDueWebhookDeliveries: %v", err)
+	}
+	if len(due) != 2 || due[0].ID != deliveries[2].ID || due[1].ID != deliveries[1].ID {
+		t.Fatalf("ListDueWebhookDeliveries = %+v, want the last two deliveries, oldest due first", due)
+	}
+
+	failed := deliveries[2]
+	failed.Status = storage.DeliveryFailed
+	failed.Attempts = 6
+	failed.ResponseCode = 500
+	failed.Error = "500 Internal Server Error"
+	if err := s.UpdateWebhookDelivery(failed); err != nil {
+		t.Fatalf("UpdateWebhookDelivery: %v", err)
+	}
+	retry := deliveries[1]
+	retry.Attempts = 1
+	retry.NextAttemptAt = base.Add(time.Hour)
+	if err := s.UpdateWebhookDelivery(retry); err != nil {
+		t.Fatalf("UpdateWebhookDelivery: %v", err)
+	}
+
+	if due, err := s.ListDueWebhookDeliveries(base.Add(time.Minute), 10); err != nil || len(due) != 0 {
+		t.Errorf("ListDueWebhookDeliveries after update = %+v, %v; want none", due, err)
+	}
+
+	log, err := s.ListWebhookDeliveries(webhook.ID, 2)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+	if len(log) != 2 || log[0].ID != failed.ID {
+		t.Fatalf("ListWebhookDeliveries = %+v, want the 2 newest deliveries", log)
+	}
+	if d := log[0]; d.Status != storage.DeliveryFailed || d.Attempts != 6 || d.ResponseCode != 500 ||
+		d.Error != failed.Error || d.Payload != `{"version":1}` || d.EventType != storage.EventBlock {
+		t.Errorf("failed delivery = %+v", d)
+	}
+
+	// Pruning removes finished deliveries only, once they are old enough
+	if err := s.DeleteWebhookDeliveries(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("DeleteWebhookDeliveries: %v", err)
+	}
+	if log, err := s.ListWebhookDeliveries(webhook.ID, 10); err != nil || len(log) != 3 {
+		t.Errorf("ListWebhookDeliveries after pruning old deliveries = %+v, %v; want all 3", log, err)
+	}
+	if err := s.DeleteWebhookDeliveries(time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("DeleteWebhookDeliveries: %v", err)
+	}
+	log, err = s.ListWebhookDeliveries(webhook.ID, 10)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+	if len(log) != 2 || log[0].ID != retry.ID || log[1].ID != deliveries[0].ID {
+		t.Errorf("ListWebhookDeliveries after pruning = %+v, want the 2 pending deliveries", log)
+	}
+
+	if err := s.DeleteWebhook(webhook.ID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if log, err := s.ListWebhookDeliveries(webhook.ID, 10); err != nil || len(log) != 0 {
+		t.Errorf("ListWebhookDeliveries after delete = %+v, %v; want none", log, err)
+	}
+}
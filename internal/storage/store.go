@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrUserExists is returned when creating a user whose username is taken
+var ErrUserExists = errors.New("user already exists")
+
+// Store is the persistence layer shared by the enforcer, tracker and API.
+// SQLite is the default implementation, Postgres stores into an existing
+// PostgreSQL server and Memory keeps everything in process for tests and
+// ephemeral runs.
+type Store interface {
+	// Close releases the resources held by the store
+	Close() error
+
+	// SaveDeviceConfig saves or updates a device configuration
+	SaveDeviceConfig(config *DeviceConfig) error
+	// GetDeviceConfig retrieves a device configuration by MAC, nil if unknown
+	GetDeviceConfig(mac string) (*DeviceConfig, error)
+	// GetAllDeviceConfigs retrieves all device configurations
+	GetAllDeviceConfigs() ([]*DeviceConfig, error)
+	// DeleteDeviceConfig removes a device configuration
+	DeleteDeviceConfig(mac string) error
+
+	// GetOrCreateBlockUsage gets or creates a usage record for a time block
+	GetOrCreateBlockUsage(mac, date string, blockIndex int, startTime, endTime string, limitMinutes *int, limitBytes *int64) (*BlockUsage, error)
+	// UpdateBlockUsage updates the block state and sent warnings of a usage
+	// record. Used time, bytes and bonuses only change through AddBlockUsage,
+	// AddBonusTime and AddBonusData, which add to the stored values so that
+	// concurrent writers cannot undo each other's changes.
+	UpdateBlockUsage(usage *BlockUsage) error
+	// AddBlockUsage adds active seconds and bytes to a usage record and stores
+	// the byte counters they were measured up to
+	AddBlockUsage(id int64, seconds int, bytes, lastTxBytes, lastRxBytes int64) error
+	// GetBlockUsageForDate retrieves all usage records for a device on a date
+	GetBlockUsageForDate(mac, date string) ([]*BlockUsage, error)
+	// GetBlockUsageRange retrieves the usage records of a device from one
+	// date to another (YYYY-MM-DD, inclusive) ordered by date and block
+	GetBlockUsageRange(mac, from, to string) ([]*BlockUsage, error)
+	// GetAllUsageForDate retrieves usage for all devices on a date, keyed by MAC
+	GetAllUsageForDate(date string) (map[string][]*BlockUsage, error)
+	// AddBonusTime adds bonus minutes to a time block
+	AddBonusTime(mac string, date string, blockIndex int, minutes int) error
+	// AddBonusData adds bonus bytes to a time block
+	AddBonusData(mac string, date string, blockIndex int, bytes int64) error
+
+	// SaveDeviceState saves the current blocking state of a device
+	SaveDeviceState(state *DeviceState) error
+	// GetDeviceState retrieves the blocking state of a device, unblocked if unknown
+	GetDeviceState(mac string) (*DeviceState, error)
+
+	// SaveOverride sets the override of a device, replacing any previous one
+	SaveOverride(override *Override) error
+	// GetOverride retrieves the override of a device, nil if there is none
+	GetOverride(mac string) (*Override, error)
+	// ListOverrides retrieves all overrides ordered by expiry
+	ListOverrides() ([]*Override, error)
+	// DeleteOverride removes the override of a device
+	DeleteOverride(mac string) error
+
+	// CreateScheduleException adds a schedule exception
+	CreateScheduleException(exception *ScheduleException) error
+	// GetScheduleException retrieves a schedule exception, nil if unknown
+	GetScheduleException(id int64) (*ScheduleException, error)
+	// ListScheduleExceptions retrieves the exceptions matching filter ordered by start date
+	ListScheduleExceptions(filter ExceptionFilter) ([]*ScheduleException, error)
+	// UpdateScheduleException updates all fields of a schedule exception but its creation
+	UpdateScheduleException(exception *ScheduleException) error
+	// DeleteScheduleException removes a schedule exception
+	DeleteScheduleException(id int64) error
+	// ReplaceScheduleExceptions atomically replaces the exceptions imported
+	// from source, which must not be empty, with exceptions, setting their source
+	ReplaceScheduleExceptions(source string, exceptions []*ScheduleException) error
+
+	// GetUsageHistory retrieves daily usage for a device from the since date
+	// (YYYY-MM-DD) on, newest first
+	GetUsageHistory(mac string, since string) ([]*HistoryEntry, error)
+	// SaveDriftEvent records a reconciliation drift event
+	SaveDriftEvent(event *DriftEvent) error
+	// GetDriftEvents retrieves the most recent drift events, optionally filtered by MAC
+	GetDriftEvents(mac string, limit int) ([]*DriftEvent, error)
+
+	// CreateSession stores a new login session
+	CreateSession(session *Session) error
+	// GetSession retrieves a session by ID, nil if unknown
+	GetSession(id string) (*Session, error)
+	// UpdateSession updates the refresh ID and expiry of a session
+	UpdateSession(session *Session) error
+	// RevokeSession marks a session as revoked
+	RevokeSession(id string, at time.Time) error
+	// RevokeUserSessions marks every session of a user as revoked
+	RevokeUserSessions(username string, at time.Time) error
+	// DeleteExpiredSessions removes sessions that expired before a point in time
+	DeleteExpiredSessions(before time.Time) error
+
+	// CreateUser stores a new user, returning ErrUserExists if the username is taken
+	CreateUser(user *User) error
+	// GetUser retrieves a user by username, nil if unknown
+	GetUser(username string) (*User, error)
+	// GetUserByOIDC retrieves the user bound to an OpenID Connect issuer and
+	// subject, nil if unknown
+	GetUserByOIDC(issuer, subject string) (*User, error)
+	// ListUsers retrieves all users ordered by username
+	ListUsers() ([]*User, error)
+	// UpdateUser updates the password hash, role and OIDC identity of a user
+	UpdateUser(user *User) error
+	// DeleteUser removes a user
+	DeleteUser(username string) error
+
+	// SaveEvent appends an event to the audit log
+	SaveEvent(event *Event) error
+	// ListEvents retrieves events matching filter, newest first
+	ListEvents(filter EventFilter) ([]*Event, error)
+
+	// AddTrafficSample adds a sample to the one of its device, resolution and
+	// start, creating it if there is none
+	AddTrafficSample(sample *TrafficSample) error
+	// ListTrafficSamples retrieves the samples matching filter ordered by start
+	ListTrafficSamples(filter TrafficFilter) ([]*TrafficSample, error)
+	// RollupTrafficSamples atomically replaces the samples of a device and
+	// resolution that start before a point in time with rollups, adding them
+	// to existing samples of the same start
+	RollupTrafficSamples(mac, resolution string, before time.Time, rollups []*TrafficSample) error
+	// DeleteTrafficSamples removes the samples of a resolution that start
+	// before a point in time
+	DeleteTrafficSamples(resolution string, before time.Time) error
+
+	// CreateWebhook adds a webhook subscription
+	CreateWebhook(webhook *Webhook) error
+	// GetWebhook retrieves a webhook, nil if unknown
+	GetWebhook(id int64) (*Webhook, error)
+	// ListWebhooks retrieves all webhooks ordered by ID
+	ListWebhooks() ([]*Webhook, error)
+	// UpdateWebhook updates the URL, secret, events, description and enabled flag of a webhook
+	UpdateWebhook(webhook *Webhook) error
+	// DeleteWebhook removes a webhook and its deliveries
+	DeleteWebhook(id int64) error
+
+	// CreateWebhookDelivery queues a delivery
+	CreateWebhookDelivery(delivery *WebhookDelivery) error
+	// UpdateWebhookDelivery records the outcome of a delivery attempt
+	UpdateWebhookDelivery(delivery *WebhookDelivery) error
+	// ListWebhookDeliveries retrieves the deliveries of a webhook, newest first
+	ListWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error)
+	// ListDueWebhookDeliveries retrieves pending deliveries due at now, oldest first
+	ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error)
+	// DeleteWebhookDeliveries removes succeeded and failed deliveries last
+	// attempted before a point in time; pending ones are kept
+	DeleteWebhookDeliveries(before time.Time) error
+}
+
+// Open creates the store selected by driver. source is the database file for
+// SQLite, the connection string for PostgreSQL and ignored by the memory store.
+func Open(driver, source string) (Store, error) {
+	switch driver {
+	case "", "sqlite", "sqlite3":
+		s, err := NewSQLite(source)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "postgres", "postgresql":
+		s, err := NewPostgres(source)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// OpenExisting opens the store selected by driver like Open, but without
+// applying any migrations. It fails unless the database exists and its schema
+// is at the latest version, so tools that only read it never change it.
+func OpenExisting(driver, source string) (Store, error) {
+	switch driver {
+	case "", "sqlite", "sqlite3":
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		db, err := openSQLiteDB(source)
+		if err != nil {
+			return nil, err
+		}
+		if err := newMigrator(db, sqliteMigrations, sqliteBind).CheckCurrent(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &SQLite{db: db}, nil
+	case "postgres", "postgresql":
+		db, err := openPostgresDB(source)
+		if err != nil {
+			return nil, err
+		}
+		if err := newMigrator(db, postgresMigrations, postgresBind).CheckCurrent(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &Postgres{db: db}, nil
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// OpenMigrator opens the database selected by driver for schema management
+// without applying any migrations
+func OpenMigrator(driver, source string) (*Migrator, error) {
+	switch driver {
+	case "", "sqlite", "sqlite3":
+		db, err := openSQLiteDB(source)
+		if err != nil {
+			return nil, err
+		}
+		return newMigrator(db, sqliteMigrations, sqliteBind), nil
+	case "postgres", "postgresql":
+		db, err := openPostgresDB(source)
+		if err != nil {
+			return nil, err
+		}
+		return newMigrator(db, postgresMigrations, postgresBind), nil
+	case "memory":
+		return nil, fmt.Errorf("the memory store has no schema to migrate")
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// trafficColumns lists the traffic_samples table columns in scan order
+const trafficColumns = `mac, resolution, start_time, seconds, active_seconds, rx_bytes, tx_bytes`
+
+// addTrafficQuery returns the statement adding a sample to the sample of its
+// device, resolution and start, creating it if there is none
+func addTrafficQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`INSERT INTO traffic_samples (%s)
+		VALUES (%s)
+		ON CONFLICT(mac, resolution, start_time) DO UPDATE SET
+			seconds = traffic_samples.seconds + excluded.seconds,
+			active_seconds = traffic_samples.active_seconds + excluded.active_seconds,
+			rx_bytes = traffic_samples.rx_bytes + excluded.rx_bytes,
+			tx_bytes = traffic_samples.tx_bytes + excluded.tx_bytes`, trafficColumns, bindList(bind, 1, 7))
+}
+
+// addTrafficArgs returns the arguments for addTrafficQuery. Times are stored
+// in UTC so they compare correctly as text in SQLite.
+func addTrafficArgs(sample *TrafficSample) []interface{} {
+	return []interface{}{
+		sample.MAC, sample.Resolution, sample.Start.UTC(),
+		sample.Seconds, sample.ActiveSeconds, sample.RxBytes, sample.TxBytes,
+	}
+}
+
+// listTrafficQuery builds the SELECT statement and arguments for a filter
+func listTrafficQuery(filter TrafficFilter, bind func(n int) string) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, bind(len(args))))
+	}
+
+	if filter.MAC != "" {
+		add("mac = %s", filter.MAC)
+	}
+	if filter.Resolution != "" {
+		add("resolution = %s", filter.Resolution)
+	}
+	if !filter.From.IsZero() {
+		add("start_time >= %s", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		add("start_time < %s", filter.To.UTC())
+	}
+
+	query := "SELECT " + trafficColumns + " FROM traffic_samples"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	return query + " ORDER BY start_time, mac, resolution", args
+}
+
+// deleteTrafficQuery returns the statement deleting the samples of a
+// resolution, optionally only those of a device, that start before a time
+func deleteTrafficQuery(mac string, bind func(n int) string) string {
+	query := fmt.Sprintf("DELETE FROM traffic_samples WHERE resolution = %s AND start_time < %s", bind(1), bind(2))
+	if mac != "" {
+		query += " AND mac = " + bind(3)
+	}
+	return query
+}
+
+// deleteTrafficArgs returns the arguments for deleteTrafficQuery
+func deleteTrafficArgs(mac, resolution string, before time.Time) []interface{} {
+	args := []interface{}{resolution, before.UTC()}
+	if mac != "" {
+		args = append(args, mac)
+	}
+	return args
+}
+
+// rollupTraffic deletes the samples of a device and resolution that start
+// before a time and adds rollups in their place, in one transaction
+func rollupTraffic(db *sql.DB, bind func(n int) string, mac, resolution string, before time.Time, rollups []*TrafficSample) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteTrafficQuery(mac, bind), deleteTrafficArgs(mac, resolution, before)...); err != nil {
+		return err
+	}
+	for _, rollup := range rollups {
+		if _, err := tx.Exec(addTrafficQuery(bind), addTrafficArgs(rollup)...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// scanTraffic reads traffic samples selected with trafficColumns
+func scanTraffic(rows *sql.Rows) ([]*TrafficSample, error) {
+	defer rows.Close()
+
+	var samples []*TrafficSample
+	for rows.Next() {
+		var sample TrafficSample
+		if err := rows.Scan(
+			&sample.MAC, &sample.Resolution, &sample.Start,
+			&sample.Seconds, &sample.ActiveSeconds, &sample.RxBytes, &sample.TxBytes,
+		); err != nil {
+			return nil, err
+		}
+		samples = append(samples, &sample)
+	}
+
+	return samples, rows.Err()
+}
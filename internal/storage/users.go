@@ -0,0 +1,33 @@
+package storage
+
+import "database/sql"
+
+// userColumns lists the users table columns in scan order
+const userColumns = `id, username, password_hash, role, oidc_issuer, oidc_subject, created_at, updated_at`
+
+// scanUsers reads users selected with userColumns
+func scanUsers(rows *sql.Rows) ([]*User, error) {
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.PasswordHash, &user.Role,
+			&user.OIDCIssuer, &user.OIDCSubject, &user.CreatedAt, &user.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// firstUser returns the only user of a lookup, nil if there is none
+func firstUser(users []*User, err error) (*User, error) {
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return users[0], nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// webhookColumns lists the webhooks table columns in scan order
+const webhookColumns = `id, url, secret, events, description, enabled, created_by, created_at, updated_at`
+
+// deliveryColumns lists the webhook_deliveries table columns in scan order
+const deliveryColumns = `id, webhook_id, event_type, payload, status, attempts, response_code, error, next_attempt_at, created_at, updated_at`
+
+// bindList returns the placeholders for n arguments starting at from
+func bindList(bind func(n int) string, from, n int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		placeholders[i] = bind(from + i)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// insertWebhookQuery returns the INSERT statement for a webhook, without the id
+func insertWebhookQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`INSERT INTO webhooks (url, secret, events, description, enabled, created_by, created_at, updated_at)
+		VALUES (%s)`, bindList(bind, 1, 8))
+}
+
+// insertWebhookArgs returns the arguments for insertWebhookQuery
+func insertWebhookArgs(webhook *Webhook) []interface{} {
+	return []interface{}{
+		webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","), webhook.Description,
+		webhook.Enabled, webhook.CreatedBy, webhook.CreatedAt.UTC(), webhook.UpdatedAt.UTC(),
+	}
+}
+
+// updateWebhookQuery returns the UPDATE statement for updateWebhookArgs
+func updateWebhookQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`UPDATE webhooks SET url = %s, secret = %s, events = %s, description = %s, enabled = %s, updated_at = %s
+		WHERE id = %s`, bind(1), bind(2), bind(3), bind(4), bind(5), bind(6), bind(7))
+}
+
+func updateWebhookArgs(webhook *Webhook) []interface{} {
+	return []interface{}{
+		webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","), webhook.Description,
+		webhook.Enabled, webhook.UpdatedAt.UTC(), webhook.ID,
+	}
+}
+
+// scanWebhooks reads webhooks selected with webhookColumns
+func scanWebhooks(rows *sql.Rows) ([]*Webhook, error) {
+	defer rows.Close()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		var webhook Webhook
+		var events string
+		if err := rows.Scan(
+			&webhook.ID, &webhook.URL, &webhook.Secret, &events, &webhook.Description,
+			&webhook.Enabled, &webhook.CreatedBy, &webhook.CreatedAt, &webhook.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		webhook.Events = splitEvents(events)
+		webhooks = append(webhooks, &webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// splitEvents parses the comma separated event types of a webhook
+func splitEvents(events string) []string {
+	if events == "" {
+		return []string{}
+	}
+	return strings.Split(events, ",")
+}
+
+// insertDeliveryQuery returns the INSERT statement for a delivery, without the id
+func insertDeliveryQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, attempts, response_code, error, next_attempt_at, created_at, updated_at)
+		VALUES (%s)`, bindList(bind, 1, 10))
+}
+
+// insertDeliveryArgs returns the arguments for insertDeliveryQuery. Times are
+// stored in UTC so they compare correctly as text in SQLite.
+func insertDeliveryArgs(delivery *WebhookDelivery) []interface{} {
+	return []interface{}{
+		delivery.WebhookID, delivery.EventType, delivery.Payload, delivery.Status, delivery.Attempts,
+		delivery.ResponseCode, delivery.Error, delivery.NextAttemptAt.UTC(),
+		delivery.CreatedAt.UTC(), delivery.UpdatedAt.UTC(),
+	}
+}
+
+// updateDeliveryQuery returns the UPDATE statement for updateDeliveryArgs
+func updateDeliveryQuery(bind func(n int) string) string {
+	return fmt.Sprintf(`UPDATE webhook_deliveries SET status = %s, attempts = %s, response_code = %s, error = %s,
+		next_attempt_at = %s, updated_at = %s WHERE id = %s`,
+		bind(1), bind(2), bind(3), bind(4), bind(5), bind(6), bind(7))
+}
+
+func updateDeliveryArgs(delivery *WebhookDelivery) []interface{} {
+	return []interface{}{
+		delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error,
+		delivery.NextAttemptAt.UTC(), delivery.UpdatedAt.UTC(), delivery.ID,
+	}
+}
+
+// listDeliveriesQuery selects the deliveries of a webhook, newest first
+func listDeliveriesQuery(bind func(n int) string) string {
+	return "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE webhook_id = " + bind(1) +
+		" ORDER BY id DESC LIMIT " + bind(2)
+}
+
+// dueDeliveriesQuery selects pending deliveries due at a time, oldest first
+func dueDeliveriesQuery(bind func(n int) string) string {
+	return "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE status = " + bind(1) +
+		" AND next_attempt_at <= " + bind(2) + " ORDER BY next_attempt_at, id LIMIT " + bind(3)
+}
+
+// deleteDeliveriesQuery deletes the finished deliveries last attempted before
+// a time, with the pending status and the time as arguments
+func deleteDeliveriesQuery(bind func(n int) string) string {
+	return "DELETE FROM webhook_deliveries WHERE status != " + bind(1) + " AND updated_at < " + bind(2)
+}
+
+// scanDeliveries reads deliveries selected with deliveryColumns
+func scanDeliveries(rows *sql.Rows) ([]*WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := rows.Scan(
+			&delivery.ID, &delivery.WebhookID, &delivery.EventType, &delivery.Payload, &delivery.Status,
+			&delivery.Attempts, &delivery.ResponseCode, &delivery.Error, &delivery.NextAttemptAt,
+			&delivery.CreatedAt, &delivery.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, rows.Err()
+}
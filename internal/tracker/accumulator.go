@@ -1,33 +1,77 @@
 package tracker
 
 import (
+	"sync"
 	"time"
 
+	"github.com/nadilas/zeitpolizei/internal/network"
 	"github.com/nadilas/zeitpolizei/internal/storage"
-	"github.com/nadilas/zeitpolizei/internal/unifi"
 )
 
-// ActivityThreshold is the minimum bytes to consider a device "active"
-const ActivityThreshold = 1024 // 1 KB
-
 // Accumulator tracks traffic accumulation for devices
 type Accumulator struct {
-	store             *storage.SQLite
-	pollIntervalSecs  int
+	store            storage.Store
+	pollIntervalSecs int
+	activity         storage.ActivityRules // Default rules
+
+	mu       sync.Mutex
+	samples  map[string][]ActivitySample // MAC -> recent samples, oldest first
+	counters map[string]counters         // MAC -> byte counters of the last poll
+}
+
+// counters are the byte counters of a client
+type counters struct {
+	rx, tx int64
 }
 
-// NewAccumulator creates a new Accumulator instance
-func NewAccumulator(store *storage.SQLite, pollInterval time.Duration) *Accumulator {
+// NewAccumulator creates a new Accumulator instance counting traffic as
+// active time by the activity rules unless a device has its own
+func NewAccumulator(store storage.Store, pollInterval time.Duration, activity storage.ActivityRules) *Accumulator {
 	return &Accumulator{
 		store:            store,
 		pollIntervalSecs: int(pollInterval.Seconds()),
+		activity:         activity,
+		samples:          make(map[string][]ActivitySample),
+		counters:         make(map[string]counters),
 	}
 }
 
-// ProcessClientStats processes client statistics and accumulates usage
-func (a *Accumulator) ProcessClientStats(mac string, client *unifi.ClientInfo, now time.Time, block *storage.TimeBlock, blockIndex int) error {
-	date := now.Format("2006-01-02")
+// Rules returns the activity rules of a device
+func (a *Accumulator) Rules(config *storage.DeviceConfig) storage.ActivityRules {
+	if config != nil && config.Activity != nil {
+		return *config.Activity
+	}
+	return a.activity
+}
+
+// Activity returns the recent samples of a device, oldest first
+func (a *Accumulator) Activity(mac string) []ActivitySample {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ActivitySample(nil), a.samples[mac]...)
+}
+
+// Sample classifies the traffic of a client since the previous poll by rules
+// and adds it to the device's samples. It returns nil on the first poll that
+// sees the client, which has nothing to compare the counters with.
+func (a *Accumulator) Sample(mac string, client *network.ClientInfo, now time.Time, rules storage.ActivityRules) *ActivitySample {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	last, ok := a.counters[mac]
+	a.counters[mac] = counters{rx: client.RxBytes, tx: client.TxBytes}
+	if !ok {
+		return nil
+	}
+
+	sample := a.record(mac, rules, now, counterDelta(client.RxBytes, last.rx), counterDelta(client.TxBytes, last.tx))
+	return &sample
+}
 
+// ProcessClientStats processes client statistics and accumulates usage in
+// the record of the time block that started on date, counting the time of
+// sample as active time if it was active
+func (a *Accumulator) ProcessClientStats(mac string, client *network.ClientInfo, now time.Time, date string, block *storage.TimeBlock, blockIndex int, sample *ActivitySample) error {
 	// Get or create usage record for this time block
 	usage, err := a.store.GetOrCreateBlockUsage(
 		mac,
@@ -42,47 +86,103 @@ func (a *Accumulator) ProcessClientStats(mac string, client *unifi.ClientInfo, n
 		return err
 	}
 
-	// Calculate traffic delta
-	currentTotal := client.TxBytes + client.RxBytes
-	lastTotal := usage.LastTxBytes + usage.LastRxBytes
+	// Count the time since the previous sample as active time if the
+	// traffic passed the activity rules
+	seconds := 0
+	if sample != nil && sample.Active {
+		seconds = sample.Seconds
+	}
 
-	var delta int64
-	if lastTotal == 0 {
-		// First poll for this block - just record current values
-		usage.LastTxBytes = client.TxBytes
-		usage.LastRxBytes = client.RxBytes
-		return a.store.UpdateBlockUsage(usage)
+	if usage.LastTxBytes+usage.LastRxBytes == 0 {
+		// First poll for this block: the record has no counters to compare
+		// with yet, so credit the sample since the previous poll, which
+		// belongs to the block just like its time, and record the counters
+		var bytes int64
+		if sample != nil {
+			bytes = sample.RxBytes + sample.TxBytes
+		}
+		return a.store.AddBlockUsage(usage.ID, seconds, bytes, client.TxBytes, client.RxBytes)
 	}
 
-	if currentTotal < lastTotal {
-		// Counter reset (client reconnected) - take full current value as delta
-		// This handles the case where a client disconnects and reconnects,
-		// causing the UniFi controller to reset the byte counters
-		delta = currentTotal
-	} else {
-		delta = currentTotal - lastTotal
+	// A counter below its last value was reset (client reconnected), so
+	// the full current value is the delta. This handles the case where a
+	// client disconnects and reconnects, causing the UniFi controller to
+	// reset the byte counters.
+	rxDelta := counterDelta(client.RxBytes, usage.LastRxBytes)
+	txDelta := counterDelta(client.TxBytes, usage.LastTxBytes)
+
+	// Add to the stored usage rather than writing the record back, so a
+	// bonus granted meanwhile is kept
+	return a.store.AddBlockUsage(usage.ID, seconds, rxDelta+txDelta, client.TxBytes, client.RxBytes)
+}
+
+// counterDelta returns the bytes transferred since a counter read last
+func counterDelta(current, last int64) int64 {
+	if current < last {
+		return current
 	}
+	return current - last
+}
 
-	// Update usage
-	usage.UsedBytes += delta
+// record classifies the traffic of a device since its previous sample by
+// rules and adds it to the device's samples; callers must hold the lock
+func (a *Accumulator) record(mac string, rules storage.ActivityRules, now time.Time, rxBytes, txBytes int64) ActivitySample {
+	history := a.samples[mac]
 
-	// Count active minutes if there was significant traffic
-	// We use the poll interval to determine how many "active minutes" to add
-	if delta > ActivityThreshold {
-		// Add the poll interval as active time (in minutes, rounded up)
-		activeMinutes := (a.pollIntervalSecs + 59) / 60
-		if activeMinutes < 1 {
-			activeMinutes = 1
+	// A sample covers the time since the previous one, or the poll interval
+	// after a gap so that missed polls or a restart cannot credit hours
+	seconds := a.pollIntervalSecs
+	if len(history) > 0 {
+		elapsed := int(now.Sub(history[len(history)-1].Time).Round(time.Second).Seconds())
+		if elapsed > 0 && elapsed <= 2*a.pollIntervalSecs {
+			seconds = elapsed
 		}
-		usage.UsedMinutes += activeMinutes
 	}
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	sample := ActivitySample{Time: now, Seconds: seconds, RxBytes: rxBytes, TxBytes: txBytes}
+	classify(&sample, rules, history)
+
+	keep := sampleWindow(rules)
+	drop := 0
+	for drop < len(history) && now.Sub(history[drop].Time) > keep {
+		drop++
+	}
+	a.samples[mac] = append(history[drop:], sample)
+
+	return sample
+}
+
+// sampleWindow is how long samples are kept for background detection by
+// rules and the activity log
+func sampleWindow(rules storage.ActivityRules) time.Duration {
+	keep := activityHistory
+	if window := backgroundWindow(rules); window > keep {
+		keep = window
+	}
+	return keep
+}
 
-	// Update last seen values
-	usage.LastTxBytes = client.TxBytes
-	usage.LastRxBytes = client.RxBytes
-	usage.LastUpdated = now
+// Prune forgets devices that are gone: the counters of clients missing from
+// a poll (seen), and the samples of devices that are no longer managed or
+// were idle for longer than their sample window
+func (a *Accumulator) Prune(configs map[string]*storage.DeviceConfig, seen map[string]bool, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	return a.store.UpdateBlockUsage(usage)
+	for mac := range a.counters {
+		if !seen[mac] {
+			delete(a.counters, mac)
+		}
+	}
+	for mac, history := range a.samples {
+		config, managed := configs[mac]
+		if !managed || len(history) == 0 || now.Sub(history[len(history)-1].Time) > sampleWindow(a.Rules(config)) {
+			delete(a.samples, mac)
+		}
+	}
 }
 
 // ResetForNewBlock resets tracking state for a new time block
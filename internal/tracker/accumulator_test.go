@@ -0,0 +1,171 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/network"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// interleavingStore runs a hook once right after the next usage record is
+// read, to interleave another writer with a read-modify-write
+type interleavingStore struct {
+	storage.Store
+	afterRead func()
+}
+
+func (s *interleavingStore) GetOrCreateBlockUsage(mac, date string, blockIndex int, startTime, endTime string, limitMinutes *int, limitBytes *int64) (*storage.BlockUsage, error) {
+	usage, err := s.Store.GetOrCreateBlockUsage(mac, date, blockIndex, startTime, endTime, limitMinutes, limitBytes)
+	if hook := s.afterRead; hook != nil {
+		s.afterRead = nil
+		hook()
+	}
+	return usage, err
+}
+
+// TestConcurrentUsageWriters interleaves polls with bonuses and enforcement
+// of the same time block; neither may undo the changes of the other
+func TestConcurrentUsageWriters(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	limit := 60
+	config := &storage.DeviceConfig{
+		MAC:     mac,
+		Enabled: true,
+		DailySchedules: []storage.DaySchedule{{
+			Days:       []string{"weekdays", "weekends"},
+			TimeBlocks: []storage.TimeBlock{{StartTime: "00:00", EndTime: "24:00", LimitMinutes: &limit}},
+		}},
+	}
+
+	store := storage.NewMemory()
+	if err := store.SaveDeviceConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	backend := network.NewMemory(network.ClientInfo{MAC: mac})
+	interleaved := &interleavingStore{Store: store}
+
+	// The tracker's writes race with a bonus from the API or MQTT, whose
+	// enforcement races with the next poll
+	enf := enforcer.New(store, backend, time.UTC)
+	polledEnf := enforcer.New(interleaved, backend, time.UTC)
+	acc := NewAccumulator(store, time.Second, storage.ActivityRules{})
+	polledAcc := NewAccumulator(interleaved, time.Second, storage.ActivityRules{})
+
+	now := time.Now()
+	block, index, date := enf.GetActiveTimeBlock(config, now)
+	if block == nil {
+		t.Fatal("no active time block")
+	}
+	poll := func(acc *Accumulator, rx int64) {
+		client := &network.ClientInfo{MAC: mac, RxBytes: rx}
+		if err := acc.ProcessClientStats(mac, client, now, date, block, index, &ActivitySample{Seconds: 30, Active: true}); err != nil {
+			t.Fatalf("ProcessClientStats: %v", err)
+		}
+	}
+
+	poll(acc, 1000) // Records the counters and the first 30 s
+
+	interleaved.afterRead = func() {
+		if err := enf.AddBonusTime(mac, 15, "mum"); err != nil {
+			t.Fatalf("AddBonusTime: %v", err)
+		}
+	}
+	poll(polledAcc, 3000)
+
+	interleaved.afterRead = func() { poll(acc, 4000) }
+	if err := polledEnf.CheckAndEnforce(mac, config, now); err != nil {
+		t.Fatalf("CheckAndEnforce: %v", err)
+	}
+
+	usage, err := store.GetOrCreateBlockUsage(mac, date, index, block.StartTime, block.EndTime, block.LimitMinutes, block.LimitBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.BonusMinutes != 15 {
+		t.Errorf("bonus = %d minutes, want 15", usage.BonusMinutes)
+	}
+	if usage.UsedSeconds != 90 || usage.UsedBytes != 3000 || usage.LastRxBytes != 4000 {
+		t.Errorf("used = %d s/%d bytes up to %d, want 90 s/3000 bytes up to 4000", usage.UsedSeconds, usage.UsedBytes, usage.LastRxBytes)
+	}
+}
+
+// TestFirstPollOfBlock credits the poll that moves a device into a new time
+// block, which has no counters to compare with yet, to that block
+func TestFirstPollOfBlock(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+
+	store := storage.NewMemory()
+	acc := NewAccumulator(store, 30*time.Second, storage.ActivityRules{MinBytes: 1})
+	rules := acc.Rules(nil)
+	morning := &storage.TimeBlock{StartTime: "08:00", EndTime: "12:00"}
+	afternoon := &storage.TimeBlock{StartTime: "12:00", EndTime: "18:00"}
+
+	start := time.Date(2024, 3, 4, 11, 59, 30, 0, time.UTC)
+	poll := func(now time.Time, block *storage.TimeBlock, index int, rx int64) {
+		client := &network.ClientInfo{MAC: mac, RxBytes: rx}
+		sample := acc.Sample(mac, client, now, rules)
+		if err := acc.ProcessClientStats(mac, client, now, "2024-03-04", block, index, sample); err != nil {
+			t.Fatalf("ProcessClientStats: %v", err)
+		}
+	}
+	used := func(block *storage.TimeBlock, index int) *storage.BlockUsage {
+		usage, err := store.GetOrCreateBlockUsage(mac, "2024-03-04", index, block.StartTime, block.EndTime, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return usage
+	}
+
+	poll(start, morning, 0, 10000)                       // First sight of the client, nothing to compare with
+	poll(start.Add(30*time.Second), afternoon, 1, 12000) // First poll of the afternoon
+	poll(start.Add(time.Minute), afternoon, 1, 15000)
+
+	if usage := used(morning, 0); usage.UsedSeconds != 0 || usage.UsedBytes != 0 {
+		t.Errorf("morning used %d s/%d bytes, want nothing", usage.UsedSeconds, usage.UsedBytes)
+	}
+	if usage := used(afternoon, 1); usage.UsedSeconds != 60 || usage.UsedBytes != 5000 || usage.LastRxBytes != 15000 {
+		t.Errorf("afternoon used %d s/%d bytes up to %d, want 60 s/5000 bytes up to 15000", usage.UsedSeconds, usage.UsedBytes, usage.LastRxBytes)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	const online, offline, removed = "aa:00:00:00:00:01", "aa:00:00:00:00:02", "aa:00:00:00:00:03"
+
+	acc := NewAccumulator(storage.NewMemory(), 30*time.Second, storage.ActivityRules{})
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		now := start.Add(time.Duration(i) * 30 * time.Second)
+		for _, mac := range []string{online, offline, removed} {
+			acc.Sample(mac, &network.ClientInfo{MAC: mac, RxBytes: int64(i) * 1000}, now, storage.ActivityRules{})
+		}
+	}
+
+	configs := map[string]*storage.DeviceConfig{online: {MAC: online}, offline: {MAC: offline}}
+	now := start.Add(time.Minute)
+	acc.Prune(configs, map[string]bool{online: true}, now)
+
+	if _, ok := acc.counters[offline]; ok {
+		t.Error("counters of an offline client were kept")
+	}
+	if _, ok := acc.counters[online]; !ok {
+		t.Error("counters of an online client were dropped")
+	}
+	if len(acc.Activity(removed)) != 0 {
+		t.Error("samples of a removed device were kept")
+	}
+	if len(acc.Activity(offline)) == 0 {
+		t.Error("recent samples of an offline device were dropped")
+	}
+
+	// Samples of idle devices go once they are older than the sample window
+	acc.Prune(configs, map[string]bool{online: true}, now.Add(sampleWindow(storage.ActivityRules{})+time.Minute))
+	if len(acc.Activity(offline)) != 0 || len(acc.Activity(online)) != 0 {
+		t.Error("samples older than the sample window were kept")
+	}
+	if len(acc.samples) != 0 {
+		t.Errorf("%d devices have samples, want none", len(acc.samples))
+	}
+}
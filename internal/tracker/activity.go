@@ -0,0 +1,84 @@
+package tracker
+
+import (
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// Reasons recorded for whether a poll interval counted as active time
+const (
+	ActivityActive     = "active"
+	ActivityNoTraffic  = "no_traffic"
+	ActivityBelowMin   = "below_min_bytes"
+	ActivityBelowMinRx = "below_min_rx_bytes"
+	ActivityBelowMinTx = "below_min_tx_bytes"
+	ActivityBackground = "background"
+)
+
+const (
+	// defaultBackgroundWindow applies to background detection without a window
+	defaultBackgroundWindow = 10 * time.Minute
+
+	// activityHistory is how long the samples of a device are kept, at least
+	activityHistory = time.Hour
+)
+
+// ActivitySample is the traffic of a device in one poll interval and whether
+// it counted as active time
+type ActivitySample struct {
+	Time    time.Time `json:"time"`
+	Seconds int       `json:"seconds"`
+	RxBytes int64     `json:"rx_bytes"`
+	TxBytes int64     `json:"tx_bytes"`
+	Active  bool      `json:"active"`
+	Reason  string    `json:"reason"`
+}
+
+// perMinute converts bytes transferred in the sample's interval to a rate
+func (s *ActivitySample) perMinute(bytes int64) int64 {
+	return bytes * 60 / int64(s.Seconds)
+}
+
+// classify decides whether sample counts as active time under rules and
+// records why. history holds the device's earlier samples, oldest first.
+func classify(sample *ActivitySample, rules storage.ActivityRules, history []ActivitySample) {
+	total := sample.perMinute(sample.RxBytes + sample.TxBytes)
+
+	switch {
+	case sample.RxBytes+sample.TxBytes == 0:
+		sample.Reason = ActivityNoTraffic
+	case total < rules.MinBytes:
+		sample.Reason = ActivityBelowMin
+	case sample.perMinute(sample.RxBytes) < rules.MinRxBytes:
+		sample.Reason = ActivityBelowMinRx
+	case sample.perMinute(sample.TxBytes) < rules.MinTxBytes:
+		sample.Reason = ActivityBelowMinTx
+	case rules.BackgroundMaxBytes > 0 && total <= rules.BackgroundMaxBytes &&
+		!busySince(history, sample.Time.Add(-backgroundWindow(rules)), rules.BackgroundMaxBytes):
+		sample.Reason = ActivityBackground
+	default:
+		sample.Active = true
+		sample.Reason = ActivityActive
+	}
+}
+
+// busySince reports whether a sample after since had a rate above maxBytes
+func busySince(history []ActivitySample, since time.Time, maxBytes int64) bool {
+	for i := len(history) - 1; i >= 0 && history[i].Time.After(since); i-- {
+		sample := &history[i]
+		if sample.perMinute(sample.RxBytes+sample.TxBytes) > maxBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// backgroundWindow returns how far back background detection looks for
+// traffic above the background rate
+func backgroundWindow(rules storage.ActivityRules) time.Duration {
+	if rules.BackgroundWindowMinutes <= 0 {
+		return defaultBackgroundWindow
+	}
+	return time.Duration(rules.BackgroundWindowMinutes) * time.Minute
+}
@@ -0,0 +1,61 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/bus"
+	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// MessageUsage is the bus message type of usage updates
+const MessageUsage = "usage"
+
+// PublishUsage returns a poll handler that publishes the usage summary of
+// every managed device whose usage changed since the previous poll
+func PublishUsage(store storage.Store, enf *enforcer.Enforcer, b *bus.Bus) PollHandler {
+	last := make(map[string][]byte)
+
+	return func(now time.Time) {
+		configs, err := store.GetAllDeviceConfigs()
+		if err != nil {
+			log.Printf("Error getting device configs: %v", err)
+			return
+		}
+
+		seen := make(map[string]bool)
+		for _, config := range configs {
+			if !config.Enabled {
+				continue
+			}
+			mac := strings.ToLower(config.MAC)
+			seen[mac] = true
+
+			summary, err := enf.UsageSummary(config, now)
+			if err != nil {
+				log.Printf("Error getting usage for %s: %v", mac, err)
+				continue
+			}
+			data, err := json.Marshal(summary)
+			if err != nil {
+				continue
+			}
+			if bytes.Equal(last[mac], data) {
+				continue
+			}
+
+			last[mac] = data
+			b.Publish(MessageUsage, json.RawMessage(data))
+		}
+
+		for mac := range last {
+			if !seen[mac] {
+				delete(last, mac)
+			}
+		}
+	}
+}
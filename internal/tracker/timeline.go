@@ -0,0 +1,146 @@
+package tracker
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// rollupInterval is how often traffic samples past their retention are
+// rolled up
+const rollupInterval = time.Hour
+
+// trafficSample returns the minute sample of an activity sample
+func trafficSample(mac string, sample *ActivitySample) *storage.TrafficSample {
+	traffic := &storage.TrafficSample{
+		MAC:        mac,
+		Resolution: storage.ResolutionMinute,
+		Start:      sample.Time.Truncate(time.Minute),
+		Seconds:    sample.Seconds,
+		RxBytes:    sample.RxBytes,
+		TxBytes:    sample.TxBytes,
+	}
+	if sample.Active {
+		traffic.ActiveSeconds = sample.Seconds
+	}
+	return traffic
+}
+
+// BucketStart returns the start of the minute, hour or day of t in loc
+func BucketStart(t time.Time, resolution string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch resolution {
+	case storage.ResolutionHour:
+		// Subtracting keeps the right hour when the clocks go back
+		return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	case storage.ResolutionDay:
+		year, month, day := t.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	default:
+		return t.Truncate(time.Minute)
+	}
+}
+
+// finer reports whether resolution a is finer than b
+func finer(a, b string) bool {
+	rank := func(resolution string) int {
+		for i, r := range storage.Resolutions {
+			if r == resolution {
+				return i
+			}
+		}
+		return len(storage.Resolutions)
+	}
+	return rank(a) < rank(b)
+}
+
+// Downsample adds samples up into buckets of a resolution in loc, ordered by
+// start. Samples of a coarser resolution are kept as they are.
+func Downsample(samples []*storage.TrafficSample, resolution string, loc *time.Location) []*storage.TrafficSample {
+	type key struct {
+		mac        string
+		resolution string
+		start      int64
+	}
+	buckets := make(map[key]*storage.TrafficSample)
+	var result []*storage.TrafficSample
+
+	for _, sample := range samples {
+		bucket := *sample
+		bucket.Start = sample.Start.In(loc)
+		if finer(sample.Resolution, resolution) {
+			bucket.Resolution = resolution
+			bucket.Start = BucketStart(sample.Start, resolution, loc)
+		}
+
+		k := key{bucket.MAC, bucket.Resolution, bucket.Start.UnixNano()}
+		if existing, ok := buckets[k]; ok {
+			existing.Add(&bucket)
+			continue
+		}
+		buckets[k] = &bucket
+		result = append(result, &bucket)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Start.Before(result[j].Start) })
+	return result
+}
+
+// rollup rolls minute samples past their retention up into hours and hourly
+// samples into days, in the time zone of their device, and deletes daily
+// samples past theirs
+func (t *Tracker) rollup(now time.Time) {
+	t.rollupResolution(now, storage.ResolutionMinute, storage.ResolutionHour, t.retention.MinuteDays)
+	t.rollupResolution(now, storage.ResolutionHour, storage.ResolutionDay, t.retention.HourDays)
+
+	if t.retention.DayDays > 0 {
+		if err := t.store.DeleteTrafficSamples(storage.ResolutionDay, now.AddDate(0, 0, -t.retention.DayDays)); err != nil {
+			log.Printf("Error deleting daily traffic samples: %v", err)
+		}
+	}
+}
+
+// rollupResolution rolls the samples of a resolution that are older than
+// days up into the next coarser one. Only whole hours or days are rolled up,
+// so a bucket is never split between resolutions.
+func (t *Tracker) rollupResolution(now time.Time, from, to string, days int) {
+	samples, err := t.store.ListTrafficSamples(storage.TrafficFilter{
+		Resolution: from,
+		To:         now.AddDate(0, 0, -days),
+	})
+	if err != nil {
+		log.Printf("Error listing %s traffic samples: %v", from, err)
+		return
+	}
+
+	byDevice := make(map[string][]*storage.TrafficSample)
+	for _, sample := range samples {
+		byDevice[sample.MAC] = append(byDevice[sample.MAC], sample)
+	}
+
+	for mac, samples := range byDevice {
+		config, err := t.store.GetDeviceConfig(mac)
+		if err != nil {
+			log.Printf("Error getting device config for %s: %v", mac, err)
+			continue
+		}
+		loc := t.enforcer.Location(config)
+		before := BucketStart(now.AddDate(0, 0, -days), to, loc)
+
+		var due []*storage.TrafficSample
+		for _, sample := range samples {
+			if sample.Start.Before(before) {
+				due = append(due, sample)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		if err := t.store.RollupTrafficSamples(mac, from, before, Downsample(due, to, loc)); err != nil {
+			log.Printf("Error rolling up %s traffic samples of %s: %v", from, mac, err)
+		}
+	}
+}
@@ -6,40 +6,71 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nadilas/zeitpolizei/internal/config"
 	"github.com/nadilas/zeitpolizei/internal/enforcer"
+	"github.com/nadilas/zeitpolizei/internal/network"
 	"github.com/nadilas/zeitpolizei/internal/storage"
-	"github.com/nadilas/zeitpolizei/internal/unifi"
 )
 
-// Tracker handles polling UniFi for client stats and tracking usage
+// Tracker handles polling the network backend for client stats and tracking usage
 type Tracker struct {
-	store        *storage.SQLite
-	unifi        *unifi.Client
+	store        storage.Store
+	network      network.Backend
 	enforcer     *enforcer.Enforcer
 	pollInterval time.Duration
+	retention    config.RetentionConfig
 	accumulator  *Accumulator
+	handlers     []PollHandler
 }
 
-// New creates a new Tracker instance
-func New(store *storage.SQLite, unifiClient *unifi.Client, enf *enforcer.Enforcer, pollInterval time.Duration) *Tracker {
+// PollHandler is called after every poll that enforced the managed devices.
+// Handlers run on the tracker loop, so they must not block.
+type PollHandler func(now time.Time)
+
+// New creates a new Tracker instance counting traffic as active time by the
+// activity rules unless a device has its own, and keeping traffic samples
+// for the retention
+func New(store storage.Store, backend network.Backend, enf *enforcer.Enforcer, pollInterval time.Duration, activity storage.ActivityRules, retention config.RetentionConfig) *Tracker {
 	return &Tracker{
 		store:        store,
-		unifi:        unifiClient,
+		network:      backend,
 		enforcer:     enf,
 		pollInterval: pollInterval,
-		accumulator:  NewAccumulator(store, pollInterval),
+		retention:    retention,
+		accumulator:  NewAccumulator(store, pollInterval, activity),
 	}
 }
 
+// ActivityRules returns the rules the traffic of a device is counted as
+// active time by
+func (t *Tracker) ActivityRules(config *storage.DeviceConfig) storage.ActivityRules {
+	return t.accumulator.Rules(config)
+}
+
+// Activity returns the recent poll intervals of a device with whether they
+// counted as active time and why, oldest first
+func (t *Tracker) Activity(mac string) []ActivitySample {
+	return t.accumulator.Activity(mac)
+}
+
+// AddHandler registers a handler for completed polls. Handlers must be added
+// before Start.
+func (t *Tracker) AddHandler(handler PollHandler) {
+	t.handlers = append(t.handlers, handler)
+}
+
 // Start begins the tracking loop
 func (t *Tracker) Start(ctx context.Context) {
 	log.Printf("Starting tracker with %v poll interval", t.pollInterval)
 
 	ticker := time.NewTicker(t.pollInterval)
 	defer ticker.Stop()
+	rollupTicker := time.NewTicker(rollupInterval)
+	defer rollupTicker.Stop()
 
 	// Run immediately on start
 	t.poll()
+	t.rollup(time.Now())
 
 	for {
 		select {
@@ -48,6 +79,8 @@ func (t *Tracker) Start(ctx context.Context) {
 			return
 		case <-ticker.C:
 			t.poll()
+		case <-rollupTicker.C:
+			t.rollup(time.Now())
 		}
 	}
 }
@@ -62,6 +95,7 @@ func (t *Tracker) poll() {
 	}
 
 	if len(configs) == 0 {
+		t.accumulator.Prune(nil, nil, time.Now())
 		return // No managed devices
 	}
 
@@ -73,56 +107,59 @@ func (t *Tracker) poll() {
 		}
 	}
 
-	// Get current client stats from UniFi
-	clients, err := t.unifi.GetClients()
+	// Get current client stats from the network backend
+	clients, err := t.network.GetClients()
 	if err != nil {
-		log.Printf("Error getting clients from UniFi: %v", err)
+		log.Printf("Error getting clients from network backend: %v", err)
 		return
 	}
 
 	now := time.Now()
+	seen := make(map[string]bool)
 
-	// Process each connected client that we're managing
+	// Accumulate traffic for each connected client that we're managing
 	for _, client := range clients {
 		mac := strings.ToLower(client.MAC)
 		config, managed := managedMACs[mac]
 		if !managed {
 			continue
 		}
+		seen[mac] = true
 
-		// Get the active time block for this device
-		activeBlock, blockIndex := t.enforcer.GetActiveTimeBlock(config, now)
+		// Traffic is sampled for the timeline all day
+		sample := t.accumulator.Sample(mac, &client, now, t.accumulator.Rules(config))
+		if sample != nil {
+			if err := t.store.AddTrafficSample(trafficSample(mac, sample)); err != nil {
+				log.Printf("Error saving traffic sample for %s: %v", mac, err)
+			}
+		}
 
+		// Usage is only tracked inside time blocks
+		activeBlock, blockIndex, date := t.enforcer.GetActiveTimeBlock(config, now)
 		if activeBlock == nil {
-			// No active time block - check if we need to block
-			if config.BlockOutside {
-				if err := t.enforcer.BlockDevice(mac, "outside_hours"); err != nil {
-					log.Printf("Error blocking device %s: %v", mac, err)
-				}
-			}
 			continue
 		}
 
-		// Accumulate traffic for this time block
-		if err := t.accumulator.ProcessClientStats(mac, &client, now, activeBlock, blockIndex); err != nil {
+		if err := t.accumulator.ProcessClientStats(mac, &client, now, date, activeBlock, blockIndex, sample); err != nil {
 			log.Printf("Error accumulating stats for %s: %v", mac, err)
-			continue
 		}
+	}
+	t.accumulator.Prune(managedMACs, seen, now)
 
-		// Check limits and enforce
+	// Enforce every managed device, including ones that are currently offline,
+	// so block boundaries unblock (or block) them even without traffic
+	for mac, config := range managedMACs {
 		if err := t.enforcer.CheckAndEnforce(mac, config, now); err != nil {
 			log.Printf("Error enforcing limits for %s: %v", mac, err)
 		}
 	}
 
-	// Check for devices that should be blocked because they're outside time blocks
-	// (even if they're not currently connected)
-	for mac, config := range managedMACs {
-		activeBlock, _ := t.enforcer.GetActiveTimeBlock(config, now)
-		if activeBlock == nil && config.BlockOutside {
-			if err := t.enforcer.BlockDevice(mac, "outside_hours"); err != nil {
-				log.Printf("Error blocking device %s: %v", mac, err)
-			}
-		}
+	// Correct any drift between our state and what the network backend has blocked
+	if err := t.enforcer.Reconcile(now); err != nil {
+		log.Printf("Error reconciling block state: %v", err)
+	}
+
+	for _, handler := range t.handlers {
+		handler(now)
 	}
 }
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/network"
 )
 
 // Config holds UniFi controller configuration
@@ -31,20 +33,11 @@ type Client struct {
 	mu         sync.RWMutex
 }
 
-// Client represents a network client from UniFi
-type ClientInfo struct {
-	MAC       string `json:"mac"`
-	Name      string `json:"name,omitempty"`
-	Hostname  string `json:"hostname,omitempty"`
-	IP        string `json:"ip,omitempty"`
-	TxBytes   int64  `json:"tx_bytes"`
-	RxBytes   int64  `json:"rx_bytes"`
-	Blocked   bool   `json:"blocked"`
-	IsWired   bool   `json:"is_wired"`
-	LastSeen  int64  `json:"last_seen"`
-	Uptime    int64  `json:"uptime"`
-	AssocTime int64  `json:"assoc_time"`
-}
+// ClientInfo represents a network client from UniFi
+type ClientInfo = network.ClientInfo
+
+// Client implements the network backend for UniFi controllers
+var _ network.Backend = (*Client)(nil)
 
 // NewClient creates a new UniFi API client
 func NewClient(cfg Config) (*Client, error) {
@@ -0,0 +1,281 @@
+// Package webhook delivers events as signed, versioned JSON payloads to the
+// URLs subscribed through /api/v1/webhooks. Deliveries are stored, so failed
+// ones are retried with backoff across restarts and can be inspected.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+// PayloadVersion is incremented on incompatible payload changes
+const PayloadVersion = 1
+
+// EventPing is the type of test deliveries sent from the API
+const EventPing = "ping"
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-Zeitpolizei-Event"
+	HeaderDelivery  = "X-Zeitpolizei-Delivery"
+	HeaderTimestamp = "X-Zeitpolizei-Timestamp"
+	HeaderSignature = "X-Zeitpolizei-Signature"
+)
+
+// Retry policy: the delay doubles after every failed attempt
+const (
+	maxAttempts  = 6
+	firstBackoff = 30 * time.Second
+	maxBackoff   = time.Hour
+)
+
+const (
+	// pollInterval is how often due retries are picked up
+	pollInterval = 5 * time.Second
+	// batchSize bounds the deliveries attempted per poll
+	batchSize = 20
+	// queueSize bounds the events waiting to be turned into deliveries
+	queueSize = 100
+	// pruneInterval is how often deliveries past their retention are deleted
+	pruneInterval = time.Hour
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	Version int            `json:"version"`
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Event   *storage.Event `json:"event"`
+}
+
+// Dispatcher turns events into deliveries and sends them
+type Dispatcher struct {
+	store         storage.Store
+	client        *http.Client
+	events        chan *storage.Event
+	retentionDays int // Days finished deliveries are kept, 0 = forever
+}
+
+// New creates a dispatcher that keeps finished deliveries for retentionDays,
+// or forever when it is 0
+func New(store storage.Store, retentionDays int) *Dispatcher {
+	return &Dispatcher{
+		store:         store,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		events:        make(chan *storage.Event, queueSize),
+		retentionDays: retentionDays,
+	}
+}
+
+// Handle queues an event for the subscribed webhooks. It never blocks, so it
+// can be registered with Enforcer.AddHandler.
+func (d *Dispatcher) Handle(event *storage.Event) {
+	copied := *event
+	select {
+	case d.events <- &copied:
+	default:
+		log.Printf("Webhook queue is full, dropping %s event for %s", event.Type, event.MAC)
+	}
+}
+
+// Start creates and sends deliveries until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	d.prune(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-d.events:
+			d.enqueue(event)
+			d.deliverDue(ctx)
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		case <-pruneTicker.C:
+			d.prune(time.Now())
+		}
+	}
+}
+
+// prune deletes the finished deliveries past their retention
+func (d *Dispatcher) prune(now time.Time) {
+	if d.retentionDays <= 0 {
+		return
+	}
+	if err := d.store.DeleteWebhookDeliveries(now.AddDate(0, 0, -d.retentionDays)); err != nil {
+		log.Printf("Error deleting old webhook deliveries: %v", err)
+	}
+}
+
+// enqueue creates a delivery of event for every subscribed webhook
+func (d *Dispatcher) enqueue(event *storage.Event) {
+	webhooks, err := d.store.ListWebhooks()
+	if err != nil {
+		log.Printf("Error listing webhooks: %v", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !Subscribed(webhook, event.Type) {
+			continue
+		}
+		if _, err := Enqueue(d.store, webhook, event.Type, event); err != nil {
+			log.Printf("Error queueing %s delivery for webhook %d: %v", event.Type, webhook.ID, err)
+		}
+	}
+}
+
+// Subscribed reports whether an enabled webhook receives events of a type
+func Subscribed(webhook *storage.Webhook, eventType string) bool {
+	if !webhook.Enabled {
+		return false
+	}
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, t := range webhook.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue stores a pending delivery of event to webhook, to be sent by the
+// running dispatcher
+func Enqueue(store storage.Store, webhook *storage.Webhook, eventType string, event *storage.Event) (*storage.WebhookDelivery, error) {
+	body, err := json.Marshal(Payload{
+		Version: PayloadVersion,
+		Type:    eventType,
+		Time:    time.Now().UTC(),
+		Event:   event,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &storage.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		EventType:     eventType,
+		Payload:       string(body),
+		Status:        storage.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := store.CreateWebhookDelivery(delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// deliverDue attempts all pending deliveries that are due
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	deliveries, err := d.store.ListDueWebhookDeliveries(time.Now(), batchSize)
+	if err != nil {
+		log.Printf("Error listing webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := d.attempt(ctx, delivery); err != nil {
+			log.Printf("Error recording webhook delivery %d: %v", delivery.ID, err)
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome, scheduling a retry
+// on failure until the attempts are used up
+func (d *Dispatcher) attempt(ctx context.Context, delivery *storage.WebhookDelivery) error {
+	webhook, err := d.store.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	if webhook == nil || !webhook.Enabled {
+		delivery.Status = storage.DeliveryFailed
+		delivery.Error = "webhook is disabled"
+		return d.store.UpdateWebhookDelivery(delivery)
+	}
+
+	delivery.ResponseCode, err = d.send(ctx, webhook, delivery)
+	if err == nil {
+		delivery.Status = storage.DeliverySucceeded
+		delivery.Error = ""
+		return d.store.UpdateWebhookDelivery(delivery)
+	}
+
+	delivery.Error = err.Error()
+	if delivery.Attempts >= maxAttempts {
+		delivery.Status = storage.DeliveryFailed
+		log.Printf("Webhook %d delivery %d failed after %d attempts: %v", webhook.ID, delivery.ID, delivery.Attempts, err)
+	} else {
+		delivery.NextAttemptAt = time.Now().Add(backoff(delivery.Attempts))
+	}
+	return d.store.UpdateWebhookDelivery(delivery)
+}
+
+// backoff returns the delay after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	delay := firstBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// send posts a delivery and returns the response status
+func (d *Dispatcher) send(ctx context.Context, webhook *storage.Webhook, delivery *storage.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Zeitpolizei-Webhook/%d", PayloadVersion))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value for a payload: the hex encoded
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,283 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nadilas/zeitpolizei/internal/storage"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"version":1,"type":"block"}`)
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("s3cret", 1700000000, body); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other", 1700000000, body) == want || Sign("s3cret", 1700000001, body) == want {
+		t.Error("signature does not depend on the secret and timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute}
+	for i, delay := range want {
+		if got := backoff(i + 1); got != delay {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, delay)
+		}
+	}
+	if got := backoff(20); got != maxBackoff {
+		t.Errorf("backoff(20) = %v, want %v", got, maxBackoff)
+	}
+}
+
+// receiver is a webhook endpoint answering with the queued status codes, 200
+// once they are used up, and recording the requests it got
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// attemptAll attempts every pending delivery once, whether due or not
+func attemptAll(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	deliveries, err := d.store.ListDueWebhookDeliveries(time.Now().Add(24*time.Hour), batchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delivery := range deliveries {
+		if err := d.attempt(context.Background(), delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// lastDelivery returns the newest delivery of a webhook from the log
+func lastDelivery(t *testing.T, store storage.Store, webhookID int64) *storage.WebhookDelivery {
+	t.Helper()
+	log, err := store.ListWebhookDeliveries(webhookID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 {
+		t.Fatalf("delivery log has %d entries, want 1", len(log))
+	}
+	return log[0]
+}
+
+func TestDelivery(t *testing.T) {
+	r := newReceiver(t)
+	store := storage.NewMemory()
+	hook := &storage.Webhook{URL: r.URL, Secret: "s3cret", Enabled: true}
+	if err := store.CreateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	d := New(store, 30)
+
+	event := &storage.Event{Type: storage.EventBlock, MAC: "aa:bb:cc:dd:ee:ff", Reason: "time_limit"}
+	delivery, err := Enqueue(store, hook, event.Type, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue(context.Background())
+
+	if len(r.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(r.requests))
+	}
+	req, body := r.requests[0], r.bodies[0]
+	if req.Header.Get(HeaderEvent) != storage.EventBlock || req.Header.Get(HeaderDelivery) != strconv.FormatInt(delivery.ID, 10) ||
+		req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp header: %v", err)
+	}
+	if got := req.Header.Get(HeaderSignature); got != Sign("s3cret", timestamp, body) {
+		t.Errorf("signature = %s, want the signature of the body", got)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Version != PayloadVersion || payload.Type != storage.EventBlock || payload.Event == nil ||
+		payload.Event.MAC != event.MAC || payload.Event.Reason != event.Reason {
+		t.Errorf("payload = %s", body)
+	}
+
+	got := lastDelivery(t, store, hook.ID)
+	if got.Status != storage.DeliverySucceeded || got.Attempts != 1 || got.ResponseCode != http.StatusOK || got.Error != "" {
+		t.Errorf("delivery = %+v, want succeeded after 1 attempt", got)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	r := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	store := storage.NewMemory()
+	hook := &storage.Webhook{URL: r.URL, Secret: "s3cret", Enabled: true}
+	if err := store.CreateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	d := New(store, 30)
+
+	if _, err := Enqueue(store, hook, EventPing, &storage.Event{Type: EventPing}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed attempt is recorded and retried after the first backoff
+	before := time.Now()
+	attemptAll(t, d)
+	got := lastDelivery(t, store, hook.ID)
+	if got.Status != storage.DeliveryPending || got.Attempts != 1 || got.ResponseCode != http.StatusInternalServerError || got.Error == "" {
+		t.Errorf("delivery after a failure = %+v, want pending with the error", got)
+	}
+	if got.NextAttemptAt.Before(before.Add(firstBackoff)) || got.NextAttemptAt.After(time.Now().Add(firstBackoff)) {
+		t.Errorf("next attempt at %v, want %v after the attempt", got.NextAttemptAt, firstBackoff)
+	}
+
+	// Retries are not sent before they are due
+	d.deliverDue(context.Background())
+	if len(r.requests) != 1 {
+		t.Fatalf("receiver got %d requests before the retry was due, want 1", len(r.requests))
+	}
+
+	attemptAll(t, d)
+	if got := lastDelivery(t, store, hook.ID); got.Attempts != 2 || got.ResponseCode != http.StatusBadGateway ||
+		got.NextAttemptAt.Sub(time.Now()) <= firstBackoff {
+		t.Errorf("delivery after a second failure = %+v, want the backoff doubled", got)
+	}
+
+	attemptAll(t, d)
+	if got := lastDelivery(t, store, hook.ID); got.Status != storage.DeliverySucceeded || got.Attempts != 3 ||
+		got.ResponseCode != http.StatusOK || got.Error != "" {
+		t.Errorf("delivery after the retry = %+v, want succeeded after 3 attempts", got)
+	}
+}
+
+func TestDeliveryGivesUp(t *testing.T) {
+	statuses := make([]int, maxAttempts)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	r := newReceiver(t, statuses...)
+	store := storage.NewMemory()
+	hook := &storage.Webhook{URL: r.URL, Secret: "s3cret", Enabled: true}
+	if err := store.CreateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	d := New(store, 30)
+
+	if _, err := Enqueue(store, hook, EventPing, &storage.Event{Type: EventPing}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxAttempts+2; i++ {
+		attemptAll(t, d)
+	}
+
+	if len(r.requests) != maxAttempts {
+		t.Errorf("receiver got %d requests, want %d", len(r.requests), maxAttempts)
+	}
+	if got := lastDelivery(t, store, hook.ID); got.Status != storage.DeliveryFailed || got.Attempts != maxAttempts ||
+		got.ResponseCode != http.StatusServiceUnavailable {
+		t.Errorf("delivery = %+v, want failed after %d attempts", got, maxAttempts)
+	}
+
+	// Deliveries of a disabled webhook fail without a request
+	hook.Enabled = false
+	if err := store.UpdateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enqueue(store, hook, EventPing, &storage.Event{Type: EventPing}); err != nil {
+		t.Fatal(err)
+	}
+	attemptAll(t, d)
+	if len(r.requests) != maxAttempts {
+		t.Errorf("receiver got a request for a disabled webhook")
+	}
+	if got := lastDelivery(t, store, hook.ID); got.Status != storage.DeliveryFailed || got.Error != "webhook is disabled" {
+		t.Errorf("delivery to a disabled webhook = %+v", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	r := newReceiver(t)
+	store := storage.NewMemory()
+	hook := &storage.Webhook{URL: r.URL, Secret: "s3cret", Enabled: true}
+	if err := store.CreateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, eventType := range []string{storage.EventBlock, storage.EventUnblock} {
+		if _, err := Enqueue(store, hook, eventType, &storage.Event{Type: eventType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := New(store, 30)
+	d.deliverDue(context.Background())
+	if _, err := Enqueue(store, hook, EventPing, &storage.Event{Type: EventPing}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		log, err := store.ListWebhookDeliveries(hook.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(log)
+	}
+
+	// Deliveries are kept for the retention period
+	d.prune(time.Now().AddDate(0, 0, 29))
+	if n := count(); n != 3 {
+		t.Errorf("delivery log has %d entries within the retention, want 3", n)
+	}
+
+	// A retention of 0 keeps them forever
+	New(store, 0).prune(time.Now().AddDate(1, 0, 0))
+	if n := count(); n != 3 {
+		t.Errorf("delivery log has %d entries without retention, want 3", n)
+	}
+
+	// Only the pending delivery survives past the retention period
+	d.prune(time.Now().AddDate(0, 0, 31))
+	if got := lastDelivery(t, store, hook.ID); got.EventType != EventPing || got.Status != storage.DeliveryPending {
+		t.Errorf("delivery left after pruning = %+v, want the pending ping", got)
+	}
+}
@@ -8,9 +8,44 @@ function getAuthHeaders() {
   }
 }
 
+export function storeTokens(tokens) {
+  localStorage.setItem('token', tokens.token)
+  localStorage.setItem('refresh_token', tokens.refresh_token)
+}
+
+export function clearTokens() {
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+}
+
+async function refreshTokens() {
+  const refreshToken = localStorage.getItem('refresh_token')
+  if (!refreshToken) return false
+
+  const response = await fetch(`${API_BASE}/auth/refresh`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ refresh_token: refreshToken })
+  })
+  if (!response.ok) return false
+
+  storeTokens(await response.json())
+  return true
+}
+
+// authFetch sends an authenticated request, renewing an expired access token once
+async function authFetch(url, options = {}) {
+  const headers = () => ({ ...getAuthHeaders(), ...options.headers })
+  let response = await fetch(url, { ...options, headers: headers() })
+  if (response.status === 401 && await refreshTokens()) {
+    response = await fetch(url, { ...options, headers: headers() })
+  }
+  return response
+}
+
 async function handleResponse(response) {
   if (response.status === 401) {
-    localStorage.removeItem('token')
+    clearTokens()
     window.location.href = '/login'
     throw new Error('Unauthorized')
   }
@@ -34,107 +69,209 @@ export const api = {
     return handleResponse(response)
   },
 
+  async getAuthConfig() {
+    const response = await fetch(`${API_BASE}/auth/config`)
+    return handleResponse(response)
+  },
+
+  async logout() {
+    try {
+      await fetch(`${API_BASE}/auth/logout`, {
+        method: 'POST',
+        headers: getAuthHeaders()
+      })
+    } finally {
+      clearTokens()
+    }
+  },
+
   async getStatus() {
-    const response = await fetch(`${API_BASE}/status`, {
-      headers: getAuthHeaders()
-    })
+    const response = await authFetch(`${API_BASE}/status`)
     return handleResponse(response)
   },
 
   async getDevices() {
-    const response = await fetch(`${API_BASE}/devices`, {
-      headers: getAuthHeaders()
-    })
+    const response = await authFetch(`${API_BASE}/devices`)
     return handleResponse(response)
   },
 
   async getManagedDevices() {
-    const response = await fetch(`${API_BASE}/devices/managed`, {
-      headers: getAuthHeaders()
-    })
+    const response = await authFetch(`${API_BASE}/devices/managed`)
     return handleResponse(response)
   },
 
   async getDeviceConfig(mac) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/config`, {
-      headers: getAuthHeaders()
-    })
+    const response = await authFetch(`${API_BASE}/devices/${mac}/config`)
     return handleResponse(response)
   },
 
   async saveDeviceConfig(mac, config) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/config`, {
+    const response = await authFetch(`${API_BASE}/devices/${mac}/config`, {
       method: 'POST',
-      headers: getAuthHeaders(),
       body: JSON.stringify(config)
     })
     return handleResponse(response)
   },
 
   async deleteDeviceConfig(mac) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/config`, {
-      method: 'DELETE',
-      headers: getAuthHeaders()
+    const response = await authFetch(`${API_BASE}/devices/${mac}/config`, {
+      method: 'DELETE'
     })
     return handleResponse(response)
   },
 
   async blockDevice(mac) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/block`, {
-      method: 'POST',
-      headers: getAuthHeaders()
+    const response = await authFetch(`${API_BASE}/devices/${mac}/block`, {
+      method: 'POST'
     })
     return handleResponse(response)
   },
 
   async unblockDevice(mac) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/unblock`, {
-      method: 'POST',
-      headers: getAuthHeaders()
+    const response = await authFetch(`${API_BASE}/devices/${mac}/unblock`, {
+      method: 'POST'
     })
     return handleResponse(response)
   },
 
   async addBonusTime(mac, minutes) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/add-time`, {
+    const response = await authFetch(`${API_BASE}/devices/${mac}/add-time`, {
       method: 'POST',
-      headers: getAuthHeaders(),
       body: JSON.stringify({ minutes })
     })
     return handleResponse(response)
   },
 
   async addBonusData(mac, amount, unit) {
-    const response = await fetch(`${API_BASE}/devices/${mac}/add-data`, {
+    const response = await authFetch(`${API_BASE}/devices/${mac}/add-data`, {
       method: 'POST',
-      headers: getAuthHeaders(),
       body: JSON.stringify({ amount, unit })
     })
     return handleResponse(response)
   },
 
-  async getAllUsage() {
-    const response = await fetch(`${API_BASE}/usage`, {
-      headers: getAuthHeaders()
+  async setOverride(mac, action, { minutes, until } = {}) {
+    const response = await authFetch(`${API_BASE}/devices/${mac}/override`, {
+      method: 'POST',
+      body: JSON.stringify({ action, minutes, until })
     })
     return handleResponse(response)
   },
 
-  async getDeviceUsage(mac) {
-    const response = await fetch(`${API_BASE}/usage/${mac}`, {
-      headers: getAuthHeaders()
+  async clearOverride(mac) {
+    const response = await authFetch(`${API_BASE}/devices/${mac}/override`, {
+      method: 'DELETE'
     })
     return handleResponse(response)
   },
 
+  async getAllUsage() {
+    const response = await authFetch(`${API_BASE}/usage`)
+    return handleResponse(response)
+  },
+
+  async getDeviceUsage(mac) {
+    const response = await authFetch(`${API_BASE}/usage/${mac}`)
+    return handleResponse(response)
+  },
+
   async getUsageHistory(mac, days = 30) {
-    const response = await fetch(`${API_BASE}/usage/${mac}/history?days=${days}`, {
-      headers: getAuthHeaders()
-    })
+    const response = await authFetch(`${API_BASE}/usage/${mac}/history?days=${days}`)
+    return handleResponse(response)
+  },
+
+  async getUsageTimeline(mac, { from, to, resolution = 'auto' } = {}) {
+    const params = new URLSearchParams({ resolution })
+    if (from) params.set('from', from)
+    if (to) params.set('to', to)
+    const response = await authFetch(`${API_BASE}/usage/${mac}/timeline?${params}`)
     return handleResponse(response)
+  },
+
+  async getUsageHeatmap(mac, weeks = 4) {
+    const response = await authFetch(`${API_BASE}/usage/${mac}/heatmap?weeks=${weeks}`)
+    return handleResponse(response)
+  },
+
+  async getWeeklyReport(mac, week) {
+    const query = week ? `?week=${week}` : ''
+    const response = await authFetch(`${API_BASE}/usage/${mac}/weekly${query}`)
+    return handleResponse(response)
+  },
+
+  // exportUsage downloads block usage records as a CSV or JSON Blob
+  async exportUsage({ format = 'csv', from, to, mac } = {}) {
+    const params = new URLSearchParams({ format })
+    if (from) params.set('from', from)
+    if (to) params.set('to', to)
+    if (mac) params.set('mac', mac)
+    const response = await authFetch(`${API_BASE}/export/usage?${params}`)
+    if (!response.ok) {
+      return handleResponse(response)
+    }
+    return response.blob()
   }
 }
 
+// subscribe streams live updates to onEvent(type, data) until the returned
+// function is called. It reconnects with the last event ID after errors, so
+// only events missed beyond the server's history produce a 'resync' event.
+export function subscribe(onEvent) {
+  const controller = new AbortController()
+  let lastEventId = ''
+  let retry = 3000
+
+  async function connect() {
+    const response = await authFetch(`${API_BASE}/stream`, {
+      signal: controller.signal,
+      headers: lastEventId ? { 'Last-Event-ID': lastEventId } : {}
+    })
+    if (!response.ok) throw new Error(`stream failed: ${response.status}`)
+
+    const reader = response.body.pipeThrough(new TextDecoderStream()).getReader()
+    let buffer = ''
+    for (;;) {
+      const { value, done } = await reader.read()
+      if (done) return
+      buffer += value
+
+      let end
+      while ((end = buffer.indexOf('\n\n')) >= 0) {
+        const frame = buffer.slice(0, end)
+        buffer = buffer.slice(end + 2)
+
+        let type = 'message', data = '', id = null
+        for (const line of frame.split('\n')) {
+          const sep = line.indexOf(':')
+          if (sep <= 0) continue
+          const field = line.slice(0, sep)
+          const val = line.slice(sep + 1).trimStart()
+          if (field === 'event') type = val
+          else if (field === 'data') data += val
+          else if (field === 'id') id = val
+          else if (field === 'retry') retry = parseInt(val, 10) || retry
+        }
+        if (id !== null) lastEventId = id
+        if (data) onEvent(type, JSON.parse(data))
+      }
+    }
+  }
+
+  ;(async () => {
+    while (!controller.signal.aborted) {
+      try {
+        await connect()
+      } catch (err) {
+        if (controller.signal.aborted) return
+        console.error('Live updates interrupted:', err)
+      }
+      await new Promise(resolve => setTimeout(resolve, retry))
+    }
+  })()
+
+  return () => controller.abort()
+}
+
 export function formatBytes(bytes) {
   if (bytes === 0) return '0 B'
   const k = 1024
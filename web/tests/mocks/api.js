@@ -5,7 +5,13 @@ export const mockResponses = {
   // Login response
   login: {
     token: 'mock-jwt-token-for-screenshots',
-    user: 'admin'
+    refresh_token: 'mock-refresh-token-for-screenshots',
+    expires_at: '2024-01-15T10:45:00Z'
+  },
+
+  // Available login methods
+  authConfig: {
+    oidc: false
   },
 
   // System status
@@ -269,6 +275,15 @@ export async function setupMockApi(page, scenario = 'default') {
       });
     }
 
+    // Login methods
+    if (url.includes('/auth/config') && method === 'GET') {
+      return route.fulfill({
+        status: 200,
+        contentType: 'application/json',
+        body: JSON.stringify(mockResponses.authConfig)
+      });
+    }
+
     // Status
     if (url.includes('/status') && method === 'GET') {
       const status = scenario === 'blocked'